@@ -0,0 +1,314 @@
+// The symbol construction in this file (layer selection, bit stuffing, check
+// words, module placement, mode message and bull's-eye) follows the Aztec
+// encoder of ZXing, com.google.zxing.aztec.encoder.Encoder:
+//
+// Copyright 2013 ZXing authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// Modified: ported to Go, high-level encoding reduced to binary shift.
+
+package main
+
+import (
+	"fmt"
+)
+
+const (
+	aztecECCPercent  = 23
+	aztecMaxLayers   = 32
+	aztecBinaryShift = 31
+)
+
+// aztecWordSizes is the codeword size in bits by layer count.
+var aztecWordSizes = [...]int{4, 6, 6, 8, 8, 8, 8, 8, 8, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10,
+	12, 12, 12, 12, 12, 12, 12, 12, 12, 12}
+
+var aztecFields = map[int]*galoisField{
+	4:  newGaloisField(0x13, 16),
+	6:  newGaloisField(0x43, 64),
+	8:  newGaloisField(0x12d, 256),
+	10: newGaloisField(0x409, 1024),
+	12: newGaloisField(0x1069, 4096),
+}
+
+// bitBuffer collects bits most significant first.
+type bitBuffer []bool
+
+func (b *bitBuffer) append(value, bits int) {
+	for i := bits - 1; i >= 0; i-- {
+		*b = append(*b, value&(1<<uint(i)) != 0)
+	}
+}
+
+// encodeAztec encodes data as the smallest compact or full-range Aztec
+// symbol with at least 23% error correction. The bytes are sent in binary
+// shift mode, which every reader supports.
+func encodeAztec(data string) ([][]bool, error) {
+	if data == "" {
+		return nil, fmt.Errorf("empty barcode data")
+	}
+	var bits bitBuffer
+	raw := []byte(data)
+	for len(raw) > 0 {
+		n := minInt(len(raw), 2078)
+		bits.append(aztecBinaryShift, 5)
+		if n <= 31 {
+			bits.append(n, 5)
+		} else {
+			bits.append(0, 5)
+			bits.append(n-31, 11)
+		}
+		for _, c := range raw[:n] {
+			bits.append(int(c), 8)
+		}
+		raw = raw[n:]
+	}
+	symbol, err := aztecSymbol(bits, aztecECCPercent)
+	if err != nil {
+		return nil, fmt.Errorf("aztec data too long (%d bytes)", len(data))
+	}
+	return symbol, nil
+}
+
+// aztecSymbol places an encoded bit stream in the smallest symbol that
+// leaves eccPercent of it, plus 11 bits, for error correction.
+func aztecSymbol(bits bitBuffer, eccPercent int) ([][]bool, error) {
+	eccBits := len(bits)*eccPercent/100 + 11
+	var stuffed bitBuffer
+	compact, layers, wordSize := false, 0, 0
+	for i := 0; ; i++ {
+		if i > aztecMaxLayers {
+			return nil, fmt.Errorf("aztec data too long (%d bits)", len(bits))
+		}
+		compact = i <= 3
+		layers = i
+		if compact {
+			layers = i + 1
+		}
+		total := aztecLayerBits(layers, compact)
+		if len(bits)+eccBits > total {
+			continue
+		}
+		if stuffed == nil || wordSize != aztecWordSizes[layers] {
+			wordSize = aztecWordSizes[layers]
+			stuffed = aztecStuffBits(bits, wordSize)
+		}
+		usable := total - total%wordSize
+		if compact && len(stuffed) > wordSize*64 {
+			continue
+		}
+		if len(stuffed)+eccBits <= usable {
+			break
+		}
+	}
+
+	total := aztecLayerBits(layers, compact)
+	messageWords := len(stuffed) / wordSize
+	message := aztecCheckWords(stuffed, total, wordSize)
+
+	mode := aztecModeMessage(compact, layers, messageWords)
+	return aztecMatrix(message, mode, layers, compact), nil
+}
+
+// aztecModeMessage encodes the layer and data word counts with their check
+// words: 28 bits for compact symbols, 40 for full-range ones.
+func aztecModeMessage(compact bool, layers, messageWords int) bitBuffer {
+	var mode bitBuffer
+	if compact {
+		mode.append(layers-1, 2)
+		mode.append(messageWords-1, 6)
+		return aztecCheckWords(mode, 28, 4)
+	}
+	mode.append(layers-1, 5)
+	mode.append(messageWords-1, 11)
+	return aztecCheckWords(mode, 40, 4)
+}
+
+func aztecLayerBits(layers int, compact bool) int {
+	if compact {
+		return (88 + 16*layers) * layers
+	}
+	return (112 + 16*layers) * layers
+}
+
+// aztecStuffBits splits bits into codewords, inserting a complementary bit
+// wherever a codeword would be all zeros or all ones. The last word is
+// padded with ones.
+func aztecStuffBits(bits bitBuffer, wordSize int) bitBuffer {
+	var out bitBuffer
+	mask := (1 << uint(wordSize)) - 2
+	for i := 0; i < len(bits); i += wordSize {
+		word := 0
+		for j := 0; j < wordSize; j++ {
+			if i+j >= len(bits) || bits[i+j] {
+				word |= 1 << uint(wordSize-1-j)
+			}
+		}
+		switch {
+		case word&mask == mask:
+			out.append(word&mask, wordSize)
+			i--
+		case word&mask == 0:
+			out.append(word|1, wordSize)
+			i--
+		default:
+			out.append(word, wordSize)
+		}
+	}
+	return out
+}
+
+// aztecCheckWords appends Reed-Solomon words to bits so that the result fills
+// total bits, with any remainder as leading zero padding.
+func aztecCheckWords(bits bitBuffer, total, wordSize int) bitBuffer {
+	words := make([]int, len(bits)/wordSize)
+	for i := range words {
+		for j := 0; j < wordSize; j++ {
+			words[i] <<= 1
+			if bits[i*wordSize+j] {
+				words[i] |= 1
+			}
+		}
+	}
+	words = append(words, aztecFields[wordSize].rsEncode(words, total/wordSize-len(words))...)
+
+	var out bitBuffer
+	out.append(0, total%wordSize)
+	for _, word := range words {
+		out.append(word, wordSize)
+	}
+	return out
+}
+
+func aztecMatrix(message, mode bitBuffer, layers int, compact bool) [][]bool {
+	baseSize := 14 + layers*4
+	if compact {
+		baseSize = 11 + layers*4
+	}
+	alignment := make([]int, baseSize)
+	size := baseSize
+	if compact {
+		for i := range alignment {
+			alignment[i] = i
+		}
+	} else {
+		// Full symbols have a reference grid line every 16 modules.
+		size = baseSize + 1 + 2*((baseSize/2-1)/15)
+		origCenter, center := baseSize/2, size/2
+		for i := 0; i < origCenter; i++ {
+			offset := i + i/15
+			alignment[origCenter-i-1] = center - offset - 1
+			alignment[origCenter+i] = center + offset + 1
+		}
+	}
+
+	matrix := make([][]bool, size)
+	for y := range matrix {
+		matrix[y] = make([]bool, size)
+	}
+	set := func(x, y int) { matrix[y][x] = true }
+
+	// Layers are filled from the outside in, two modules deep, starting at
+	// the top-left corner and turning clockwise.
+	rowOffset := 0
+	for i := 0; i < layers; i++ {
+		rowSize := (layers-i)*4 + 12
+		if compact {
+			rowSize = (layers-i)*4 + 9
+		}
+		for j := 0; j < rowSize; j++ {
+			columnOffset := j * 2
+			for k := 0; k < 2; k++ {
+				if message[rowOffset+columnOffset+k] {
+					set(alignment[i*2+k], alignment[i*2+j])
+				}
+				if message[rowOffset+rowSize*2+columnOffset+k] {
+					set(alignment[i*2+j], alignment[baseSize-1-i*2-k])
+				}
+				if message[rowOffset+rowSize*4+columnOffset+k] {
+					set(alignment[baseSize-1-i*2-k], alignment[baseSize-1-i*2-j])
+				}
+				if message[rowOffset+rowSize*6+columnOffset+k] {
+					set(alignment[baseSize-1-i*2-j], alignment[i*2+k])
+				}
+			}
+		}
+		rowOffset += rowSize * 8
+	}
+
+	center := size / 2
+	if compact {
+		for i := 0; i < 7; i++ {
+			offset := center - 3 + i
+			if mode[i] {
+				set(offset, center-5)
+			}
+			if mode[i+7] {
+				set(center+5, offset)
+			}
+			if mode[20-i] {
+				set(offset, center+5)
+			}
+			if mode[27-i] {
+				set(center-5, offset)
+			}
+		}
+		aztecBullsEye(set, center, 5)
+	} else {
+		for i := 0; i < 10; i++ {
+			offset := center - 5 + i + i/5
+			if mode[i] {
+				set(offset, center-7)
+			}
+			if mode[i+10] {
+				set(center+7, offset)
+			}
+			if mode[29-i] {
+				set(offset, center+7)
+			}
+			if mode[39-i] {
+				set(center-7, offset)
+			}
+		}
+		aztecBullsEye(set, center, 7)
+		for i, j := 0, 0; i < baseSize/2-1; i, j = i+15, j+16 {
+			for k := center & 1; k < size; k += 2 {
+				set(center-j, k)
+				set(center+j, k)
+				set(k, center-j)
+				set(k, center+j)
+			}
+		}
+	}
+	return matrix
+}
+
+// aztecBullsEye draws the concentric finder squares and the orientation
+// marks at the corners of the mode message ring.
+func aztecBullsEye(set func(x, y int), center, size int) {
+	for i := 0; i < size; i += 2 {
+		for j := center - i; j <= center+i; j++ {
+			set(j, center-i)
+			set(j, center+i)
+			set(center-i, j)
+			set(center+i, j)
+		}
+	}
+	set(center-size, center-size)
+	set(center-size+1, center-size)
+	set(center-size, center-size+1)
+	set(center+size, center-size)
+	set(center+size, center-size+1)
+	set(center+size, center+size-1)
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func bitsFromString(s string) bitBuffer {
+	var bits bitBuffer
+	for _, c := range s {
+		switch c {
+		case '1', 'X':
+			bits = append(bits, true)
+		case '0', '.':
+			bits = append(bits, false)
+		}
+	}
+	return bits
+}
+
+// TestAztecSymbolReference places ZXing's high-level encoding of two texts
+// and compares the result with the reference symbols of ZXing's encoder
+// tests, which use 33% error correction.
+func TestAztecSymbolReference(t *testing.T) {
+	tests := []struct {
+		name string
+		bits string
+		want string
+	}{
+		{"compact", "" +
+			"1010111100010010101010100000010101010100000010001001111000010011" +
+			"0110010001001110100010110100110000011110000010110111010100110001" +
+			"0000001101001101001110000111000001101000010011110000100110000111" +
+			"100110000101001100010101000100110001010101000010111101101",
+			"" +
+				"#..##...#..##..#..#....\n" +
+				"#....#..##..#.##.##...#\n" +
+				"##.#####.###........#..\n" +
+				"##........##.#...######\n" +
+				"..###.#.#..####....##..\n" +
+				".###.####.#..#.#..##.#.\n" +
+				"....#####..####.#.#..#.\n" +
+				"#...#.###########..#.##\n" +
+				"#.#..###.......####.##.\n" +
+				"#..##.##.#####.##.#.###\n" +
+				"#.#....#.#...#.####...#\n" +
+				"#...#..#.#.#.#.#.##.#..\n" +
+				"...#.###.#...#.#..###..\n" +
+				"..######.#####.######.#\n" +
+				".##.#.##.......###.####\n" +
+				".#.#...############.##.\n" +
+				".##.#...###.###...##...\n" +
+				".#.......#.##..#..###..\n" +
+				".#.###.##.#.####.#.####\n" +
+				"..#.#.###.#.#.####..#..\n" +
+				"....#.......#........#.\n" +
+				"....##..#.##.#.#.#...##\n" +
+				".#.#.##...#.#....###..#\n"},
+		{"full range", "" +
+			"0001011100110111010100110001000000111100001001000000101001100000" +
+			"1010101010000001000100000110001101100001101101010100010000001001" +
+			"0110000011100001001010011111111000010100111000101000011110001110" +
+			"0001010101100110101011001000010001100010100110010010000001010011" +
+			"0000011010011010011100001110000011011000001000110100000110000001" +
+			"1100001011111000001110010100111100010011010110111010000011010010" +
+			"0101011000010100110011000001101001101001110000111000001101101000" +
+			"0001000111011001010011011010100001100000111100001000100000110100" +
+			"1001010110000101001100110000010100010011010100010100001110000101" +
+			"0101010100100001000100000100101010101010010101010100111100100101" +
+			"0101010101110011000001101001001010110000101001100110000010001110" +
+			"1100110101101101000011011010001100000110001000101010110101001101" +
+			"0011011110000100010101010000110101010010011001010100110000100100" +
+			"0011001111101010011010011111101101",
+			"" +
+				"....##..##..#..#..#.###....#.#....#.##...\n" +
+				".#...##..#.##.##...#......#..#.##.#.....#\n" +
+				".#.###..#.#.##..###.#.##.......##...##..#\n" +
+				"###......#.#....#....#..#..#.#..##...#.#.\n" +
+				"#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#\n" +
+				"..##.#.#.###.......#...#...##..##.##...#.\n" +
+				"##..#...#...####.#.##...#.##.#...##.##.#.\n" +
+				".#...#.#..##.#.##.##.######.##.....#.#.##\n" +
+				"##.##.#.####.########.#.#...##.####.###..\n" +
+				".#...#.#..#...##..##.#.#.#..##.###.#..###\n" +
+				".#.###.##...###....##.....#.#.#.###.##..#\n" +
+				"..#..#.##..####..#.#..####.##.##.###..#.#\n" +
+				"###.#......#....#####.#.##.#.#.##.#.#.#.#\n" +
+				".....#...###.##..#.#.....#.####.##.......\n" +
+				".#..##.#.#...###############.#.##.#.###..\n" +
+				"..##........#.#...........##.#...#....###\n" +
+				"....#.##.######.#########.#..##.....####.\n" +
+				".....###.#..#.#.#.......#.##..###.##.....\n" +
+				"##..#..#.#.#.##.#.#####.#.#######...#.###\n" +
+				"####...#...#.##.#.#...#.#.#..###..##...##\n" +
+				"#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#\n" +
+				"..#..#...#....#.#.#...#.#.#..#.##........\n" +
+				"....##..#####.#.#.#####.#.###..####.#....\n" +
+				"#..#.#.#....#.#.#.......#.##.##.###..#.#.\n" +
+				".#.###.#.##.###.#########.##....##..####.\n" +
+				"..##.#.#.###..#...........###.##.#.#..#..\n" +
+				"..####.#....#.##############.#...##.##.##\n" +
+				"......#.#.##...#####..###...#...###....#.\n" +
+				"#...#....#.####.#..##..##..##.....#.#...#\n" +
+				"#..#...#####..#.####.###..#...####.#.##.#\n" +
+				".#####.......#..###.#...##.##.####..##...\n" +
+				"#......#....#.##.##..#..#..#.#.####......\n" +
+				"..#.##...#..#...#.######.##.#########.#.#\n" +
+				"..#....##.#...#..#.#.#...#..###..#...####\n" +
+				"#..##..######......###.#.......#.#..#..##\n" +
+				"#.##..#.......#####..##..########..#.#.##\n" +
+				"#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#\n" +
+				"#.....#..####..#..#....#....#.#...##.###.\n" +
+				"#.#.##.###.#....##..####..##.#.#..#.#...#\n" +
+				"...#..#..#..##..#.##.##.#....##...#...#.#\n" +
+				"#...#.....#.#.#..##.#.......#..#..###....\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matrix, err := aztecSymbol(bitsFromString(tt.bits), 33)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := matrixString(matrix); got != tt.want {
+				t.Errorf("symbol =\n%swant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// The references below were produced with an independent port of the ZXing
+// encoder (github.com/boombuler/barcode/aztec) fed the same binary shift
+// encoding.
+func TestEncodeAztec(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"A", "" +
+			"...##..###....#\n" +
+			"####.##.#.###.#\n" +
+			"####......#.##.\n" +
+			"#.#############\n" +
+			"..##.......###.\n" +
+			"..##.#####.##..\n" +
+			"#..#.#...#.####\n" +
+			"#..#.#.#.#.####\n" +
+			"...#.#...#.#.##\n" +
+			"..##.#####.##..\n" +
+			"####.......##.#\n" +
+			"##.############\n" +
+			"#.....#.#....#.\n" +
+			"..#.#.####.###.\n" +
+			".#..#.##...###.\n"},
+		{"https://inv.example/item/000-029", "" +
+			"##..##...#..##...#..##.\n" +
+			"###.#.#..####.##..#..##\n" +
+			"#.#.######.#.####.##.#.\n" +
+			"...##.####.#..#.##.#.#.\n" +
+			"...##.#..###....##..#.#\n" +
+			"....##...#..#####....#.\n" +
+			"..#.#####.#...#.##.##.#\n" +
+			".###.############....##\n" +
+			"..##.#.#.......#......#\n" +
+			"...#.#.#.#####.##......\n" +
+			".##.##.#.#...#.######.#\n" +
+			".##....#.#.#.#.###...#.\n" +
+			"#..##..#.#...#.#.###.##\n" +
+			"#..#..##.#####.###.###.\n" +
+			"..###..#.......#.#..#.#\n" +
+			".#.#.#.############..##\n" +
+			"##...#..####.#...#....#\n" +
+			".#.#####.#..##..###..#.\n" +
+			"..#.#..###..##.##.####.\n" +
+			".####.##..##.#...#....#\n" +
+			"##...##..###.#...#....#\n" +
+			"#.##..##.#.#....##..##.\n" +
+			"...#...#.#.###.###.####\n"},
+	}
+	for _, tt := range tests {
+		matrix, err := encodeAztec(tt.in)
+		if err != nil {
+			t.Fatalf("encodeAztec(%q): %v", tt.in, err)
+		}
+		if got := matrixString(matrix); got != tt.want {
+			t.Errorf("encodeAztec(%q) =\n%swant\n%s", tt.in, got, tt.want)
+		}
+	}
+}
+
+// Full-range symbols with reference grid lines, compared by digest.
+func TestEncodeAztecLarge(t *testing.T) {
+	tests := []struct {
+		in     string
+		size   int
+		digest string
+	}{
+		{strings.Repeat("x", 600), 87, "be128b1a6a041b62a7a3132a0d5af680bb1733a9a33137e7e01d8fa60fa2f345"},
+		{strings.Repeat("Z", 1900), 147, "35ea5120aee0d8e11e4974e200d3a58c0e45f75c42d79fb1dcc4ea3e8a70c591"},
+	}
+	for _, tt := range tests {
+		matrix, err := encodeAztec(tt.in)
+		if err != nil {
+			t.Fatalf("encodeAztec: %v", err)
+		}
+		if len(matrix) != tt.size {
+			t.Errorf("%d-byte input: %dx%d symbol, want %dx%d", len(tt.in), len(matrix), len(matrix), tt.size, tt.size)
+			continue
+		}
+		if got := matrixDigest(matrix); got != tt.digest {
+			t.Errorf("%d-byte input: digest %s, want %s", len(tt.in), got, tt.digest)
+		}
+	}
+}
+
+// Bit stuffing vectors from ZXing's encoder tests.
+func TestAztecStuffBits(t *testing.T) {
+	tests := []struct {
+		wordSize int
+		in, want string
+	}{
+		{5, ".X.X. X.X.X .X.X.", ".X.X. X.X.X .X.X."},
+		{5, ".X.X. ..... .X.X", ".X.X. ....X ..X.X"},
+		{3, "XX. ... ... ..X XXX .X. ..", "XX. ..X ..X ..X ..X .XX XX. .X. ..X"},
+		{6, ".X.X.. ...... ..X.XX", ".X.X.. .....X. ..X.XX XXXX."},
+		{6, ".X.X.. ...... ...... ..X.X.", ".X.X.. .....X .....X ....X. X.XXXX"},
+		{6, ".X.X.. XXXXXX ...... ..X.XX", ".X.X.. XXXXX. X..... ...X.X XXXXX."},
+		{6,
+			"...... ..XXXX X..XX. .X.... .X.X.X .....X .X.... ...X.X .....X ....XX ..X... ....X. X..XXX X.XX.X",
+			".....X ...XXX XX..XX ..X... ..X.X. X..... X.X... ....X. X..... X....X X..X.. .....X X.X..X XXX.XX .XXXXX"},
+	}
+	for _, tt := range tests {
+		got := aztecStuffBits(bitsFromString(tt.in), tt.wordSize)
+		if want := bitsFromString(tt.want); bitString(got) != bitString(want) {
+			t.Errorf("aztecStuffBits(%q, %d) = %s, want %s", tt.in, tt.wordSize, bitString(got), bitString(want))
+		}
+	}
+}
+
+// Mode message vectors from ZXing's encoder tests; the check words use
+// Reed-Solomon over GF(16).
+func TestAztecModeMessage(t *testing.T) {
+	tests := []struct {
+		compact       bool
+		layers, words int
+		want          string
+	}{
+		{true, 2, 29, ".X .XXX.. ...X XX.. ..X .XX. .XX.X"},
+		{true, 4, 64, "XX XXXXXX .X.. ...X ..XX .X.. XX.."},
+		{false, 21, 660, "X.X.. .X.X..X..XX .XXX ..X.. .XXX. .X... ..XXX"},
+		{false, 32, 4096, "XXXXX XXXXXXXXXXX X.X. ..... XXX.X ..X.. X.XXX"},
+	}
+	for _, tt := range tests {
+		got := aztecModeMessage(tt.compact, tt.layers, tt.words)
+		if want := bitsFromString(tt.want); bitString(got) != bitString(want) {
+			t.Errorf("aztecModeMessage(%v, %d, %d) = %s, want %s", tt.compact, tt.layers, tt.words, bitString(got), bitString(want))
+		}
+	}
+}
+
+func TestEncodeAztecErrors(t *testing.T) {
+	if _, err := encodeAztec(""); err == nil {
+		t.Error("empty data: no error")
+	}
+	if _, err := encodeAztec(strings.Repeat("x", 4000)); err == nil {
+		t.Error("4000 bytes: no error")
+	}
+}
+
+func bitString(bits bitBuffer) string {
+	var b strings.Builder
+	for _, bit := range bits {
+		if bit {
+			b.WriteByte('X')
+		} else {
+			b.WriteByte('.')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+var code128Patterns = [...]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+const (
+	code128CodeC  = 99
+	code128CodeB  = 100
+	code128StartB = 104
+	code128StartC = 105
+	code128Stop   = 106
+)
+
+const (
+	symbologyQR         = "qr"
+	symbologyCode128    = "code128"
+	symbologyCode39     = "code39"
+	symbologyEAN13      = "ean13"
+	symbologyDataMatrix = "datamatrix"
+	symbologyAztec      = "aztec"
+)
+
+func lookupSymbology(name string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "qr", "qrcode":
+		return symbologyQR, true
+	case "code128", "code-128":
+		return symbologyCode128, true
+	case "code39", "code-39":
+		return symbologyCode39, true
+	case "ean13", "ean-13":
+		return symbologyEAN13, true
+	case "datamatrix", "data-matrix":
+		return symbologyDataMatrix, true
+	case "aztec":
+		return symbologyAztec, true
+	}
+	return "", false
+}
+
+// isLinearSymbology reports whether symbology is a 1D barcode.
+func isLinearSymbology(symbology string) bool {
+	switch symbology {
+	case symbologyCode128, symbologyCode39, symbologyEAN13:
+		return true
+	}
+	return false
+}
+
+func encodeBarcode(symbology, data string) ([]bool, error) {
+	switch symbology {
+	case symbologyCode128:
+		return encodeCode128(data)
+	case symbologyCode39:
+		return encodeCode39(data)
+	case symbologyEAN13:
+		return encodeEAN13(data)
+	}
+	return nil, errors.New("unsupported barcode symbology")
+}
+
+// codeOptions are the per-label settings for machine-readable codes.
+type codeOptions struct {
+	qrLevel   qrcode.RecoveryLevel
+	quietZone int
+	snapQR    bool
+}
+
+func (p labelParams) codeOptions() codeOptions {
+	return codeOptions{qrLevel: p.qrLevel, quietZone: p.qrQuietZone, snapQR: p.qrSnap}
+}
+
+// snapQRSize returns the largest size up to size at which the QR code for
+// data, including its quiet zone, has a whole number of pixels per module.
+func snapQRSize(data string, opts codeOptions, size int) (snapped, module int, err error) {
+	qr, err := qrcode.New(data, opts.qrLevel)
+	if err != nil {
+		return 0, 0, err
+	}
+	qr.DisableBorder = true
+	modules := len(qr.Bitmap()) + 2*opts.quietZone
+	module, err = qrModuleSize(modules, size)
+	return module * modules, module, err
+}
+
+func qrModuleSize(modules, size int) (int, error) {
+	if size < modules {
+		return 0, fmt.Errorf("QR code needs %d pixels, only %d available", modules, size)
+	}
+	return size / modules, nil
+}
+
+// newCodeElement builds the machine-readable element for data inside the
+// w x h box at (x, y). 2D symbols are square and use the smaller side;
+// linear barcodes fill the box and fail if their modules do not fit. The
+// quiet zone, in modules, is kept blank inside the box. Snapped QR codes sit
+// in the top-left corner of the box.
+func newCodeElement(symbology, data string, opts codeOptions, x, y, w, h int) (labelElement, error) {
+	size := minInt(w, h)
+	el := labelElement{x: x, y: y, width: size, height: size, data: data, symbology: symbology}
+	modules := 0
+	switch symbology {
+	case symbologyQR:
+		qr, err := qrcode.New(data, opts.qrLevel)
+		if err != nil {
+			logError("QR code creation failed: %v", err)
+			return el, err
+		}
+		qr.DisableBorder = true
+		el.kind = elementQR
+		el.qr = qr
+		if opts.snapQR {
+			modules := len(qr.Bitmap())
+			module, err := qrModuleSize(modules+2*opts.quietZone, size)
+			if err != nil {
+				return el, err
+			}
+			logDebug("QR code: %d modules at %dpx per module", modules, module)
+			el.qrModule = module
+			el.x, el.y = x+module*opts.quietZone, y+module*opts.quietZone
+			el.width, el.height = module*modules, module*modules
+			return el, nil
+		}
+		if opts.quietZone > 0 {
+			modules = len(qr.Bitmap())
+		}
+	case symbologyDataMatrix, symbologyAztec:
+		encode := encodeDataMatrix
+		if symbology == symbologyAztec {
+			encode = encodeAztec
+		}
+		matrix, err := encode(data)
+		if err != nil {
+			return el, err
+		}
+		el.kind = elementMatrix
+		el.matrix = matrix
+		modules = len(matrix)
+	default:
+		bars, err := encodeBarcode(symbology, data)
+		if err != nil {
+			return el, err
+		}
+		total := len(bars) + 2*opts.quietZone
+		if total > w {
+			return el, fmt.Errorf("%s barcode needs %d pixels, only %d available", symbology, total, w)
+		}
+		quiet := opts.quietZone * (w / total)
+		el.kind = elementBarcode
+		el.x, el.width, el.height = x+quiet, w-2*quiet, h
+		el.modules = bars
+		return el, nil
+	}
+
+	if opts.quietZone > 0 {
+		quiet := int(math.Round(float64(opts.quietZone*size) / float64(modules+2*opts.quietZone)))
+		if size-2*quiet < modules {
+			return el, fmt.Errorf("%s code with a %d module quiet zone does not fit in %d pixels", symbology, opts.quietZone, size)
+		}
+		el.x, el.y = x+quiet, y+quiet
+		el.width, el.height = size-2*quiet, size-2*quiet
+	}
+	return el, nil
+}
+
+// encodeCode128 encodes printable ASCII using code set B, switching to code
+// set C for runs of four or more digits.
+func encodeCode128(data string) ([]bool, error) {
+	if data == "" {
+		return nil, errors.New("empty barcode data")
+	}
+	for i := 0; i < len(data); i++ {
+		if data[i] < 32 || data[i] > 126 {
+			return nil, errors.New("code128 supports printable ASCII only")
+		}
+	}
+
+	var codes []int
+	inC := false
+	i := 0
+	if digitRun(data, 0) >= 4 {
+		codes = append(codes, code128StartC)
+		inC = true
+	} else {
+		codes = append(codes, code128StartB)
+	}
+	for i < len(data) {
+		run := digitRun(data, i)
+		switch {
+		case inC && run >= 2:
+			codes = append(codes, int(data[i]-'0')*10+int(data[i+1]-'0'))
+			i += 2
+		case inC:
+			codes = append(codes, code128CodeB)
+			inC = false
+		case run >= 4:
+			if run%2 == 1 {
+				codes = append(codes, int(data[i])-32)
+				i++
+			}
+			codes = append(codes, code128CodeC)
+			inC = true
+		default:
+			codes = append(codes, int(data[i])-32)
+			i++
+		}
+	}
+
+	checksum := codes[0]
+	for pos, code := range codes[1:] {
+		checksum += (pos + 1) * code
+	}
+	codes = append(codes, checksum%103, code128Stop)
+
+	var modules []bool
+	for _, code := range codes {
+		bar := true
+		for _, w := range code128Patterns[code] {
+			for n := 0; n < int(w-'0'); n++ {
+				modules = append(modules, bar)
+			}
+			bar = !bar
+		}
+	}
+	return modules, nil
+}
+
+var code39Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ-. $/+%"
+
+// code39Patterns lists bar/space widths (narrow or wide) of each character in
+// code39Chars, followed by the start/stop character.
+var code39Patterns = [...]string{
+	"nnnwwnwnn", "wnnwnnnnw", "nnwwnnnnw", "wnwwnnnnn", "nnnwwnnnw", "wnnwwnnnn", "nnwwwnnnn", "nnnwnnwnw", "wnnwnnwnn", "nnwwnnwnn",
+	"wnnnnwnnw", "nnwnnwnnw", "wnwnnwnnn", "nnnnwwnnw", "wnnnwwnnn", "nnwnwwnnn", "nnnnnwwnw", "wnnnnwwnn", "nnwnnwwnn", "nnnnwwwnn",
+	"wnnnnnnww", "nnwnnnnww", "wnwnnnnwn", "nnnnwnnww", "wnnnwnnwn", "nnwnwnnwn", "nnnnnnwww", "wnnnnnwwn", "nnwnnnwwn", "nnnnwnwwn",
+	"wwnnnnnnw", "nwwnnnnnw", "wwwnnnnnn", "nwnnwnnnw", "wwnnwnnnn", "nwwnwnnnn", "nwnnnnwnw", "wwnnnnwnn", "nwwnnnwnn", "nwnwnwnnn",
+	"nwnwnnnwn", "nwnnnwnwn", "nnnwnwnwn", "nwnnwnwnn",
+}
+
+// encodeCode39 encodes upper-case letters, digits and "-. $/+%" between
+// start/stop characters, with wide elements three modules wide. Lower-case
+// letters are upper-cased.
+func encodeCode39(data string) ([]bool, error) {
+	if data == "" {
+		return nil, errors.New("empty barcode data")
+	}
+	data = strings.ToUpper(data)
+	indexes := []int{len(code39Patterns) - 1}
+	for _, r := range data {
+		i := strings.IndexRune(code39Chars, r)
+		if i < 0 {
+			return nil, fmt.Errorf("code39 cannot encode %q", r)
+		}
+		indexes = append(indexes, i)
+	}
+	indexes = append(indexes, len(code39Patterns)-1)
+
+	var modules []bool
+	for n, index := range indexes {
+		if n > 0 {
+			modules = append(modules, false) // inter-character gap
+		}
+		for i, element := range code39Patterns[index] {
+			width := 1
+			if element == 'w' {
+				width = 3
+			}
+			for j := 0; j < width; j++ {
+				modules = append(modules, i%2 == 0)
+			}
+		}
+	}
+	return modules, nil
+}
+
+// ean13LCodes are the odd-parity left-hand patterns; right-hand patterns are
+// their complement and even-parity ones the reversed complement.
+var ean13LCodes = [...]string{"0001101", "0011001", "0010011", "0111101", "0100011", "0110001", "0101111", "0111011", "0110111", "0001011"}
+
+// ean13Parity selects odd (L) or even (G) parity for the left six digits by
+// the first digit.
+var ean13Parity = [...]string{"LLLLLL", "LLGLGG", "LLGGLG", "LLGGGL", "LGLLGG", "LGGLLG", "LGGGLL", "LGLGLG", "LGLGGL", "LGGLGL"}
+
+// encodeEAN13 encodes 12 digits plus the computed check digit, or 13 digits
+// whose check digit must be correct.
+func encodeEAN13(data string) ([]bool, error) {
+	if len(data) != 12 && len(data) != 13 || digitRun(data, 0) != len(data) {
+		return nil, errors.New("ean13 needs 12 or 13 digits")
+	}
+	sum := 0
+	for i := 0; i < 12; i++ {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += int(data[i]-'0') * weight
+	}
+	check := byte('0' + (10-sum%10)%10)
+	if len(data) == 13 && data[12] != check {
+		return nil, fmt.Errorf("ean13 check digit should be %c", check)
+	}
+	digits := data[:12] + string(check)
+
+	pattern := "101"
+	parity := ean13Parity[digits[0]-'0']
+	for i := 1; i <= 6; i++ {
+		code := ean13LCodes[digits[i]-'0']
+		if parity[i-1] == 'G' {
+			code = reverseString(invertBits(code))
+		}
+		pattern += code
+	}
+	pattern += "01010"
+	for i := 7; i <= 12; i++ {
+		pattern += invertBits(ean13LCodes[digits[i]-'0'])
+	}
+	pattern += "101"
+
+	modules := make([]bool, len(pattern))
+	for i := range pattern {
+		modules[i] = pattern[i] == '1'
+	}
+	return modules, nil
+}
+
+func invertBits(bits string) string {
+	out := []byte(bits)
+	for i, b := range out {
+		if b == '0' {
+			out[i] = '1'
+		} else {
+			out[i] = '0'
+		}
+	}
+	return string(out)
+}
+
+func reverseString(value string) string {
+	out := []byte(value)
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+func digitRun(data string, start int) int {
+	n := 0
+	for i := start; i < len(data) && data[i] >= '0' && data[i] <= '9'; i++ {
+		n++
+	}
+	return n
+}
+
+// barcodeModuleWidth returns the whole-pixel module width that fits the
+// symbol into width, never less than one pixel.
+func barcodeModuleWidth(modules []bool, width int) int {
+	if len(modules) == 0 {
+		return 0
+	}
+	return maxInt(1, width/len(modules))
+}
+
+// matrixModule returns the whole-pixel module size and centring offset of an
+// n-module 2D symbol drawn into a size x size square.
+func matrixModule(n, size int) (module, offset int) {
+	if n == 0 {
+		return 0, 0
+	}
+	module = maxInt(1, size/n)
+	return module, maxInt(0, (size-module*n)/2)
+}
+
+func drawMatrix(img *image.RGBA, matrix [][]bool, x, y, size int) {
+	module, offset := matrixModule(len(matrix), size)
+	for row, cells := range matrix {
+		for col, dark := range cells {
+			if dark {
+				fillRect(img, x+offset+col*module, y+offset+row*module, module, module, color.Black)
+			}
+		}
+	}
+}
+
+func drawBarcode(img *image.RGBA, modules []bool, x, y, w, h int) {
+	module := barcodeModuleWidth(modules, w)
+	if module == 0 || h <= 0 {
+		return
+	}
+	offsetX := x + maxInt(0, (w-module*len(modules))/2)
+	for i, dark := range modules {
+		if dark {
+			fillRect(img, offsetX+i*module, y, module, h, color.Black)
+		}
+	}
+}
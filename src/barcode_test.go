@@ -0,0 +1,221 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// moduleBits writes a linear symbol as '1' for bars and '0' for spaces.
+func moduleBits(modules []bool) string {
+	var b strings.Builder
+	for _, bar := range modules {
+		if bar {
+			b.WriteByte('1')
+		} else {
+			b.WriteByte('0')
+		}
+	}
+	return b.String()
+}
+
+// code128Values decodes a Code 128 symbol back into its symbol values.
+func code128Values(t *testing.T, modules []bool) []int {
+	t.Helper()
+	patterns := map[string]int{}
+	for value, pattern := range code128Patterns {
+		patterns[pattern] = value
+	}
+	var widths []byte
+	for i := 0; i < len(modules); {
+		n := 1
+		for i+n < len(modules) && modules[i+n] == modules[i] {
+			n++
+		}
+		widths = append(widths, byte('0'+n))
+		i += n
+	}
+	var values []int
+	for len(widths) > 0 {
+		size := 6
+		if len(widths) == 7 {
+			size = 7 // stop pattern
+		}
+		if len(widths) < size {
+			t.Fatalf("trailing elements %q", widths)
+		}
+		value, ok := patterns[string(widths[:size])]
+		if !ok {
+			t.Fatalf("unknown pattern %q", widths[:size])
+		}
+		values = append(values, value)
+		widths = widths[size:]
+	}
+	return values
+}
+
+func TestCode128Patterns(t *testing.T) {
+	if len(code128Patterns) != 107 {
+		t.Fatalf("%d patterns, want 107", len(code128Patterns))
+	}
+	// Entries from the ISO/IEC 15417 table.
+	for value, want := range map[int]string{0: "212222", 1: "222122", 2: "222221", 99: "113141", 100: "114131", 103: "211412", 104: "211214", 105: "211232", 106: "2331112"} {
+		if got := code128Patterns[value]; got != want {
+			t.Errorf("pattern %d = %s, want %s", value, got, want)
+		}
+	}
+	seen := map[string]int{}
+	for value, pattern := range code128Patterns {
+		modules, bars := 0, 0
+		for i, w := range pattern {
+			modules += int(w - '0')
+			if i%2 == 0 {
+				bars += int(w - '0')
+			}
+		}
+		if want := 11 + 2*(len(pattern)-6); modules != want {
+			t.Errorf("pattern %d (%s) is %d modules wide, want %d", value, pattern, modules, want)
+		}
+		// Bars always cover an even number of modules.
+		if bars%2 != 0 {
+			t.Errorf("pattern %d (%s) has %d bar modules", value, pattern, bars)
+		}
+		if other, ok := seen[pattern]; ok {
+			t.Errorf("patterns %d and %d are both %s", other, value, pattern)
+		}
+		seen[pattern] = value
+	}
+}
+
+func TestEncodeCode128(t *testing.T) {
+	// The module strings match an independent encoder, except for AB12345,
+	// which it encodes one symbol longer by staying in code set B.
+	tests := []struct {
+		in      string
+		values  []int
+		modules string
+	}{
+		// An even run of four or more digits starts in code set C.
+		{"1234", []int{105, 12, 34, 82, 106},
+			"110100111001011001110010001011000100100111101100011101011"},
+		// A digit left over at the end of an odd run goes back to set B.
+		{"12345", []int{105, 12, 34, 100, 21, 54, 106},
+			"1101001110010110011100100010110001011110111011011100100111010110001100011101011"},
+		{"1234AB", []int{105, 12, 34, 100, 33, 34, 66, 106},
+			"110100111001011001110010001011000101111011101010001100010001011000100100001101100011101011"},
+		// Shorter runs stay in set B.
+		{"123", []int{104, 17, 18, 19, 8, 106}, ""},
+		{"AB123", []int{104, 33, 34, 17, 18, 19, 11, 106},
+			"110100100001010001100010001011000100111001101100111001011001011100110001001001100011101011"},
+		// Set C is entered for an even run after text ...
+		{"AB123456", []int{104, 33, 34, 99, 12, 34, 56, 26, 106},
+			"11010010000101000110001000101100010111011110101100111001000101100011100010110111001001101100011101011"},
+		// ... after the first digit of an odd run ...
+		{"AB12345", []int{104, 33, 34, 17, 99, 23, 45, 7, 106},
+			"11010010000101000110001000101100010011100110101110111101110110111010111011000100110001001100011101011"},
+		// ... and left again for the text after it.
+		{"A1234B", []int{104, 33, 99, 12, 34, 100, 34, 78, 106}, ""},
+		{"Wikipedia", []int{104, 55, 73, 75, 73, 80, 69, 68, 73, 65, 88, 106},
+			"11010010000111010001101000011010011000010010100001101001010011110010110010000100001001101000011010010010110000111100100101100011101011"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			modules, err := encodeCode128(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := code128Values(t, modules); !reflect.DeepEqual(got, tt.values) {
+				t.Errorf("symbol values %v, want %v", got, tt.values)
+			}
+			if got := moduleBits(modules); tt.modules != "" && got != tt.modules {
+				t.Errorf("modules\n%s, want\n%s", got, tt.modules)
+			}
+		})
+	}
+}
+
+func TestEncodeCode128Checksum(t *testing.T) {
+	for _, in := range []string{"Box 1", "000-101", "https://x.example/item/42", "~}|{"} {
+		modules, err := encodeCode128(in)
+		if err != nil {
+			t.Fatalf("%q: %v", in, err)
+		}
+		values := code128Values(t, modules)
+		sum := values[0]
+		for i, v := range values[1 : len(values)-2] {
+			sum += (i + 1) * v
+		}
+		if check := values[len(values)-2]; check != sum%103 {
+			t.Errorf("%q: check symbol %d, want %d", in, check, sum%103)
+		}
+	}
+	for _, in := range []string{"", "é", "a\tb"} {
+		if _, err := encodeCode128(in); err == nil {
+			t.Errorf("%q accepted", in)
+		}
+	}
+}
+
+func TestEncodeCode39(t *testing.T) {
+	tests := []struct {
+		in      string
+		modules string
+	}{
+		{"A1", "100010111011101011101010001011101110100010101110100010111011101"},
+		{"CODE-39", "10001011101110101110111010001010111010111010001010101110001011101110101110001010100010101110111011101110001010101011100010111010100010111011101"},
+		{"X.Y $/+%", "100010111011101010001011101011101110001010111010111000101110101010001110101110101000100010001010100010001010001010001010001000101010001000100010100010111011101"},
+		// Lower case is upper-cased.
+		{"code-39", "10001011101110101110111010001010111010111010001010101110001011101110101110001010100010101110111011101110001010101011100010111010100010111011101"},
+	}
+	for _, tt := range tests {
+		modules, err := encodeCode39(tt.in)
+		if err != nil {
+			t.Errorf("%q: %v", tt.in, err)
+			continue
+		}
+		if got := moduleBits(modules); got != tt.modules {
+			t.Errorf("%q: modules\n%s, want\n%s", tt.in, got, tt.modules)
+		}
+	}
+	for _, in := range []string{"", "A_B", "A*B", "Größe", "a\nb"} {
+		if _, err := encodeCode39(in); err == nil {
+			t.Errorf("%q accepted", in)
+		}
+	}
+}
+
+func TestEncodeEAN13(t *testing.T) {
+	const wikipedia = "10100011010100111010111101111010001001011001101010100001010000101000010111010010000101100110101"
+	tests := []struct {
+		in      string
+		modules string
+		err     string
+	}{
+		// The check digit is computed for 12 digits ...
+		{"400638133393", wikipedia, ""},
+		// ... and checked for 13.
+		{"4006381333931", wikipedia, ""},
+		{"4006381333932", "", "ean13 check digit should be 1"},
+		{"012345678905", "10100110010010011011110101000110110001010111101010100010010010001110100111001010011101110010101", ""},
+		{"12345", "", "ean13 needs 12 or 13 digits"},
+		{"40063813339a", "", "ean13 needs 12 or 13 digits"},
+		{"40063813339312", "", "ean13 needs 12 or 13 digits"},
+		{"", "", "ean13 needs 12 or 13 digits"},
+	}
+	for _, tt := range tests {
+		modules, err := encodeEAN13(tt.in)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%q: error %v, want %q", tt.in, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.in, err)
+			continue
+		}
+		if got := moduleBits(modules); got != tt.modules {
+			t.Errorf("%q: modules\n%s, want\n%s", tt.in, got, tt.modules)
+		}
+	}
+}
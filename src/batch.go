@@ -0,0 +1,347 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	batchFormatPDF = "pdf"
+	batchFormatZIP = "zip"
+	maxBatchLabels = 1000
+)
+
+var batchContentTypes = map[string]string{
+	batchFormatPDF: "application/pdf",
+	batchFormatZIP: "application/zip",
+}
+
+// batchItemError reports why one entry of a batch could not be rendered.
+type batchItemError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+// batchHandler renders a JSON array of label parameter objects into a single
+// multi-page PDF or a ZIP of PNGs, in input order. If any entry fails, nothing
+// is rendered and every failing entry is reported.
+func batchHandler(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	logInfo("%s %s from %s", r.Method, r.URL.Path, clientAddr(r))
+
+	if r.Method != http.MethodPost {
+		logError("method not allowed: %s (expected POST)", r.Method)
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	format, err := negotiateBatchFormat(r.URL.Query(), r.Header.Get("Accept"))
+	if err != nil {
+		logError("batch format negotiation failed: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts := outputOptions{format: formatPNG}
+	if err := parseColorOptions(r.URL.Query(), &opts); err != nil {
+		logError("output option parsing failed: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := parseSheetOptions(r.URL.Query(), &opts); err != nil {
+		logError("sheet option parsing failed: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.sheet != nil {
+		format = batchFormatPDF
+	}
+
+	maxUpload := envInt("HBOX_WEB_MAX_UPLOAD_SIZE", defaultMaxUpload)
+	items, err := decodeBatch(http.MaxBytesReader(w, r.Body, int64(maxUpload)))
+	if err != nil {
+		logError("batch decoding failed: %v", err)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(items) == 0 {
+		logError("empty batch")
+		http.Error(w, "batch must contain at least one label", http.StatusBadRequest)
+		return
+	}
+	if count := len(items) * maxInt(opts.copies, 1); count > maxBatchLabels {
+		logError("batch of %d labels exceeds maximum %d", count, maxBatchLabels)
+		http.Error(w, fmt.Sprintf("batch exceeds maximum of %d labels (including copies)", maxBatchLabels), http.StatusRequestEntityTooLarge)
+		return
+	}
+	logDebug("batch: %d labels as %s", len(items), strings.ToUpper(format))
+
+	labels := make([]labelParams, len(items))
+	layouts := make([]*labelLayout, len(items))
+	var failures []batchItemError
+	for i, values := range items {
+		params, layout, err := layoutBatchItem(values, opts.sheet)
+		if err != nil {
+			logError("batch label %d failed: %v", i, err)
+			failures = append(failures, batchItemError{Index: i, Error: err.Error()})
+			continue
+		}
+		labels[i] = params
+		layouts[i] = layout
+	}
+	if len(failures) > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string][]batchItemError{"errors": failures})
+		return
+	}
+
+	var data []byte
+	switch {
+	case opts.sheet != nil:
+		data, err = encodeSheet(opts.sheet, opts.skip, repeatLabels(labels, opts.copies), opts, maxUpload)
+	case format == batchFormatZIP:
+		data, err = encodeBatchZIP(layouts, opts, maxUpload)
+	default:
+		data, err = encodeBatchPDF(layouts, maxUpload)
+	}
+	if errors.Is(err, errOutputTooLarge) {
+		logError("batch output exceeds maximum %d bytes", maxUpload)
+		http.Error(w, "batch exceeds maximum upload size", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		logError("batch %s encoding failed: %v", strings.ToUpper(format), err)
+		http.Error(w, "failed to encode batch", http.StatusInternalServerError)
+		return
+	}
+	if len(data) > maxUpload {
+		logError("batch size %d bytes exceeds maximum %d bytes", len(data), maxUpload)
+		http.Error(w, "batch exceeds maximum upload size", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	logInfo("generated batch of %d labels as %s (%d bytes) in %v",
+		len(layouts), strings.ToUpper(format), len(data), time.Since(startTime))
+
+	w.Header().Set("Content-Type", batchContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"labels.%s\"", format))
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// negotiateBatchFormat picks PDF or ZIP from the Format parameter or the
+// Accept header; PDF is the default.
+func negotiateBatchFormat(values url.Values, accept string) (string, error) {
+	if explicit := strings.ToLower(strings.TrimSpace(queryGet(values, "Format"))); explicit != "" {
+		if _, ok := batchContentTypes[explicit]; !ok {
+			return "", fmt.Errorf("unsupported batch format %q (use pdf or zip)", explicit)
+		}
+		return explicit, nil
+	}
+
+	best := batchFormatPDF
+	bestQ := 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, q := parseAcceptPart(part)
+		for format, contentType := range batchContentTypes {
+			if mediaType == contentType && q > bestQ {
+				best = format
+				bestQ = q
+			}
+		}
+	}
+	return best, nil
+}
+
+// decodeBatch reads a JSON array of objects whose keys are the query
+// parameters understood by parseLabelParams.
+func decodeBatch(body io.Reader) ([]url.Values, error) {
+	decoder := json.NewDecoder(body)
+	decoder.UseNumber()
+	var raw []map[string]any
+	if err := decoder.Decode(&raw); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("invalid batch body: %w", err)
+	}
+
+	items := make([]url.Values, len(raw))
+	for i, entry := range raw {
+		values, err := labelValues(entry)
+		if err != nil {
+			return nil, fmt.Errorf("label %d: %w", i, err)
+		}
+		items[i] = values
+	}
+	return items, nil
+}
+
+// decodeLabel reads a single JSON object in the same form as a batch entry.
+func decodeLabel(body io.Reader) (url.Values, error) {
+	decoder := json.NewDecoder(body)
+	decoder.UseNumber()
+	var raw map[string]any
+	if err := decoder.Decode(&raw); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("invalid label body: %w", err)
+	}
+	return labelValues(raw)
+}
+
+// labelValues converts a JSON object to query parameters. A "fields" array of
+// {"name", "value"} objects becomes Field=Name:Value parameters, added after
+// any scalar "Field" so neither replaces the other.
+func labelValues(entry map[string]any) (url.Values, error) {
+	values := url.Values{}
+	var fieldKeys []string
+	for key, value := range entry {
+		if strings.EqualFold(key, "fields") {
+			fieldKeys = append(fieldKeys, key)
+			continue
+		}
+		s, ok := scalarString(value)
+		if !ok {
+			return nil, fmt.Errorf("unsupported value for %q", key)
+		}
+		if value != nil {
+			values.Set(key, s)
+		}
+	}
+	sort.Strings(fieldKeys)
+	for _, key := range fieldKeys {
+		if err := addFieldValues(values, entry[key]); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func addFieldValues(values url.Values, raw any) error {
+	list, ok := raw.([]any)
+	if !ok {
+		return errors.New(`"fields" must be an array of {"name", "value"} objects`)
+	}
+	for i, item := range list {
+		obj, ok := item.(map[string]any)
+		if !ok {
+			return fmt.Errorf("field %d: expected an object", i)
+		}
+		name, _ := obj["name"].(string)
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("field %d: missing name", i)
+		}
+		value, ok := scalarString(obj["value"])
+		if !ok {
+			return fmt.Errorf("field %d: unsupported value", i)
+		}
+		values.Add("Field", name+":"+value)
+	}
+	return nil
+}
+
+func scalarString(value any) (string, bool) {
+	switch v := value.(type) {
+	case nil:
+		return "", true
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
+
+func layoutBatchItem(values url.Values, sheet *labelSheet) (labelParams, *labelLayout, error) {
+	params, err := parseLabelParams(values)
+	if err != nil {
+		return params, nil, err
+	}
+	if sheet != nil {
+		params = sheet.cellParams(params)
+	}
+	layout, err := layoutLabel(params)
+	return params, layout, err
+}
+
+var errOutputTooLarge = errors.New("output exceeds maximum size")
+
+// encodeBatchPDF writes one PDF page per layout, stopping as soon as the
+// pages grow beyond limit.
+func encodeBatchPDF(layouts []*labelLayout, limit int) ([]byte, error) {
+	doc := newPDFDocument()
+	for _, layout := range layouts {
+		if err := doc.addPage(layout); err != nil {
+			return nil, err
+		}
+		if doc.written > limit {
+			return nil, errOutputTooLarge
+		}
+	}
+	return doc.bytes()
+}
+
+// encodeBatchZIP renders every layout as a PNG and stores it in a ZIP archive,
+// stopping as soon as the archive grows beyond limit.
+func encodeBatchZIP(layouts []*labelLayout, opts outputOptions, limit int) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	digits := len(strconv.Itoa(len(layouts)))
+	for i, layout := range layouts {
+		data, err := encodeLayout(layout, opts)
+		if err != nil {
+			return nil, err
+		}
+		// PNG data is already compressed.
+		f, err := zw.CreateHeader(&zip.FileHeader{
+			Name:   fmt.Sprintf("label-%0*d.png", digits, i+1),
+			Method: zip.Store,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Write(data); err != nil {
+			return nil, err
+		}
+		if buf.Len() > limit {
+			return nil, errOutputTooLarge
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
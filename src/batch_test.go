@@ -0,0 +1,222 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func postBatch(t *testing.T, query, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/batch?"+query, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	batchHandler(rec, req)
+	return rec
+}
+
+func TestBatchPDFOrder(t *testing.T) {
+	rec := postBatch(t, "Format=pdf", `[
+		{"TitleText": "A", "Width": 200},
+		{"TitleText": "B", "Width": 300},
+		{"TitleText": "C", "Width": 400}
+	]`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/pdf" {
+		t.Errorf("Content-Type %q", got)
+	}
+	// Pages are written in order, so their MediaBoxes follow the input.
+	var widths []string
+	for _, m := range regexp.MustCompile(`/Type /Page /Parent \d+ 0 R /MediaBox \[0 0 ([\d.]+) `).FindAllSubmatch(rec.Body.Bytes(), -1) {
+		widths = append(widths, string(m[1]))
+	}
+	var want []string
+	for _, w := range []float64{200, 300, 400} {
+		want = append(want, formatNumber(w*72/defaultDPI))
+	}
+	if !reflect.DeepEqual(widths, want) {
+		t.Errorf("page widths %v, want %v", widths, want)
+	}
+	if !bytes.Contains(rec.Body.Bytes(), []byte("/Count 3 ")) {
+		t.Error("page tree does not count 3 pages")
+	}
+}
+
+func TestBatchZIPOrder(t *testing.T) {
+	items := []string{
+		`{"TitleText": "A", "URL": "https://x.example/item/1"}`,
+		`{"TitleText": "B", "Barcode": "code128", "URL": "B-2"}`,
+		`{"TitleText": "C", "DynamicLength": true}`,
+	}
+	rec := postBatch(t, "Format=zip", "["+strings.Join(items, ",")+"]")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != len(items) {
+		t.Fatalf("%d files, want %d", len(zr.File), len(items))
+	}
+	for i, f := range zr.File {
+		if want := fmt.Sprintf("label-%d.png", i+1); f.Name != want {
+			t.Errorf("file %d is %s, want %s", i, f.Name, want)
+		}
+		values, err := decodeLabel(strings.NewReader(items[i]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, layout, err := layoutBatchItem(values, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := encodeLayout(layout, outputOptions{format: formatPNG})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s differs from label %d rendered on its own", f.Name, i)
+		}
+	}
+}
+
+func TestBatchItemErrors(t *testing.T) {
+	rec := postBatch(t, "", `[
+		{"TitleText": "A"},
+		{"TitleText": "B", "Template": "nope"},
+		{"TitleText": "C"},
+		{"TitleText": "D", "Barcode": "bogus"}
+	]`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status %d, want 422: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type %q", got)
+	}
+	var body struct {
+		Errors []batchItemError `json:"errors"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode %q: %v", rec.Body, err)
+	}
+	want := []batchItemError{
+		{Index: 1, Error: errUnknownTemplate("nope").Error()},
+		{Index: 3, Error: `unsupported barcode "bogus"`},
+	}
+	if !reflect.DeepEqual(body.Errors, want) {
+		t.Errorf("errors %+v, want %+v", body.Errors, want)
+	}
+}
+
+func TestBatchLimits(t *testing.T) {
+	labels := func(n int) string {
+		items := make([]string, n)
+		for i := range items {
+			items[i] = fmt.Sprintf(`{"TitleText": "Drill %d", "URL": "https://x.example/item/%d"}`, i, i)
+		}
+		return "[" + strings.Join(items, ",") + "]"
+	}
+	tests := []struct {
+		name      string
+		maxUpload string
+		query     string
+		body      string
+		status    int
+	}{
+		{"within limits", "", "", labels(3), http.StatusOK},
+		{"too many labels", "", "", labels(maxBatchLabels + 1), http.StatusRequestEntityTooLarge},
+		{"too many copies", "", "Sheet=L7160&Copies=400", labels(3), http.StatusRequestEntityTooLarge},
+		{"pdf too large", "40000", "Format=pdf", labels(30), http.StatusRequestEntityTooLarge},
+		{"zip too large", "40000", "Format=zip", labels(30), http.StatusRequestEntityTooLarge},
+		{"sheet too large", "40000", "Sheet=L7160", labels(30), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HBOX_WEB_MAX_UPLOAD_SIZE", tt.maxUpload)
+			rec := postBatch(t, tt.query, tt.body)
+			if rec.Code != tt.status {
+				t.Errorf("status %d, want %d: %.200s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
+
+func TestEncodeBatchPDFLimit(t *testing.T) {
+	var layouts []*labelLayout
+	for i := 0; i < 50; i++ {
+		params, err := parseLabelParams(url.Values{"TitleText": {fmt.Sprint("Drill ", i)}, "URL": {fmt.Sprint("https://x.example/item/", i)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		layout, err := layoutLabel(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		layouts = append(layouts, layout)
+	}
+	data, err := encodeBatchPDF(layouts, defaultMaxUpload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The pages alone exceed half the document, so the limit is hit before
+	// the fonts are written.
+	if _, err := encodeBatchPDF(layouts, len(data)/2); !errors.Is(err, errOutputTooLarge) {
+		t.Errorf("encodeBatchPDF with limit %d: %v, want errOutputTooLarge", len(data)/2, err)
+	}
+}
+
+func TestDecodeLabelFields(t *testing.T) {
+	values, err := decodeLabel(strings.NewReader(`{
+		"TitleText": "Drill",
+		"fields": [{"name": "Serial", "value": "SN-1"}, {"name": "Qty", "value": 3}, {"name": "Note", "value": null}],
+		"Field": "Bin:B3"
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The array is added after the scalar Field, whatever the key order.
+	want := []string{"Bin:B3", "Serial:SN-1", "Qty:3", "Note:"}
+	if got := values["Field"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("Field = %q, want %q", got, want)
+	}
+	fields, err := parseFields(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []labelField{{"Bin", "B3"}, {"Serial", "SN-1"}, {"Qty", "3"}}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("fields %v, want %v", fields, want)
+	}
+
+	for _, body := range []string{
+		`{"fields": {"name": "Serial", "value": "SN-1"}}`,
+		`{"fields": ["Serial:SN-1"]}`,
+		`{"fields": [{"value": "SN-1"}]}`,
+		`{"fields": [{"name": " ", "value": "SN-1"}]}`,
+		`{"fields": [{"name": "Serial", "value": ["SN-1"]}]}`,
+	} {
+		if _, err := decodeLabel(strings.NewReader(body)); err == nil {
+			t.Errorf("%s accepted", body)
+		}
+	}
+}
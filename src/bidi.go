@@ -0,0 +1,438 @@
+package main
+
+import (
+	"golang.org/x/text/unicode/bidi"
+)
+
+// isRTL reports whether the first strong character of text is right-to-left,
+// which makes it the base direction of the paragraph.
+func isRTL(text string) bool {
+	for _, r := range text {
+		props, _ := bidi.LookupRune(r)
+		switch props.Class() {
+		case bidi.L:
+			return false
+		case bidi.R, bidi.AL:
+			return true
+		}
+	}
+	return false
+}
+
+func hasRTL(text string) bool {
+	for _, r := range text {
+		props, _ := bidi.LookupRune(r)
+		if class := props.Class(); class == bidi.R || class == bidi.AL {
+			return true
+		}
+	}
+	return false
+}
+
+// mirrorAlign swaps left and right alignment for right-to-left text.
+func mirrorAlign(align textAlign, text string) textAlign {
+	if !isRTL(text) {
+		return align
+	}
+	switch align {
+	case alignLeft:
+		return alignRight
+	case alignRight:
+		return alignLeft
+	}
+	return align
+}
+
+// visualOrder reorders one line of text from logical to display order. Each
+// paragraph of the line is ordered on its own.
+func visualOrder(line string) string {
+	if !hasRTL(line) {
+		return line
+	}
+	runes := []rune(line)
+	out := make([]rune, 0, len(runes))
+	start := 0
+	for i, r := range runes {
+		if props, _ := bidi.LookupRune(r); props.Class() == bidi.B {
+			out = append(out, reorderParagraph(runes[start:i])...)
+			out = append(out, r)
+			start = i + 1
+		}
+	}
+	return string(append(out, reorderParagraph(runes[start:])...))
+}
+
+// reorderParagraph resolves the embedding level of every rune with the
+// implicit rules of the Unicode Bidirectional Algorithm and reverses the
+// runes for display (rules L1, L2 and L4). Explicit embeddings and isolates
+// are not supported; their control characters count as neutral.
+func reorderParagraph(runes []rune) []rune {
+	if len(runes) == 0 {
+		return runes
+	}
+	levels := bidiLevels(runes)
+
+	out := append([]rune(nil), runes...)
+	for level := 2; level >= 1; level-- {
+		for i := 0; i < len(out); {
+			if levels[i] < level {
+				i++
+				continue
+			}
+			j := i
+			for j < len(out) && levels[j] >= level {
+				j++
+			}
+			for a, b := i, j-1; a < b; a, b = a+1, b-1 {
+				out[a], out[b] = out[b], out[a]
+				levels[a], levels[b] = levels[b], levels[a]
+			}
+			i = j
+		}
+	}
+	// Brackets at odd levels are drawn mirrored; ReverseString swaps them.
+	for i, r := range out {
+		if levels[i]%2 == 1 {
+			out[i] = []rune(bidi.ReverseString(string(r)))[0]
+		}
+	}
+	return out
+}
+
+// bidiLevels returns the resolved embedding level of each rune of a single
+// paragraph.
+func bidiLevels(runes []rune) []int {
+	n := len(runes)
+	base := 0
+	if isRTL(string(runes)) {
+		base = 1
+	}
+	sos := bidi.L
+	if base == 1 {
+		sos = bidi.R
+	}
+
+	types := make([]bidi.Class, n)
+	for i, r := range runes {
+		props, _ := bidi.LookupRune(r)
+		types[i] = props.Class()
+		switch types[i] {
+		case bidi.LRE, bidi.RLE, bidi.LRO, bidi.RLO, bidi.PDF, bidi.LRI, bidi.RLI, bidi.FSI, bidi.PDI, bidi.BN:
+			types[i] = bidi.ON
+		}
+	}
+
+	// W1: marks take the type of the preceding character.
+	for i, t := range types {
+		if t == bidi.NSM {
+			if i == 0 {
+				types[i] = sos
+			} else {
+				types[i] = types[i-1]
+			}
+		}
+	}
+	// W2, W3: numbers after Arabic letters are Arabic numbers, and Arabic
+	// letters are right-to-left.
+	last := sos
+	for i, t := range types {
+		switch t {
+		case bidi.L, bidi.R, bidi.AL:
+			last = t
+		case bidi.EN:
+			if last == bidi.AL {
+				types[i] = bidi.AN
+			}
+		}
+	}
+	for i, t := range types {
+		if t == bidi.AL {
+			types[i] = bidi.R
+		}
+	}
+	// W4: a single separator between two numbers of the same kind joins them.
+	for i := 1; i+1 < n; i++ {
+		prev, next := types[i-1], types[i+1]
+		switch {
+		case types[i] == bidi.ES && prev == bidi.EN && next == bidi.EN:
+			types[i] = bidi.EN
+		case types[i] == bidi.CS && prev == next && (prev == bidi.EN || prev == bidi.AN):
+			types[i] = prev
+		}
+	}
+	// W5, W6: terminators next to European numbers belong to them, other
+	// separators and terminators are neutral.
+	for i := 0; i < n; {
+		if types[i] != bidi.ET {
+			i++
+			continue
+		}
+		j := i
+		for j < n && types[j] == bidi.ET {
+			j++
+		}
+		if (i > 0 && types[i-1] == bidi.EN) || (j < n && types[j] == bidi.EN) {
+			for k := i; k < j; k++ {
+				types[k] = bidi.EN
+			}
+		}
+		i = j
+	}
+	for i, t := range types {
+		switch t {
+		case bidi.ES, bidi.ET, bidi.CS:
+			types[i] = bidi.ON
+		}
+	}
+	// W7: European numbers in left-to-right context are left-to-right.
+	last = sos
+	for i, t := range types {
+		switch t {
+		case bidi.L, bidi.R:
+			last = t
+		case bidi.EN:
+			if last == bidi.L {
+				types[i] = bidi.L
+			}
+		}
+	}
+
+	// Numbers count as right-to-left when resolving neutrals.
+	strong := func(t bidi.Class) bidi.Class {
+		switch t {
+		case bidi.L:
+			return bidi.L
+		case bidi.R, bidi.EN, bidi.AN:
+			return bidi.R
+		}
+		return bidi.ON
+	}
+	embedding := sos
+
+	// N0: paired brackets take the direction of their content, or of the
+	// text before them when the content only has the opposite direction.
+	var stack []int
+	for i, r := range runes {
+		props, _ := bidi.LookupRune(r)
+		if types[i] != bidi.ON || !props.IsBracket() {
+			continue
+		}
+		if props.IsOpeningBracket() {
+			stack = append(stack, i)
+			continue
+		}
+		for s := len(stack) - 1; s >= 0; s-- {
+			open := stack[s]
+			if bidi.ReverseString(string(runes[open])) != string(r) {
+				continue
+			}
+			stack = stack[:s]
+			found := bidi.ON
+			for k := open + 1; k < i; k++ {
+				if t := strong(types[k]); t == embedding {
+					found = t
+					break
+				} else if t != bidi.ON {
+					found = t
+				}
+			}
+			if found == bidi.ON {
+				break
+			}
+			if found != embedding {
+				before := sos
+				for k := open - 1; k >= 0; k-- {
+					if t := strong(types[k]); t != bidi.ON {
+						before = t
+						break
+					}
+				}
+				if before != found {
+					found = embedding
+				}
+			}
+			types[open], types[i] = found, found
+			break
+		}
+	}
+
+	// N1, N2: neutrals between text of one direction take that direction,
+	// all others the paragraph direction.
+	for i := 0; i < n; {
+		if strong(types[i]) != bidi.ON {
+			i++
+			continue
+		}
+		j := i
+		for j < n && strong(types[j]) == bidi.ON {
+			j++
+		}
+		before, after := sos, sos
+		if i > 0 {
+			before = strong(types[i-1])
+		}
+		if j < n {
+			after = strong(types[j])
+		}
+		direction := embedding
+		if before == after {
+			direction = before
+		}
+		for k := i; k < j; k++ {
+			types[k] = direction
+		}
+		i = j
+	}
+
+	// I1, I2 and L1: trailing whitespace goes back to the paragraph level.
+	levels := make([]int, n)
+	for i, t := range types {
+		switch {
+		case base == 0 && t == bidi.R:
+			levels[i] = 1
+		case base == 0 && (t == bidi.EN || t == bidi.AN):
+			levels[i] = 2
+		case base == 1 && (t == bidi.L || t == bidi.EN || t == bidi.AN):
+			levels[i] = 2
+		default:
+			levels[i] = base
+		}
+	}
+	for i := n - 1; i >= 0; i-- {
+		props, _ := bidi.LookupRune(runes[i])
+		if class := props.Class(); class != bidi.WS && class != bidi.S {
+			break
+		}
+		levels[i] = base
+	}
+	return levels
+}
+
+// arabicForms lists the isolated, final, initial and medial presentation
+// forms of the Arabic letters. Letters that only join to the preceding letter
+// have two forms, hamza has one.
+var arabicForms = map[rune][]rune{
+	0x0621: {0xFE80},
+	0x0622: {0xFE81, 0xFE82},
+	0x0623: {0xFE83, 0xFE84},
+	0x0624: {0xFE85, 0xFE86},
+	0x0625: {0xFE87, 0xFE88},
+	0x0626: {0xFE89, 0xFE8A, 0xFE8B, 0xFE8C},
+	0x0627: {0xFE8D, 0xFE8E},
+	0x0628: {0xFE8F, 0xFE90, 0xFE91, 0xFE92},
+	0x0629: {0xFE93, 0xFE94},
+	0x062A: {0xFE95, 0xFE96, 0xFE97, 0xFE98},
+	0x062B: {0xFE99, 0xFE9A, 0xFE9B, 0xFE9C},
+	0x062C: {0xFE9D, 0xFE9E, 0xFE9F, 0xFEA0},
+	0x062D: {0xFEA1, 0xFEA2, 0xFEA3, 0xFEA4},
+	0x062E: {0xFEA5, 0xFEA6, 0xFEA7, 0xFEA8},
+	0x062F: {0xFEA9, 0xFEAA},
+	0x0630: {0xFEAB, 0xFEAC},
+	0x0631: {0xFEAD, 0xFEAE},
+	0x0632: {0xFEAF, 0xFEB0},
+	0x0633: {0xFEB1, 0xFEB2, 0xFEB3, 0xFEB4},
+	0x0634: {0xFEB5, 0xFEB6, 0xFEB7, 0xFEB8},
+	0x0635: {0xFEB9, 0xFEBA, 0xFEBB, 0xFEBC},
+	0x0636: {0xFEBD, 0xFEBE, 0xFEBF, 0xFEC0},
+	0x0637: {0xFEC1, 0xFEC2, 0xFEC3, 0xFEC4},
+	0x0638: {0xFEC5, 0xFEC6, 0xFEC7, 0xFEC8},
+	0x0639: {0xFEC9, 0xFECA, 0xFECB, 0xFECC},
+	0x063A: {0xFECD, 0xFECE, 0xFECF, 0xFED0},
+	0x0640: {0x0640, 0x0640, 0x0640, 0x0640},
+	0x0641: {0xFED1, 0xFED2, 0xFED3, 0xFED4},
+	0x0642: {0xFED5, 0xFED6, 0xFED7, 0xFED8},
+	0x0643: {0xFED9, 0xFEDA, 0xFEDB, 0xFEDC},
+	0x0644: {0xFEDD, 0xFEDE, 0xFEDF, 0xFEE0},
+	0x0645: {0xFEE1, 0xFEE2, 0xFEE3, 0xFEE4},
+	0x0646: {0xFEE5, 0xFEE6, 0xFEE7, 0xFEE8},
+	0x0647: {0xFEE9, 0xFEEA, 0xFEEB, 0xFEEC},
+	0x0648: {0xFEED, 0xFEEE},
+	0x0649: {0xFEEF, 0xFEF0, 0xFBE8, 0xFBE9},
+	0x064A: {0xFEF1, 0xFEF2, 0xFEF3, 0xFEF4},
+	0x0671: {0xFB50, 0xFB51},
+	0x067E: {0xFB56, 0xFB57, 0xFB58, 0xFB59},
+	0x0686: {0xFB7A, 0xFB7B, 0xFB7C, 0xFB7D},
+	0x0698: {0xFB8A, 0xFB8B},
+	0x06A9: {0xFB8E, 0xFB8F, 0xFB90, 0xFB91},
+	0x06AF: {0xFB92, 0xFB93, 0xFB94, 0xFB95},
+	0x06CC: {0xFBFC, 0xFBFD, 0xFBFE, 0xFBFF},
+}
+
+// lamAlef maps the alef that follows a lam to the isolated and final forms
+// of their mandatory ligature.
+var lamAlef = map[rune][2]rune{
+	0x0622: {0xFEF5, 0xFEF6},
+	0x0623: {0xFEF7, 0xFEF8},
+	0x0625: {0xFEF9, 0xFEFA},
+	0x0627: {0xFEFB, 0xFEFC},
+}
+
+const arabicLam = 0x0644
+
+// isArabicMark reports whether r is a vowel mark, which does not break the
+// joining of the letters around it.
+func isArabicMark(r rune) bool {
+	return r >= 0x064B && r <= 0x065F || r == 0x0670
+}
+
+// shapeArabic replaces Arabic letters in logical order with the presentation
+// form for their position in the word, so fonts without OpenType shaping
+// still draw joined script.
+func shapeArabic(text string) string {
+	runes := []rune(text)
+	arabic := false
+	for _, r := range runes {
+		if _, ok := arabicForms[r]; ok {
+			arabic = true
+			break
+		}
+	}
+	if !arabic {
+		return text
+	}
+
+	// neighbour returns the nearest letter before (step -1) or after (step 1)
+	// index i, skipping vowel marks.
+	neighbour := func(i, step int) []rune {
+		for j := i + step; j >= 0 && j < len(runes); j += step {
+			if !isArabicMark(runes[j]) {
+				return arabicForms[runes[j]]
+			}
+		}
+		return nil
+	}
+
+	out := make([]rune, 0, len(runes))
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		forms, ok := arabicForms[r]
+		if !ok {
+			out = append(out, r)
+			continue
+		}
+		joinsPrev := len(forms) > 1 && len(neighbour(i, -1)) == 4
+		if r == arabicLam && i+1 < len(runes) {
+			if ligature, ok := lamAlef[runes[i+1]]; ok {
+				if joinsPrev {
+					out = append(out, ligature[1])
+				} else {
+					out = append(out, ligature[0])
+				}
+				i++
+				continue
+			}
+		}
+		joinsNext := len(forms) == 4 && len(neighbour(i, 1)) > 1
+		switch {
+		case joinsPrev && joinsNext:
+			out = append(out, forms[3])
+		case joinsNext:
+			out = append(out, forms[2])
+		case joinsPrev:
+			out = append(out, forms[1])
+		default:
+			out = append(out, forms[0])
+		}
+	}
+	return string(out)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBidiLevels(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []int
+	}{
+		{"hebrew", "שלום", []int{1, 1, 1, 1}},
+		{"latin", "Box 1", []int{0, 0, 0, 0, 0}},
+		// The brackets take the paragraph direction; the Latin and digits
+		// inside are raised to an even level.
+		{"rtl with bracketed number", "מקדחה (18V)", []int{1, 1, 1, 1, 1, 1, 1, 2, 2, 2, 1}},
+		// A number after Hebrew in a left-to-right paragraph belongs to the
+		// Hebrew run, and so does the space before it.
+		{"ltr with hebrew and number", "Box שלום 5", []int{0, 0, 0, 0, 1, 1, 1, 1, 1, 2}},
+		// Digits after Arabic letters are Arabic numbers.
+		{"arabic with number", "رقم 123", []int{1, 1, 1, 1, 2, 2, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bidiLevels([]rune(tt.in)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("bidiLevels(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVisualOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"latin unchanged", "Box (12) a-b", "Box (12) a-b"},
+		{"hebrew", "שלום", "םולש"},
+		{"hebrew words", "מקדחה חשמלית", "תילמשח החדקמ"},
+		{"rtl with bracketed number", "מקדחה (18V)", "(18V) החדקמ"},
+		{"rtl with latin in brackets and number", "שלום (abc) 12", "12 (abc) םולש"},
+		{"ltr with hebrew in brackets", "Item (שלום)", "Item (םולש)"},
+		{"ltr with hebrew and number", "Box שלום 5", "Box 5 םולש"},
+		{"arabic with number", "رقم 123", "123 مقر"},
+		{"number range", "מדף 1-3", "1-3 ףדמ"},
+		{"each paragraph on its own", "שלום\nabc שלום", "םולש\nabc םולש"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := visualOrder(tt.in); got != tt.want {
+				t.Errorf("visualOrder(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsRTL(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"Box", false},
+		{"12 שלום", true},
+		{"(سلام)", true},
+		{"abc שלום", false},
+		{"123", false},
+	}
+	for _, tt := range tests {
+		if got := isRTL(tt.in); got != tt.want {
+			t.Errorf("isRTL(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+	if got := mirrorAlign(alignLeft, "שלום"); got != alignRight {
+		t.Errorf("mirrorAlign(left, hebrew) = %v", got)
+	}
+	if got := mirrorAlign(alignCenter, "שלום"); got != alignCenter {
+		t.Errorf("mirrorAlign(center, hebrew) = %v", got)
+	}
+}
+
+func TestShapeArabic(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"isolated", "ب", "ﺏ"},
+		// beh initial, yeh medial, teh final
+		{"initial medial final", "بيت", "ﺑﻴﺖ"},
+		// alef only joins to the preceding letter, so the next beh is isolated
+		{"right-joining letter", "باب", "ﺑﺎﺏ"},
+		{"hamza", "ماء", "ﻣﺎﺀ"},
+		{"lam-alef isolated", "لا", "ﻻ"},
+		{"lam-alef final", "سلام", "ﺳﻼﻡ"},
+		{"lam-alef with hamza", "كلأ", "ﻛﻸ"},
+		{"lam-alef with madda", "لآ", "ﻵ"},
+		{"vowel marks do not break joining", "بَيت", "ﺑَﻴﺖ"},
+		{"space breaks joining", "ب ب", "ﺏ ﺏ"},
+		{"persian letters", "پیک", "ﭘﯿﮏ"},
+		{"mixed with latin", "Box بيت 5", "Box ﺑﻴﺖ 5"},
+		{"no arabic", "שלום abc", "שלום abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shapeArabic(tt.in); got != tt.want {
+				t.Errorf("shapeArabic(%q) = %+q, want %+q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShapeArabicVisualOrder(t *testing.T) {
+	// Shaping happens in logical order, reordering afterwards.
+	got := visualOrder(shapeArabic("سلام 12"))
+	if want := "12 ﻡﻼﺳ"; got != want {
+		t.Errorf("got %+q, want %+q", got, want)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"strings"
+)
+
+// monoBitmap is a packed 1-bit image, most significant bit first, where a
+// set bit is a black dot. This is the layout thermal printers expect.
+type monoBitmap struct {
+	width  int
+	height int
+	stride int
+	bits   []byte
+}
+
+func newMonoBitmap(width, height int) *monoBitmap {
+	stride := (width + 7) / 8
+	return &monoBitmap{
+		width:  width,
+		height: height,
+		stride: stride,
+		bits:   make([]byte, stride*height),
+	}
+}
+
+func (b *monoBitmap) set(x, y int) {
+	b.bits[y*b.stride+x/8] |= 0x80 >> uint(x%8)
+}
+
+func (b *monoBitmap) black(x, y int) bool {
+	return b.bits[y*b.stride+x/8]&(0x80>>uint(x%8)) != 0
+}
+
+// paletted returns the bitmap as a two-colour image, which image/png writes
+// with a bit depth of 1.
+func (b *monoBitmap) paletted() *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, b.width, b.height), color.Palette{color.White, color.Black})
+	for y := 0; y < b.height; y++ {
+		for x := 0; x < b.width; x++ {
+			if b.black(x, y) {
+				img.Pix[y*img.Stride+x] = 1
+			}
+		}
+	}
+	return img
+}
+
+const (
+	ditherNone           = "none"
+	ditherFloydSteinberg = "floyd-steinberg"
+	ditherBayer          = "bayer"
+)
+
+func parseDither(value string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "none", "threshold":
+		return ditherNone, true
+	case "floyd-steinberg", "floydsteinberg", "fs":
+		return ditherFloydSteinberg, true
+	case "bayer", "ordered":
+		return ditherBayer, true
+	}
+	return "", false
+}
+
+// monoBitmapFrom converts an image to 1-bit using the given dither method.
+func monoBitmapFrom(img image.Image, dither string) *monoBitmap {
+	switch dither {
+	case ditherFloydSteinberg:
+		return floydSteinbergBitmap(img)
+	case ditherBayer:
+		return bayerBitmap(img)
+	}
+	return thresholdBitmap(img)
+}
+
+// thresholdBitmap marks every pixel darker than mid-gray as black.
+func thresholdBitmap(img image.Image) *monoBitmap {
+	bounds := img.Bounds()
+	out := newMonoBitmap(bounds.Dx(), bounds.Dy())
+	for y := 0; y < out.height; y++ {
+		for x := 0; x < out.width; x++ {
+			gray := color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray)
+			if gray.Y < 128 {
+				out.set(x, y)
+			}
+		}
+	}
+	return out
+}
+
+// floydSteinbergBitmap diffuses the quantisation error of each pixel to its
+// neighbours, which keeps gray areas and anti-aliased edges readable.
+func floydSteinbergBitmap(img image.Image) *monoBitmap {
+	bounds := img.Bounds()
+	out := newMonoBitmap(bounds.Dx(), bounds.Dy())
+	levels := grayLevels(img)
+	for y := 0; y < out.height; y++ {
+		for x := 0; x < out.width; x++ {
+			i := y*out.width + x
+			old := levels[i]
+			value := 255.0
+			if old < 128 {
+				value = 0
+				out.set(x, y)
+			}
+			diff := old - value
+			if x+1 < out.width {
+				levels[i+1] += diff * 7 / 16
+			}
+			if y+1 < out.height {
+				if x > 0 {
+					levels[i+out.width-1] += diff * 3 / 16
+				}
+				levels[i+out.width] += diff * 5 / 16
+				if x+1 < out.width {
+					levels[i+out.width+1] += diff * 1 / 16
+				}
+			}
+		}
+	}
+	return out
+}
+
+func grayLevels(img image.Image) []float64 {
+	bounds := img.Bounds()
+	levels := make([]float64, bounds.Dx()*bounds.Dy())
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			gray := color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray)
+			levels[y*bounds.Dx()+x] = float64(gray.Y)
+		}
+	}
+	return levels
+}
+
+var bayerMatrix = [8][8]float64{
+	{0, 32, 8, 40, 2, 34, 10, 42},
+	{48, 16, 56, 24, 50, 18, 58, 26},
+	{12, 44, 4, 36, 14, 46, 6, 38},
+	{60, 28, 52, 20, 62, 30, 54, 22},
+	{3, 35, 11, 43, 1, 33, 9, 41},
+	{51, 19, 59, 27, 49, 17, 57, 25},
+	{15, 47, 7, 39, 13, 45, 5, 37},
+	{63, 31, 55, 23, 61, 29, 53, 21},
+}
+
+// bayerBitmap applies an 8x8 ordered dither. Unlike error diffusion the
+// pattern is stable, so neighbouring labels print identically.
+func bayerBitmap(img image.Image) *monoBitmap {
+	bounds := img.Bounds()
+	out := newMonoBitmap(bounds.Dx(), bounds.Dy())
+	levels := grayLevels(img)
+	for y := 0; y < out.height; y++ {
+		for x := 0; x < out.width; x++ {
+			threshold := (bayerMatrix[y%8][x%8] + 0.5) * 256 / 64
+			if levels[y*out.width+x] < threshold {
+				out.set(x, y)
+			}
+		}
+	}
+	return out
+}
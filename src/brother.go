@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type brotherFamily int
+
+const (
+	brotherQL brotherFamily = iota
+	brotherPT
+)
+
+// brotherMedia describes a Brother label stock. Dots are at the print head's
+// native resolution: 300 dpi for QL printers and 180 dpi for P-touch.
+type brotherMedia struct {
+	id          string
+	aliases     []string
+	family      brotherFamily
+	widthMM     int
+	lengthMM    int
+	printable   int
+	lengthDots  int
+	rightMargin int
+}
+
+const (
+	brotherQLHeadDots = 720
+	brotherPTHeadDots = 128
+)
+
+var brotherMediaTable = []brotherMedia{
+	{id: "DK-22214", aliases: []string{"12"}, family: brotherQL, widthMM: 12, printable: 106, rightMargin: 29},
+	{id: "DK-22210", aliases: []string{"29"}, family: brotherQL, widthMM: 29, printable: 306, rightMargin: 6},
+	{id: "DK-22225", aliases: []string{"38"}, family: brotherQL, widthMM: 38, printable: 413, rightMargin: 12},
+	{id: "DK-22223", aliases: []string{"50"}, family: brotherQL, widthMM: 50, printable: 554, rightMargin: 12},
+	{id: "DK-N55224", aliases: []string{"54"}, family: brotherQL, widthMM: 54, printable: 590, rightMargin: 0},
+	{id: "DK-22205", aliases: []string{"62"}, family: brotherQL, widthMM: 62, printable: 696, rightMargin: 12},
+	{id: "DK-11204", aliases: []string{"17x54"}, family: brotherQL, widthMM: 17, lengthMM: 54, printable: 165, lengthDots: 566, rightMargin: 0},
+	{id: "DK-11203", aliases: []string{"17x87"}, family: brotherQL, widthMM: 17, lengthMM: 87, printable: 165, lengthDots: 956, rightMargin: 0},
+	{id: "DK-11221", aliases: []string{"23x23"}, family: brotherQL, widthMM: 23, lengthMM: 23, printable: 202, lengthDots: 202, rightMargin: 42},
+	{id: "DK-11201", aliases: []string{"29x90"}, family: brotherQL, widthMM: 29, lengthMM: 90, printable: 306, lengthDots: 991, rightMargin: 6},
+	{id: "DK-11208", aliases: []string{"38x90"}, family: brotherQL, widthMM: 38, lengthMM: 90, printable: 413, lengthDots: 991, rightMargin: 12},
+	{id: "DK-11209", aliases: []string{"62x29"}, family: brotherQL, widthMM: 62, lengthMM: 29, printable: 696, lengthDots: 271, rightMargin: 12},
+	{id: "DK-11202", aliases: []string{"62x100"}, family: brotherQL, widthMM: 62, lengthMM: 100, printable: 696, lengthDots: 1109, rightMargin: 12},
+	{id: "TZE-6MM", aliases: []string{"TZE-6"}, family: brotherPT, widthMM: 6, printable: 32, rightMargin: 48},
+	{id: "TZE-9MM", aliases: []string{"TZE-9"}, family: brotherPT, widthMM: 9, printable: 50, rightMargin: 39},
+	{id: "TZE-12MM", aliases: []string{"TZE-12"}, family: brotherPT, widthMM: 12, printable: 70, rightMargin: 29},
+	{id: "TZE-18MM", aliases: []string{"TZE-18"}, family: brotherPT, widthMM: 18, printable: 112, rightMargin: 8},
+	{id: "TZE-24MM", aliases: []string{"TZE-24"}, family: brotherPT, widthMM: 24, printable: 128, rightMargin: 0},
+}
+
+const defaultBrotherMedia = "DK-22205"
+
+func lookupBrotherMedia(name string) (*brotherMedia, bool) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	for i := range brotherMediaTable {
+		media := &brotherMediaTable[i]
+		if media.id == name {
+			return media, true
+		}
+		for _, alias := range media.aliases {
+			if strings.EqualFold(alias, name) {
+				return media, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func brotherMediaIDs() []string {
+	ids := make([]string, 0, len(brotherMediaTable))
+	for _, media := range brotherMediaTable {
+		ids = append(ids, media.id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (m *brotherMedia) continuous() bool {
+	return m.lengthDots == 0
+}
+
+func (m *brotherMedia) headDots() int {
+	if m.family == brotherPT {
+		return brotherPTHeadDots
+	}
+	return brotherQLHeadDots
+}
+
+func (m *brotherMedia) nativeDPI() float64 {
+	if m.family == brotherPT {
+		return 180
+	}
+	return 300
+}
+
+// checkFits reports label sizes the media cannot print.
+func (m *brotherMedia) checkFits(width, height int) error {
+	if width > m.printable {
+		return fmt.Errorf("label width %d exceeds the %d printable dots of %s", width, m.printable, m.id)
+	}
+	if !m.continuous() && height > m.lengthDots {
+		return fmt.Errorf("label height %d exceeds the %d dots of %s", height, m.lengthDots, m.id)
+	}
+	return nil
+}
+
+// encodeBrotherRaster converts a rendered label into Brother raster commands.
+// Image columns run across the tape and rows along the feed direction, so
+// Width must fit the printable dots of the media.
+func encodeBrotherRaster(bitmap *monoBitmap, media *brotherMedia, compress bool, dpi float64) ([]byte, error) {
+	width, height := bitmap.width, bitmap.height
+	if err := media.checkFits(width, height); err != nil {
+		return nil, err
+	}
+	lines := height
+	if !media.continuous() {
+		lines = media.lengthDots
+	}
+	if dpi != media.nativeDPI() {
+		logDebug("rendering at %.1f DPI for %s (native %.0f DPI); output will be scaled physically", dpi, media.id, media.nativeDPI())
+	}
+
+	headBytes := media.headDots() / 8
+	// The head prints mirrored; centre the label inside the printable area.
+	offset := media.rightMargin + (media.printable-width)/2
+
+	var b bytes.Buffer
+	invalidate := 400
+	if media.family == brotherPT {
+		invalidate = 100
+	}
+	b.Write(make([]byte, invalidate))
+	b.Write([]byte{0x1b, 0x40})             // initialize
+	b.Write([]byte{0x1b, 0x69, 0x61, 0x01}) // switch to raster mode
+
+	mediaType := byte(0x0a)
+	switch {
+	case media.family == brotherPT:
+		mediaType = 0x01
+	case !media.continuous():
+		mediaType = 0x0b
+	}
+	info := []byte{0x1b, 0x69, 0x7a, 0x8e, mediaType, byte(media.widthMM), byte(media.lengthMM), 0, 0, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(info[7:11], uint32(lines))
+	b.Write(info)
+
+	b.Write([]byte{0x1b, 0x69, 0x4d, 0x40}) // various mode: auto cut
+	if media.family == brotherQL {
+		b.Write([]byte{0x1b, 0x69, 0x41, 0x01}) // cut every label
+	}
+	b.Write([]byte{0x1b, 0x69, 0x4b, 0x08}) // expanded mode: cut at end
+	margin := 0
+	switch {
+	case media.family == brotherPT:
+		margin = 14
+	case media.continuous():
+		margin = 35
+	}
+	b.Write([]byte{0x1b, 0x69, 0x64, byte(margin), byte(margin >> 8)})
+	if compress {
+		b.Write([]byte{0x4d, 0x02})
+	} else {
+		b.Write([]byte{0x4d, 0x00})
+	}
+
+	line := make([]byte, headBytes)
+	for y := 0; y < lines; y++ {
+		for i := range line {
+			line[i] = 0
+		}
+		blank := true
+		if y < height {
+			for x := 0; x < width; x++ {
+				if !bitmap.black(x, y) {
+					continue
+				}
+				pos := offset + width - 1 - x
+				line[pos/8] |= 0x80 >> uint(pos%8)
+				blank = false
+			}
+		}
+		data := line
+		if compress {
+			if blank {
+				b.WriteByte('Z')
+				continue
+			}
+			data = packBits(line)
+		}
+		if media.family == brotherPT {
+			b.Write([]byte{'G', byte(len(data)), byte(len(data) >> 8)})
+		} else {
+			b.Write([]byte{'g', 0x00, byte(len(data))})
+		}
+		b.Write(data)
+	}
+	b.WriteByte(0x1a) // print with feeding
+	return b.Bytes(), nil
+}
+
+// packBits compresses a raster line with the TIFF PackBits scheme.
+func packBits(data []byte) []byte {
+	var out []byte
+	for i := 0; i < len(data); {
+		run := 1
+		for i+run < len(data) && run < 128 && data[i+run] == data[i] {
+			run++
+		}
+		if run > 1 {
+			out = append(out, byte(257-run), data[i])
+			i += run
+			continue
+		}
+		start := i
+		for i < len(data) && i-start < 128 {
+			if i+1 < len(data) && data[i+1] == data[i] {
+				break
+			}
+			i++
+		}
+		out = append(out, byte(i-start-1))
+		out = append(out, data[start:i]...)
+	}
+	return out
+}
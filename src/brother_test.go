@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// unpackBits reverses TIFF PackBits.
+func unpackBits(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var out []byte
+	for i := 0; i < len(data); {
+		n := int(int8(data[i]))
+		i++
+		switch {
+		case n >= 0:
+			if i+n+1 > len(data) {
+				t.Fatalf("literal of %d bytes runs past the end", n+1)
+			}
+			out = append(out, data[i:i+n+1]...)
+			i += n + 1
+		case n != -128:
+			if i >= len(data) {
+				t.Fatal("repeat without a byte")
+			}
+			out = append(out, bytes.Repeat(data[i:i+1], 1-n)...)
+			i++
+		}
+	}
+	return out
+}
+
+func TestPackBits(t *testing.T) {
+	seq := func(n int) []byte {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = byte(i)
+		}
+		return b
+	}
+	tests := []struct {
+		name string
+		in   []byte
+		want []byte
+	}{
+		{"single byte", []byte{0xAA}, []byte{0x00, 0xAA}},
+		{"pair", []byte{1, 1}, []byte{0xFF, 1}},
+		{"literal then repeat then literal", []byte{1, 2, 3, 3, 3, 4}, []byte{0x01, 1, 2, 0xFE, 3, 0x00, 4}},
+		{"run of 128", bytes.Repeat([]byte{0}, 128), []byte{0x81, 0}},
+		{"run of 129", bytes.Repeat([]byte{0}, 129), []byte{0x81, 0, 0x00, 0}},
+		{"run of 130", bytes.Repeat([]byte{7}, 130), []byte{0x81, 7, 0xFF, 7}},
+		{"run of 300", bytes.Repeat([]byte{7}, 300), []byte{0x81, 7, 0x81, 7, 0xD5, 7}},
+		{"literal of 128", seq(128), append([]byte{0x7F}, seq(128)...)},
+		{"literal of 129", seq(129), append(append([]byte{0x7F}, seq(128)...), 0x00, 128)},
+		{"literal ends before a run", []byte{1, 2, 5, 5}, []byte{0x01, 1, 2, 0xFF, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := packBits(tt.in)
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("packBits = % x, want % x", got, tt.want)
+			}
+			if back := unpackBits(t, got); !bytes.Equal(back, tt.in) {
+				t.Errorf("unpacks to % x", back)
+			}
+		})
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		line := make([]byte, 90)
+		for j := range line {
+			// Few distinct values, so runs and literals mix.
+			line[j] = byte(rng.Intn(3)) * 0x55
+		}
+		if back := unpackBits(t, packBits(line)); !bytes.Equal(back, line) {
+			t.Fatalf("round trip of % x gave % x", line, back)
+		}
+	}
+}
+
+// brotherJob splits a raster job into its header (everything before the
+// first raster line), the raster lines and the trailing byte.
+func brotherJob(t *testing.T, job []byte, header int, pt bool) (head []byte, lines [][]byte, blank []bool, last byte) {
+	t.Helper()
+	if len(job) < header+1 {
+		t.Fatalf("job of %d bytes", len(job))
+	}
+	head, rest := job[:header], job[header:len(job)-1]
+	for len(rest) > 0 {
+		switch {
+		case rest[0] == 'Z':
+			lines = append(lines, nil)
+			blank = append(blank, true)
+			rest = rest[1:]
+			continue
+		case pt && rest[0] == 'G' || !pt && rest[0] == 'g':
+		default:
+			t.Fatalf("unexpected command % x", rest[:minInt(len(rest), 8)])
+		}
+		n := int(rest[1]) | int(rest[2])<<8
+		if !pt {
+			if rest[1] != 0 {
+				t.Fatalf("QL raster line with second byte %#x", rest[1])
+			}
+			n = int(rest[2])
+		}
+		lines = append(lines, rest[3:3+n])
+		blank = append(blank, false)
+		rest = rest[3+n:]
+	}
+	return head, lines, blank, job[len(job)-1]
+}
+
+func TestEncodeBrotherRasterQL(t *testing.T) {
+	media, _ := lookupBrotherMedia("DK-22205")
+	bitmap := newMonoBitmap(8, 2)
+	bitmap.set(0, 0)
+	bitmap.set(7, 0)
+	bitmap.set(3, 1)
+	job, err := encodeBrotherRaster(bitmap, media, false, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := make([]byte, 400) // invalidate
+	want = append(want,
+		0x1b, 0x40, // initialize
+		0x1b, 0x69, 0x61, 0x01, // raster mode
+		// media information: kind, width, length and quality valid;
+		// continuous tape, 62mm, 2 raster lines
+		0x1b, 0x69, 0x7a, 0x8e, 0x0a, 62, 0, 2, 0, 0, 0, 0, 0,
+		0x1b, 0x69, 0x4d, 0x40, // auto cut
+		0x1b, 0x69, 0x41, 0x01, // cut every label
+		0x1b, 0x69, 0x4b, 0x08, // cut at end
+		0x1b, 0x69, 0x64, 35, 0, // 35 dot feed margin
+		0x4d, 0x00, // no compression
+	)
+	head, lines, _, last := brotherJob(t, job, len(want), false)
+	if !bytes.Equal(head, want) {
+		t.Errorf("header\n% x, want\n% x", head, want)
+	}
+	if last != 0x1a {
+		t.Errorf("job ends with %#x, want print with feeding", last)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("%d raster lines, want 2", len(lines))
+	}
+	// The 8 dot label is centred in the 696 printable dots after the 12 dot
+	// margin, i.e. dots 356-363 of the 720 dot head, and mirrored: label
+	// column 0 is head dot 363.
+	wantLines := [][]byte{make([]byte, 90), make([]byte, 90)}
+	wantLines[0][45] = 0x10 // dot 363
+	wantLines[0][44] = 0x08 // dot 356
+	wantLines[1][45] = 0x80 // dot 360
+	for i := range lines {
+		if !bytes.Equal(lines[i], wantLines[i]) {
+			t.Errorf("line %d\n% x, want\n% x", i, lines[i], wantLines[i])
+		}
+	}
+}
+
+func TestEncodeBrotherRasterDieCut(t *testing.T) {
+	media, _ := lookupBrotherMedia("62x29")
+	bitmap := newMonoBitmap(696, 3)
+	for x := 0; x < 696; x++ {
+		bitmap.set(x, 1)
+	}
+	job, err := encodeBrotherRaster(bitmap, media, true, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make([]byte, 400)
+	want = append(want,
+		0x1b, 0x40,
+		0x1b, 0x69, 0x61, 0x01,
+		// die-cut 62x29mm, padded to the 271 lines of the label
+		0x1b, 0x69, 0x7a, 0x8e, 0x0b, 62, 29, 0x0f, 0x01, 0, 0, 0, 0,
+		0x1b, 0x69, 0x4d, 0x40,
+		0x1b, 0x69, 0x41, 0x01,
+		0x1b, 0x69, 0x4b, 0x08,
+		0x1b, 0x69, 0x64, 0, 0, // no feed margin on die-cut labels
+		0x4d, 0x02, // TIFF compression
+	)
+	head, lines, blank, _ := brotherJob(t, job, len(want), false)
+	if !bytes.Equal(head, want) {
+		t.Errorf("header\n% x, want\n% x", head, want)
+	}
+	if len(lines) != 271 {
+		t.Fatalf("%d raster lines, want 271", len(lines))
+	}
+	for i := range lines {
+		if blank[i] != (i != 1) {
+			t.Errorf("line %d blank %v", i, blank[i])
+		}
+	}
+	// A full line prints dots 12-707 and leaves 12 blank on either side.
+	full := unpackBits(t, lines[1])
+	wantFull := make([]byte, 90)
+	for pos := 12; pos < 708; pos++ {
+		wantFull[pos/8] |= 0x80 >> uint(pos%8)
+	}
+	if !bytes.Equal(full, wantFull) {
+		t.Errorf("full line\n% x, want\n% x", full, wantFull)
+	}
+}
+
+func TestEncodeBrotherRasterPTouch(t *testing.T) {
+	media, _ := lookupBrotherMedia("TZE-12MM")
+	bitmap := newMonoBitmap(70, 2)
+	bitmap.set(69, 1)
+	job, err := encodeBrotherRaster(bitmap, media, true, 180)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make([]byte, 100)
+	want = append(want,
+		0x1b, 0x40,
+		0x1b, 0x69, 0x61, 0x01,
+		0x1b, 0x69, 0x7a, 0x8e, 0x01, 12, 0, 2, 0, 0, 0, 0, 0, // laminated tape
+		0x1b, 0x69, 0x4d, 0x40,
+		0x1b, 0x69, 0x4b, 0x08, // no per-label cut command on P-touch
+		0x1b, 0x69, 0x64, 14, 0,
+		0x4d, 0x02,
+	)
+	head, lines, blank, last := brotherJob(t, job, len(want), true)
+	if !bytes.Equal(head, want) {
+		t.Errorf("header\n% x, want\n% x", head, want)
+	}
+	if last != 0x1a {
+		t.Errorf("job ends with %#x", last)
+	}
+	if len(lines) != 2 || !blank[0] || blank[1] {
+		t.Fatalf("lines %v, want a blank line and a raster line", blank)
+	}
+	// Column 69 is mirrored to the first printable dot after the 29 dot
+	// margin of the 128 dot head.
+	wantLine := make([]byte, 16)
+	wantLine[29/8] = 0x80 >> (29 % 8)
+	if got := unpackBits(t, lines[1]); !bytes.Equal(got, wantLine) {
+		t.Errorf("line\n% x, want\n% x", got, wantLine)
+	}
+}
+
+func TestEncodeBrotherRasterTooLarge(t *testing.T) {
+	continuous, _ := lookupBrotherMedia("DK-22205")
+	if _, err := encodeBrotherRaster(newMonoBitmap(697, 10), continuous, false, 300); err == nil {
+		t.Error("697 dots accepted on 62mm tape")
+	}
+	dieCut, _ := lookupBrotherMedia("DK-11209")
+	if _, err := encodeBrotherRaster(newMonoBitmap(696, 272), dieCut, false, 300); err == nil {
+		t.Error("272 lines accepted on a 271 line label")
+	}
+}
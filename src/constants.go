@@ -1,5 +1,7 @@
 package main
 
+import "github.com/skip2/go-qrcode"
+
 const (
 	defaultWidth         = 320
 	defaultHeight        = 240
@@ -12,6 +14,11 @@ const (
 	defaultMaxUpload     = 10 * 1024 * 1024
 )
 
+const (
+	orientationPortrait  = "portrait"
+	orientationLandscape = "landscape"
+)
+
 type labelParams struct {
 	width               int
 	height              int
@@ -24,5 +31,32 @@ type labelParams struct {
 	secondaryText       string
 	idText              string
 	titleFontSize       float64
+	titleMinFontSize    float64
+	titleMaxLines       int
 	descriptionFontSize float64
+	descriptionBlock    bool
+	template            string
+	titleFont           *labelFont
+	descriptionFont     *labelFont
+	dynamicLength       bool
+	media               *labelMedia
+	barcode             string
+	barcodeData         string
+	qrLevel             qrcode.RecoveryLevel
+	qrQuietZone         int
+	qrSnap              bool
+	fields              []labelField
+	icon                *labelIcon
+	logo                *labelIcon
+	logoPosition        string
+	logoSize            int
+	rotation            int
+	orientation         string
+	layout              string
+}
+
+// labelField is a custom "Name: Value" row.
+type labelField struct {
+	name  string
+	value string
 }
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+)
+
+// dataMatrixSize is one square ECC 200 symbol size. Regions is the number
+// of data regions per side; each region has its own finder pattern.
+type dataMatrixSize struct {
+	size      int
+	regions   int
+	dataWords int
+	eccWords  int
+	blocks    int
+}
+
+var dataMatrixSizes = []dataMatrixSize{
+	{10, 1, 3, 5, 1},
+	{12, 1, 5, 7, 1},
+	{14, 1, 8, 10, 1},
+	{16, 1, 12, 12, 1},
+	{18, 1, 18, 14, 1},
+	{20, 1, 22, 18, 1},
+	{22, 1, 30, 20, 1},
+	{24, 1, 36, 24, 1},
+	{26, 1, 44, 28, 1},
+	{32, 2, 62, 36, 1},
+	{36, 2, 86, 42, 1},
+	{40, 2, 114, 48, 1},
+	{44, 2, 144, 56, 1},
+	{48, 2, 174, 68, 1},
+	{52, 2, 204, 84, 2},
+	{64, 4, 280, 112, 2},
+	{72, 4, 368, 144, 4},
+	{80, 4, 456, 192, 4},
+	{88, 4, 576, 224, 4},
+	{96, 4, 696, 272, 4},
+	{104, 4, 816, 336, 6},
+}
+
+var dataMatrixField = newGaloisField(0x12d, 256)
+
+// encodeDataMatrix encodes data as the smallest square ECC 200 symbol using
+// ASCII encodation, with digit pairs packed into one codeword.
+func encodeDataMatrix(data string) ([][]bool, error) {
+	if data == "" {
+		return nil, fmt.Errorf("empty barcode data")
+	}
+	codewords := dataMatrixASCII([]byte(data))
+
+	var spec *dataMatrixSize
+	for i := range dataMatrixSizes {
+		if dataMatrixSizes[i].dataWords >= len(codewords) {
+			spec = &dataMatrixSizes[i]
+			break
+		}
+	}
+	if spec == nil {
+		return nil, fmt.Errorf("datamatrix data too long (%d codewords, max %d)", len(codewords), dataMatrixSizes[len(dataMatrixSizes)-1].dataWords)
+	}
+
+	// The first pad is 129, later ones are scrambled by their position.
+	dataLen := len(codewords)
+	for i := dataLen; i < spec.dataWords; i++ {
+		if i == dataLen {
+			codewords = append(codewords, 129)
+			continue
+		}
+		pad := 129 + (149*(i+1))%253 + 1
+		if pad > 254 {
+			pad -= 254
+		}
+		codewords = append(codewords, pad)
+	}
+
+	// Larger symbols interleave codewords over several blocks.
+	eccPerBlock := spec.eccWords / spec.blocks
+	codewords = append(codewords, make([]int, spec.eccWords)...)
+	for block := 0; block < spec.blocks; block++ {
+		var blockData []int
+		for i := block; i < spec.dataWords; i += spec.blocks {
+			blockData = append(blockData, codewords[i])
+		}
+		for i, word := range dataMatrixField.rsEncode(blockData, eccPerBlock) {
+			codewords[spec.dataWords+i*spec.blocks+block] = word
+		}
+	}
+
+	mapping := dataMatrixPlacement(spec.size-2*spec.regions, codewords)
+	return dataMatrixSymbol(spec, mapping), nil
+}
+
+func dataMatrixASCII(data []byte) []int {
+	var codewords []int
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case isDigit(c) && i+1 < len(data) && isDigit(data[i+1]):
+			codewords = append(codewords, 130+int(c-'0')*10+int(data[i+1]-'0'))
+			i++
+		case c < 128:
+			codewords = append(codewords, int(c)+1)
+		default:
+			// Upper shift to extended ASCII.
+			codewords = append(codewords, 235, int(c)-127)
+		}
+	}
+	return codewords
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// dataMatrixPlacement runs the ECC 200 module placement over an n x n mapping
+// matrix. Cells hold 10*codeword+bit (codewords counted from one, bit 1 is the
+// most significant) or 1 for the fixed dark corner module.
+func dataMatrixPlacement(n int, codewords []int) [][]bool {
+	cells := make([]int, n*n)
+	module := func(row, col, chr, bit int) {
+		if row < 0 {
+			row += n
+			col += 4 - (n+4)%8
+		}
+		if col < 0 {
+			col += n
+			row += 4 - (n+4)%8
+		}
+		cells[row*n+col] = 10*chr + bit
+	}
+	utah := func(row, col, chr int) {
+		module(row-2, col-2, chr, 1)
+		module(row-2, col-1, chr, 2)
+		module(row-1, col-2, chr, 3)
+		module(row-1, col-1, chr, 4)
+		module(row-1, col, chr, 5)
+		module(row, col-2, chr, 6)
+		module(row, col-1, chr, 7)
+		module(row, col, chr, 8)
+	}
+	corner := func(chr int, positions [8][2]int) {
+		for i, p := range positions {
+			module(p[0], p[1], chr, i+1)
+		}
+	}
+
+	chr, row, col := 1, 4, 0
+	for row < n || col < n {
+		if row == n && col == 0 {
+			corner(chr, [8][2]int{{n - 1, 0}, {n - 1, 1}, {n - 1, 2}, {0, n - 2}, {0, n - 1}, {1, n - 1}, {2, n - 1}, {3, n - 1}})
+			chr++
+		}
+		if row == n-2 && col == 0 && n%4 != 0 {
+			corner(chr, [8][2]int{{n - 3, 0}, {n - 2, 0}, {n - 1, 0}, {0, n - 4}, {0, n - 3}, {0, n - 2}, {0, n - 1}, {1, n - 1}})
+			chr++
+		}
+		if row == n-2 && col == 0 && n%8 == 4 {
+			corner(chr, [8][2]int{{n - 3, 0}, {n - 2, 0}, {n - 1, 0}, {0, n - 2}, {0, n - 1}, {1, n - 1}, {2, n - 1}, {3, n - 1}})
+			chr++
+		}
+		if row == n+4 && col == 2 && n%8 == 0 {
+			corner(chr, [8][2]int{{n - 1, 0}, {n - 1, n - 1}, {0, n - 3}, {0, n - 2}, {0, n - 1}, {1, n - 3}, {1, n - 2}, {1, n - 1}})
+			chr++
+		}
+		for {
+			if row < n && col >= 0 && cells[row*n+col] == 0 {
+				utah(row, col, chr)
+				chr++
+			}
+			row -= 2
+			col += 2
+			if row < 0 || col >= n {
+				break
+			}
+		}
+		row++
+		col += 3
+		for {
+			if row >= 0 && col < n && cells[row*n+col] == 0 {
+				utah(row, col, chr)
+				chr++
+			}
+			row += 2
+			col -= 2
+			if row >= n || col < 0 {
+				break
+			}
+		}
+		row += 3
+		col++
+	}
+	if cells[n*n-1] == 0 {
+		cells[n*n-1] = 1
+		cells[n*n-n-2] = 1
+	}
+
+	mapping := make([][]bool, n)
+	for r := range mapping {
+		mapping[r] = make([]bool, n)
+		for c := range mapping[r] {
+			v := cells[r*n+c]
+			switch {
+			case v == 1:
+				mapping[r][c] = true
+			case v >= 10:
+				word := codewords[v/10-1]
+				mapping[r][c] = word&(1<<uint(8-v%10)) != 0
+			}
+		}
+	}
+	return mapping
+}
+
+// dataMatrixSymbol adds the finder and timing patterns around each data
+// region of the mapping matrix.
+func dataMatrixSymbol(spec *dataMatrixSize, mapping [][]bool) [][]bool {
+	region := spec.size/spec.regions - 2
+	symbol := make([][]bool, spec.size)
+	for y := range symbol {
+		symbol[y] = make([]bool, spec.size)
+	}
+	for ry := 0; ry < spec.regions; ry++ {
+		for rx := 0; rx < spec.regions; rx++ {
+			top, left := ry*(region+2), rx*(region+2)
+			for i := 0; i < region+2; i++ {
+				symbol[top+region+1][left+i] = true // solid bottom edge
+				symbol[top+i][left] = true          // solid left edge
+				symbol[top][left+i] = i%2 == 0      // alternating top edge
+				symbol[top+i][left+region+1] = i%2 == 1
+			}
+			symbol[top+region+1][left+region+1] = true
+			for y := 0; y < region; y++ {
+				for x := 0; x < region; x++ {
+					symbol[top+1+y][left+1+x] = mapping[ry*region+y][rx*region+x]
+				}
+			}
+		}
+	}
+	return symbol
+}
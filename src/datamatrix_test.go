@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// matrixString draws a symbol with one line per row, '#' for dark modules.
+func matrixString(matrix [][]bool) string {
+	var b strings.Builder
+	for _, row := range matrix {
+		for _, dark := range row {
+			if dark {
+				b.WriteByte('#')
+			} else {
+				b.WriteByte('.')
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func matrixDigest(matrix [][]bool) string {
+	sum := sha256.Sum256([]byte(matrixString(matrix)))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestDataMatrixASCII(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []int
+	}{
+		// ISO/IEC 16022 example: digit pairs are packed into one codeword.
+		{"123456", []int{142, 164, 186}},
+		{"A1", []int{66, 50}},
+		{"a12b", []int{98, 142, 99}},
+		{"\xe4", []int{235, 101}},
+	}
+	for _, tt := range tests {
+		if got := dataMatrixASCII([]byte(tt.in)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("dataMatrixASCII(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+// The reference symbols below were produced with an independent ECC 200
+// encoder (github.com/boombuler/barcode/datamatrix) using ASCII encodation.
+func TestEncodeDataMatrix(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"123456", "" +
+			"#.#.#.#.#.\n" +
+			"##..#.##.#\n" +
+			"##.....#..\n" +
+			"##...###.#\n" +
+			"##....#...\n" +
+			"#.....####\n" +
+			"###.##....\n" +
+			"####.##..#\n" +
+			"#..###.#..\n" +
+			"##########\n"},
+		{"Wikipedia", "" +
+			"#.#.#.#.#.#.#.#.\n" +
+			"#.##..#.#.#.#.##\n" +
+			"##.#.#.#.#.###..\n" +
+			"#.#.##.#.....###\n" +
+			"##.#..#.#..#....\n" +
+			"#.#..##.##.##.##\n" +
+			"####.###......#.\n" +
+			"#..#..###...#..#\n" +
+			"#.#...#####.##..\n" +
+			"#.#..##.#..#..##\n" +
+			"##..##..#.##..#.\n" +
+			"#.#...###.#..###\n" +
+			"#..#.#..####..#.\n" +
+			"##....#..##.##.#\n" +
+			"######.#.#....#.\n" +
+			"################\n"},
+		{"https://inv.example/item/000-029", "" +
+			"#.#.#.#.#.#.#.#.#.#.#.\n" +
+			"#.##.###..#..##...#..#\n" +
+			"###..#..##...#.#.#..#.\n" +
+			"##.###...##.#.##.#####\n" +
+			"##..#..#.#.###.#.###..\n" +
+			"#####.######.....##.##\n" +
+			"#..####..#..####...#..\n" +
+			"##..#####.##..#..##.##\n" +
+			"#..#.#.##.#...#.#.##..\n" +
+			"#.#####....#..####...#\n" +
+			"##.#..##.#.##...##....\n" +
+			"#.#.##...##..#.##..###\n" +
+			"#..#...##.#.#####.#...\n" +
+			"#.#.##...#..#.#..#...#\n" +
+			"##.#......##..##...##.\n" +
+			"##....#...#.##.##....#\n" +
+			"#.###..##.###....####.\n" +
+			"#.#...#...##.##...#..#\n" +
+			"##.##.###.....###.#...\n" +
+			"###.#...#..##...#.####\n" +
+			"#.#######..##.####.##.\n" +
+			"######################\n"},
+		{strings.Repeat("abc", 40), "" +
+			"#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.\n" +
+			"#.##..#...#.###...##.##.#...##..#...#.###..#\n" +
+			"##...#.#.#...##..#....##.#.#...#.#.#...##...\n" +
+			"#.###...#.#.#...#.#####...#.###...#.#.#...##\n" +
+			"#..#.###...#.#.#...#..####...#.###...#.#.#..\n" +
+			"#.#...##..#...#.#.#..##.#.#.#...##..#.....##\n" +
+			"###..#...#.###...##...##...##..#...#.#####..\n" +
+			"#...#.###...##..#...####..#...#.###..#.#####\n" +
+			"##.#...##..#...#.#.#..#..#.#.#...##..#.###..\n" +
+			"#.#.#.#...#.###...#.#####...#.#.#........#.#\n" +
+			"##...#.#.#...#.###....##.###...#.#..#..##.#.\n" +
+			"##..#...#.#.#...#.#.###...##..#...#.#.#.#.##\n" +
+			"#..#.###...##..#...##.#..#...#.#####..#..#..\n" +
+			"###...##..#...##..#..##.#.###..##.#....##.##\n" +
+			"###..#...#.#.#...#.#..##...##..#..#.#..##...\n" +
+			"#...#.###...#.###...###.#.#...#.##.##.....##\n" +
+			"##.#...#.###...#.###..#..#.#...#...#...##...\n" +
+			"#.#.#.#...#.#.#...##.##.#..#.#.###.#.##.##.#\n" +
+			"##...##..#...##..#....##.####.##..###..#....\n" +
+			"##..#...##..#...#.#####....##.....##..#...##\n" +
+			"#..#.#.#...#.#.#...##.#..#...#############..\n" +
+			"############################################\n" +
+			"#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.#.\n" +
+			"###...#.###...#.#.#..###.....##.#.#..#.##.##\n" +
+			"##.###...#.###...#.#..#..#####..#####...##..\n" +
+			"#...#.#.#...##..#..####..###.#######..#...##\n" +
+			"#..#...##..#...#.####.#.#.#..##.#.#.##..#.#.\n" +
+			"#.##..#...#.###...#..###..#.###..###..####.#\n" +
+			"##...#.#.#...##...##..#.#..##...#..###....#.\n" +
+			"#.###...#.#.#..#..#####...#..#######......##\n" +
+			"#..#.###...#.#....#...####.##.#..###...#.#..\n" +
+			"#.#...##..#...#..#########.##.##.#..#.##..##\n" +
+			"###..#...#.###...#..#.#.###.#####.##.#..##..\n" +
+			"#...#.###...##...#...##...###...#.#....#####\n" +
+			"##.#...##..#..#....##.##..#.#.##...####.##..\n" +
+			"#.#.#.#...#.###.....###.###...#..#.##......#\n" +
+			"##...#.#.#...###......#.#####.#..##...#.#...\n" +
+			"##..#...#.#.#.#.#..#######.#####.###.####.##\n" +
+			"#..#.###..#..#.##.#...##..#.#...#..#..####..\n" +
+			"###...##.....#.####..###.#....#.....##....##\n" +
+			"###..#..##.###.#..#.#.#.#.....#.###..####...\n" +
+			"#...#.####..###..#..###.....#.###.#.#.#...##\n" +
+			"##.#...#..##...#...#..#..#####...###.#...#..\n" +
+			"############################################\n"},
+	}
+	for _, tt := range tests {
+		matrix, err := encodeDataMatrix(tt.in)
+		if err != nil {
+			t.Fatalf("encodeDataMatrix(%q): %v", tt.in, err)
+		}
+		if got := matrixString(matrix); got != tt.want {
+			t.Errorf("encodeDataMatrix(%.20q) =\n%swant\n%s", tt.in, got, tt.want)
+		}
+	}
+}
+
+// Larger symbols interleave several Reed-Solomon blocks; their references
+// come from the same encoder and are compared by digest.
+func TestEncodeDataMatrixBlocks(t *testing.T) {
+	tests := []struct {
+		in     string
+		size   int
+		digest string
+	}{
+		{strings.Repeat("abc", 60), 52, "978623fbe2b65791494633f6dfd010f0b78d3d7c83d8b93c89ef77c784bca917"},
+		{strings.Repeat("Q", 400), 80, "4098977a4b665845079e8b860a9af3c842192f42a3b4075160b6c683c0f2cfe1"},
+		{strings.Repeat("7", 1500), 104, "7ae295e3cbcfae5203758aafdb79f3b87343ec99a840e79846403f155a005fa9"},
+	}
+	for _, tt := range tests {
+		matrix, err := encodeDataMatrix(tt.in)
+		if err != nil {
+			t.Fatalf("encodeDataMatrix: %v", err)
+		}
+		if len(matrix) != tt.size {
+			t.Errorf("%d-byte input: %dx%d symbol, want %dx%d", len(tt.in), len(matrix), len(matrix), tt.size, tt.size)
+			continue
+		}
+		if got := matrixDigest(matrix); got != tt.digest {
+			t.Errorf("%d-byte input: digest %s, want %s", len(tt.in), got, tt.digest)
+		}
+	}
+}
+
+func TestEncodeDataMatrixErrors(t *testing.T) {
+	if _, err := encodeDataMatrix(""); err == nil {
+		t.Error("empty data: no error")
+	}
+	if _, err := encodeDataMatrix(strings.Repeat("x", 1000)); err == nil {
+		t.Error("1000 bytes: no error")
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+)
+
+// escposBandHeight limits each GS v 0 image so printers with small receive
+// buffers can print long labels.
+const escposBandHeight = 960
+
+// escposPaperWidths maps paper roll widths to their printable width in mm.
+var escposPaperWidths = map[int]float64{
+	58: 48,
+	80: 72,
+}
+
+const defaultEscposPaper = 58
+
+// escposDots returns the printable dot width of a paper roll at dpi.
+func escposDots(paperMM int, dpi float64) int {
+	printable, ok := escposPaperWidths[paperMM]
+	if !ok {
+		printable = float64(paperMM) - 10
+	}
+	return int(math.Round(printable * dpi / 25.4))
+}
+
+// encodeESCPOS wraps a bilevel bitmap in GS v 0 raster image commands,
+// padded to the printable width so it is centred on the paper, and followed
+// by a feed and full cut.
+func encodeESCPOS(bitmap *monoBitmap, paperMM int, dpi float64) ([]byte, error) {
+	dots := escposDots(paperMM, dpi)
+	if bitmap.width > dots {
+		return nil, fmt.Errorf("label width %d exceeds the %d printable dots of %dmm paper", bitmap.width, dots, paperMM)
+	}
+	// Many printers ignore ESC a for raster images, so the margin is part of
+	// the image.
+	padded := newMonoBitmap(dots, bitmap.height)
+	left := (dots - bitmap.width) / 2
+	for y := 0; y < bitmap.height; y++ {
+		for x := 0; x < bitmap.width; x++ {
+			if bitmap.black(x, y) {
+				padded.set(left+x, y)
+			}
+		}
+	}
+
+	var b bytes.Buffer
+	b.Write([]byte{0x1b, 0x40}) // initialize
+	for top := 0; top < padded.height; top += escposBandHeight {
+		rows := minInt(escposBandHeight, padded.height-top)
+		b.Write([]byte{0x1d, 0x76, 0x30, 0x00,
+			byte(padded.stride), byte(padded.stride >> 8),
+			byte(rows), byte(rows >> 8)})
+		b.Write(padded.bits[top*padded.stride : (top+rows)*padded.stride])
+	}
+	b.Write([]byte{0x1d, 0x56, 0x41, 0x03}) // feed to cutter and full cut
+	return b.Bytes(), nil
+}
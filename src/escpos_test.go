@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// escposBand is one GS v 0 image of an ESC/POS job.
+type escposBand struct {
+	stride, rows int
+	bits         []byte
+}
+
+// escposJob splits a job into its raster bands and checks the framing
+// around them.
+func escposJob(t *testing.T, job []byte) []escposBand {
+	t.Helper()
+	if !bytes.HasPrefix(job, []byte{0x1b, 0x40}) {
+		t.Fatalf("job starts with % x, want initialize", job[:minInt(len(job), 2)])
+	}
+	cut := []byte{0x1d, 0x56, 0x41, 0x03}
+	if !bytes.HasSuffix(job, cut) {
+		t.Fatalf("job ends with % x, want feed and full cut", job[maxInt(len(job)-4, 0):])
+	}
+	rest := job[2 : len(job)-len(cut)]
+	var bands []escposBand
+	for len(rest) > 0 {
+		if len(rest) < 8 || !bytes.Equal(rest[:4], []byte{0x1d, 0x76, 0x30, 0x00}) {
+			t.Fatalf("unexpected command % x", rest[:minInt(len(rest), 8)])
+		}
+		band := escposBand{
+			stride: int(rest[4]) | int(rest[5])<<8,
+			rows:   int(rest[6]) | int(rest[7])<<8,
+		}
+		n := band.stride * band.rows
+		if len(rest) < 8+n {
+			t.Fatalf("band of %dx%d bytes runs past the end", band.stride, band.rows)
+		}
+		band.bits = rest[8 : 8+n]
+		bands = append(bands, band)
+		rest = rest[8+n:]
+	}
+	return bands
+}
+
+func TestEncodeESCPOS(t *testing.T) {
+	bitmap := newMonoBitmap(100, 3)
+	bitmap.set(0, 0)
+	bitmap.set(99, 2)
+	job, err := encodeESCPOS(bitmap, 58, 203)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 48mm at 203 DPI is 384 dots: 48 bytes per row, 3 rows.
+	want := []byte{0x1b, 0x40, 0x1d, 0x76, 0x30, 0x00, 48, 0, 3, 0}
+	header := len(want)
+	want = append(want, make([]byte, 3*48)...)
+	// The 100 dot label starts 142 dots in: column 0 is dot 142, column 99
+	// dot 241.
+	want[header+142/8] = 0x80 >> (142 % 8)
+	want[header+2*48+241/8] = 0x80 >> (241 % 8)
+	want = append(want, 0x1d, 0x56, 0x41, 0x03)
+	if !bytes.Equal(job, want) {
+		t.Errorf("job\n% x, want\n% x", job, want)
+	}
+}
+
+func TestEncodeESCPOSPadding(t *testing.T) {
+	tests := []struct {
+		paper    int
+		dpi      float64
+		width    int
+		stride   int
+		firstDot int
+	}{
+		{58, 203, 384, 48, 0},
+		{58, 203, 383, 48, 0},
+		{58, 203, 1, 48, 191},
+		// 72mm at 203 DPI is 575 dots, padded to 72 whole bytes.
+		{80, 203, 575, 72, 0},
+		{80, 203, 320, 72, 127},
+		// 850 dots at 300 DPI need 107 bytes.
+		{80, 300, 600, 107, 125},
+	}
+	for _, tt := range tests {
+		bitmap := newMonoBitmap(tt.width, 1)
+		bitmap.set(0, 0)
+		job, err := encodeESCPOS(bitmap, tt.paper, tt.dpi)
+		if err != nil {
+			t.Errorf("%dmm, %d dots: %v", tt.paper, tt.width, err)
+			continue
+		}
+		bands := escposJob(t, job)
+		if len(bands) != 1 || bands[0].rows != 1 {
+			t.Errorf("%dmm, %d dots: %d bands, want 1 band of 1 row", tt.paper, tt.width, len(bands))
+			continue
+		}
+		if bands[0].stride != tt.stride {
+			t.Errorf("%dmm, %d dots: stride %d, want %d", tt.paper, tt.width, bands[0].stride, tt.stride)
+		}
+		row := bands[0].bits
+		for dot := 0; dot < 8*len(row); dot++ {
+			black := row[dot/8]&(0x80>>uint(dot%8)) != 0
+			if black != (dot == tt.firstDot) {
+				t.Errorf("%dmm, %d dots: dot %d black %v, want column 0 at dot %d", tt.paper, tt.width, dot, black, tt.firstDot)
+			}
+		}
+	}
+}
+
+func TestEncodeESCPOSBands(t *testing.T) {
+	bitmap := newMonoBitmap(384, 2000)
+	for y := 0; y < bitmap.height; y++ {
+		bitmap.set(y%bitmap.width, y)
+	}
+	job, err := encodeESCPOS(bitmap, 58, 203)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bands := escposJob(t, job)
+	var rows []int
+	var bits []byte
+	for _, band := range bands {
+		if band.stride != 48 {
+			t.Errorf("band stride %d, want 48", band.stride)
+		}
+		rows = append(rows, band.rows)
+		bits = append(bits, band.bits...)
+	}
+	if len(rows) != 3 || rows[0] != 960 || rows[1] != 960 || rows[2] != 80 {
+		t.Errorf("bands of %v rows, want [960 960 80]", rows)
+	}
+	// 960 rows are written as yL=0xc0, yH=0x03.
+	if !bytes.Contains(job, []byte{0x1d, 0x76, 0x30, 0x00, 48, 0, 0xc0, 0x03}) {
+		t.Error("no GS v 0 header for a 960 row band")
+	}
+	if !bytes.Equal(bits, bitmap.bits) {
+		t.Error("bands do not add up to the bitmap")
+	}
+}
+
+func TestEncodeESCPOSTooWide(t *testing.T) {
+	if _, err := encodeESCPOS(newMonoBitmap(385, 1), 58, 203); err == nil {
+		t.Error("385 dots accepted on 58mm paper")
+	}
+	if _, err := encodeESCPOS(newMonoBitmap(576, 1), 80, 203); err == nil {
+		t.Error("576 dots accepted on 80mm paper")
+	}
+}
@@ -1,8 +1,20 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
 	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
 	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
 	"golang.org/x/image/math/fixed"
 )
 
@@ -14,20 +26,229 @@ const (
 	alignRight
 )
 
-func newFontFace(ttf []byte, pixelSize, dpi float64) (font.Face, error) {
+func parseAlign(value string) (textAlign, bool) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "left":
+		return alignLeft, true
+	case "center":
+		return alignCenter, true
+	case "right":
+		return alignRight, true
+	}
+	return alignLeft, false
+}
+
+type labelFont struct {
+	name string
+	data []byte
+
+	parseOnce sync.Once
+	parsed    *opentype.Font
+	parseErr  error
+}
+
+var (
+	fontBold    = &labelFont{name: "Go-Bold", data: gobold.TTF}
+	fontRegular = &labelFont{name: "Go-Regular", data: goregular.TTF}
+)
+
+// customFonts holds the fonts loaded from FONT_DIR, keyed by the lower-case
+// file name without extension.
+var customFonts = map[string]*labelFont{}
+
+func lookupFont(name string) (*labelFont, bool) {
+	key := strings.ToLower(strings.TrimSpace(name))
+	switch key {
+	case "regular", "go-regular":
+		return fontRegular, true
+	case "bold", "go-bold":
+		return fontBold, true
+	}
+	f, ok := customFonts[key]
+	return f, ok
+}
+
+func fontNames() []string {
+	names := make([]string, 0, len(customFonts))
+	for _, f := range customFonts {
+		names = append(names, f.name)
+	}
+	sort.Strings(names)
+	return append([]string{"regular", "bold"}, names...)
+}
+
+func errUnknownFont(name string) error {
+	return fmt.Errorf("unknown font %q (available: %s)", name, strings.Join(fontNames(), ", "))
+}
+
+// isCFF reports whether the font has PostScript (CFF) outlines rather than
+// TrueType ones.
+func (f *labelFont) isCFF() bool {
+	return bytes.HasPrefix(f.data, []byte("OTTO"))
+}
+
+// parse returns the parsed font. It is parsed once and shared by all faces;
+// every user keeps its own sfnt.Buffer.
+func (f *labelFont) parse() (*opentype.Font, error) {
+	f.parseOnce.Do(func() {
+		f.parsed, f.parseErr = opentype.Parse(f.data)
+	})
+	return f.parsed, f.parseErr
+}
+
+func loadFonts(dir string) error {
+	if dir == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if ext != ".ttf" && ext != ".otf" {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		lf := &labelFont{name: name, data: data}
+		if _, err := lf.parse(); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		key := strings.ToLower(name)
+		if _, exists := lookupFont(key); exists {
+			return fmt.Errorf("%s: duplicate font name %q", path, name)
+		}
+		customFonts[key] = lf
+		logInfo("loaded font %q from %s", name, path)
+	}
+	return nil
+}
+
+// fontFallbacks are tried in order for runes the requested font lacks.
+var fontFallbacks []*labelFont
+
+// setFontFallbacks configures the fallback chain from a comma-separated list
+// of font names.
+func setFontFallbacks(names string) error {
+	fontFallbacks = nil
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		f, ok := lookupFont(name)
+		if !ok {
+			return errUnknownFont(name)
+		}
+		fontFallbacks = append(fontFallbacks, f)
+		logInfo("using font %q as fallback", f.name)
+	}
+	return nil
+}
+
+// newFontFace returns a face for f, falling back to the configured fonts for
+// runes f has no glyph for.
+func newFontFace(f *labelFont, pixelSize, dpi float64) (font.Face, error) {
 	if dpi <= 0 {
 		dpi = defaultDPI
 	}
 	points := pixelSize * 72.0 / dpi
-	ft, err := opentype.Parse(ttf)
-	if err != nil {
-		return nil, err
+	fonts := []*labelFont{f}
+	for _, fallback := range fontFallbacks {
+		if fallback != f {
+			fonts = append(fonts, fallback)
+		}
 	}
-	return opentype.NewFace(ft, &opentype.FaceOptions{
-		Size:    points,
-		DPI:     dpi,
-		Hinting: font.HintingFull,
-	})
+
+	chain := &fallbackFace{}
+	for _, lf := range fonts {
+		ft, err := lf.parse()
+		if err != nil {
+			return nil, err
+		}
+		face, err := opentype.NewFace(ft, &opentype.FaceOptions{
+			Size:    points,
+			DPI:     dpi,
+			Hinting: font.HintingFull,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(fonts) == 1 {
+			return face, nil
+		}
+		chain.faces = append(chain.faces, face)
+		chain.fonts = append(chain.fonts, lf)
+		chain.parsed = append(chain.parsed, ft)
+	}
+	return chain, nil
+}
+
+// fallbackFace draws every rune with the first face whose font has a glyph
+// for it. Metrics are those of the first face, so line heights do not change
+// with the text.
+type fallbackFace struct {
+	faces  []font.Face
+	fonts  []*labelFont
+	parsed []*opentype.Font
+	buf    sfnt.Buffer
+}
+
+func (f *fallbackFace) index(r rune) int {
+	for i, ft := range f.parsed {
+		if gid, err := ft.GlyphIndex(&f.buf, r); err == nil && gid != 0 {
+			return i
+		}
+	}
+	return 0
+}
+
+func (f *fallbackFace) Close() error {
+	for _, face := range f.faces {
+		face.Close()
+	}
+	return nil
+}
+
+func (f *fallbackFace) Glyph(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, image.Point, fixed.Int26_6, bool) {
+	return f.faces[f.index(r)].Glyph(dot, r)
+}
+
+func (f *fallbackFace) GlyphBounds(r rune) (fixed.Rectangle26_6, fixed.Int26_6, bool) {
+	return f.faces[f.index(r)].GlyphBounds(r)
+}
+
+func (f *fallbackFace) GlyphAdvance(r rune) (fixed.Int26_6, bool) {
+	return f.faces[f.index(r)].GlyphAdvance(r)
+}
+
+// Kern only applies between runes drawn from the same face.
+func (f *fallbackFace) Kern(r0, r1 rune) fixed.Int26_6 {
+	i := f.index(r0)
+	if i != f.index(r1) {
+		return 0
+	}
+	return f.faces[i].Kern(r0, r1)
+}
+
+func (f *fallbackFace) Metrics() font.Metrics {
+	return f.faces[0].Metrics()
+}
+
+// runeFont returns the font a text element draws r with.
+func runeFont(el labelElement, r rune) *labelFont {
+	if chain, ok := el.face.(*fallbackFace); ok {
+		return chain.fonts[chain.index(r)]
+	}
+	return el.font
 }
 
 func drawTextLines(drawer *font.Drawer, lines []string, x, topY, maxWidth int, align textAlign) int {
@@ -63,6 +284,71 @@ func drawTextLines(drawer *font.Drawer, lines []string, x, topY, maxWidth int, a
 	return lineHeight * len(lines)
 }
 
+type placedLine struct {
+	runes    []rune
+	x        []fixed.Int26_6
+	fonts    []*labelFont
+	baseline int
+}
+
+// runs splits the line into ranges [start, end) of runes that share a font.
+func (l placedLine) runs() [][2]int {
+	var runs [][2]int
+	for start := 0; start < len(l.runes); {
+		end := start + 1
+		for end < len(l.runes) && l.fonts[end] == l.fonts[start] {
+			end++
+		}
+		runs = append(runs, [2]int{start, end})
+		start = end
+	}
+	return runs
+}
+
+// placeTextLines returns the pen position of every rune of a text element,
+// following the same alignment, kerning and clipping rules as drawTextLines.
+// Vector encoders use it to match the raster output.
+func placeTextLines(el labelElement, dstHeight int) []placedLine {
+	maxWidth := maxInt(el.width, 1)
+	metrics := el.face.Metrics()
+	lineHeight := metrics.Height.Ceil()
+	ascent := metrics.Ascent.Ceil()
+	descent := metrics.Descent.Ceil()
+
+	var out []placedLine
+	for i, line := range el.lines {
+		lineWidth := font.MeasureString(el.face, line).Ceil()
+		alignedX := el.x
+		switch el.align {
+		case alignCenter:
+			alignedX = el.x + (maxWidth-lineWidth)/2
+		case alignRight:
+			alignedX = el.x + maxWidth - lineWidth
+		}
+		y := el.y + ascent + i*lineHeight
+		if y+descent > dstHeight || line == "" {
+			continue
+		}
+
+		placed := placedLine{baseline: y}
+		dot := fixed.I(alignedX)
+		prev := rune(-1)
+		for _, r := range line {
+			if prev >= 0 {
+				dot += el.face.Kern(prev, r)
+			}
+			placed.runes = append(placed.runes, r)
+			placed.x = append(placed.x, dot)
+			placed.fonts = append(placed.fonts, runeFont(el, r))
+			advance, _ := el.face.GlyphAdvance(r)
+			dot += advance
+			prev = r
+		}
+		out = append(out, placed)
+	}
+	return out
+}
+
 func textBlockHeight(face font.Face, lines int) int {
 	if lines <= 0 {
 		return 0
@@ -1,67 +1,136 @@
 package main
 
 import (
+	"errors"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 )
 
 func labelHandler(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
-	remoteAddr := r.RemoteAddr
-	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
-		remoteAddr = forwarded
-	}
+	logInfo("%s %s from %s", r.Method, r.URL.Path, clientAddr(r))
 
-	logInfo("%s %s from %s", r.Method, r.URL.Path, remoteAddr)
-
-	if r.Method != http.MethodGet {
-		logError("method not allowed: %s (expected GET)", r.Method)
-		w.Header().Set("Allow", http.MethodGet)
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		logError("method not allowed: %s (expected GET or POST)", r.Method)
+		w.Header().Set("Allow", "GET, POST")
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	params, err := parseLabelParams(r.URL.Query())
+	values := r.URL.Query()
+	if r.Method == http.MethodPost {
+		body, err := decodeLabel(http.MaxBytesReader(w, r.Body, int64(envInt("HBOX_WEB_MAX_UPLOAD_SIZE", defaultMaxUpload))))
+		if err != nil {
+			logError("label body decoding failed: %v", err)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mergeValues(values, body)
+	}
+
+	params, err := parseLabelParams(values)
 	if err != nil {
 		logError("parameter parsing failed: %v", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	logDebug("params: size=%dx%d dpi=%.1f margin=%d padding=%d qrSize=%d title=%q secondary=%q id=%q url=%q",
+	opts, err := parseOutputOptions(values, r.Header.Get("Accept"), params.media)
+	if err != nil {
+		logError("output option parsing failed: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if opts.sheet != nil {
+		params = opts.sheet.cellParams(params)
+	}
+	if opts.format == formatZPL {
+		// ^BQ prints a whole number of dots per module, so the layout
+		// reserves exactly that size.
+		params.qrSnap = true
+	}
+
+	logDebug("params: size=%dx%d dpi=%.1f margin=%d padding=%d qrSize=%d title=%q secondary=%q id=%q url=%q template=%q",
 		params.width, params.height, params.dpi, params.margin, params.padding,
-		params.qrSize, params.titleText, params.secondaryText, params.idText, shortURLFrom(params.url))
+		params.qrSize, params.titleText, params.secondaryText, params.idText, shortURLFrom(params.url), params.template)
 
-	img, err := renderLabel(params)
+	layout, err := layoutLabel(params)
 	if err != nil {
 		logError("rendering failed: %v", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if err := checkLayout(layout, opts); err != nil {
+		logError("label does not fit output: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	maxUpload := envInt("HBOX_WEB_MAX_UPLOAD_SIZE", defaultMaxUpload)
-	pngData, err := encodePNGWithDPI(img, params.dpi)
+	var data []byte
+	if opts.sheet != nil {
+		data, err = encodeSheet(opts.sheet, opts.skip, repeatLabels([]labelParams{params}, opts.copies), opts, maxUpload)
+	} else {
+		data, err = encodeLayout(layout, opts)
+	}
+	if errors.Is(err, errOutputTooLarge) {
+		logError("sheet output exceeds maximum %d bytes", maxUpload)
+		http.Error(w, "image exceeds maximum upload size", http.StatusRequestEntityTooLarge)
+		return
+	}
 	if err != nil {
-		logError("PNG encoding failed: %v", err)
+		logError("%s encoding failed: %v", strings.ToUpper(opts.format), err)
 		http.Error(w, "failed to encode image", http.StatusInternalServerError)
 		return
 	}
 
-	if len(pngData) > maxUpload {
-		logError("image size %d bytes exceeds maximum %d bytes", len(pngData), maxUpload)
+	if len(data) > maxUpload {
+		logError("image size %d bytes exceeds maximum %d bytes", len(data), maxUpload)
 		http.Error(w, "image exceeds maximum upload size", http.StatusRequestEntityTooLarge)
 		return
 	}
 
 	duration := time.Since(startTime)
-	logInfo("generated %dx%d PNG (%d bytes, %.1f DPI) in %v",
-		params.width, params.height, len(pngData), params.dpi, duration)
+	width, height := layout.outputSize()
+	logInfo("generated %dx%d %s (%d bytes, %.1f DPI) in %v",
+		width, height, strings.ToUpper(opts.format), len(data), params.dpi, duration)
 
-	w.Header().Set("Content-Type", "image/png")
-	w.Header().Set("Content-Length", strconv.Itoa(len(pngData)))
+	w.Header().Set("Content-Type", formatContentTypes[opts.format])
+	if module := layout.qrModuleSize(); module > 0 {
+		w.Header().Set("X-Qr-Module-Size", strconv.Itoa(module))
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
 	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(pngData)
+	_, _ = w.Write(data)
+}
+
+// mergeValues replaces parameters in dst with those in src, matching names
+// case-insensitively.
+func mergeValues(dst, src url.Values) {
+	for key, list := range src {
+		for k := range dst {
+			if strings.EqualFold(k, key) {
+				delete(dst, k)
+			}
+		}
+		dst[key] = list
+	}
+}
+
+func clientAddr(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		return forwarded
+	}
+	return r.RemoteAddr
 }
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
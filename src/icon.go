@@ -1,45 +1,233 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
 )
 
-func drawOpenBoxIcon(img *image.RGBA, x, y, w, h int) {
-	if w <= 0 || h <= 0 {
-		return
+type lineSegment struct {
+	x0, y0, x1, y1 int
+}
+
+// labelIcon is an icon for the right column. Vector icons are polylines in a
+// unit square, so raster and vector encoders draw the same outline; PNG
+// icons from ICON_DIR are scaled bitmaps.
+type labelIcon struct {
+	name  string
+	paths []iconPath
+	image image.Image
+}
+
+// iconPath is a polyline with coordinates from 0 to 1.
+type iconPath [][2]float64
+
+const iconNone = "none"
+
+var builtinIcons = []*labelIcon{
+	{name: "box", paths: []iconPath{
+		{{0.18, 0.45}, {0.82, 0.45}, {0.82, 0.85}, {0.18, 0.85}, {0.18, 0.45}},
+		{{0.18, 0.45}, {0.05, 0.3}, {0.5, 0.1}, {0.95, 0.3}, {0.82, 0.45}},
+		{{0.5, 0.1}, {0.5, 0.45}},
+	}},
+	{name: "shelf", paths: []iconPath{
+		{{0.15, 0.1}, {0.85, 0.1}, {0.85, 0.9}, {0.15, 0.9}, {0.15, 0.1}},
+		{{0.15, 0.37}, {0.85, 0.37}},
+		{{0.15, 0.63}, {0.85, 0.63}},
+		{{0.28, 0.37}, {0.28, 0.18}, {0.38, 0.18}, {0.38, 0.37}},
+		{{0.44, 0.37}, {0.52, 0.2}},
+		{{0.6, 0.63}, {0.6, 0.46}, {0.72, 0.46}, {0.72, 0.63}},
+	}},
+	{name: "drawer", paths: []iconPath{
+		{{0.1, 0.15}, {0.9, 0.15}, {0.9, 0.85}, {0.1, 0.85}, {0.1, 0.15}},
+		{{0.1, 0.5}, {0.9, 0.5}},
+		{{0.4, 0.33}, {0.6, 0.33}},
+		{{0.4, 0.67}, {0.6, 0.67}},
+	}},
+	{name: "tool", paths: []iconPath{
+		{{0.15, 0.15}, {0.7, 0.15}, {0.7, 0.35}, {0.15, 0.35}, {0.15, 0.15}},
+		{{0.36, 0.35}, {0.36, 0.9}, {0.49, 0.9}, {0.49, 0.35}},
+		{{0.7, 0.2}, {0.85, 0.12}},
+		{{0.7, 0.3}, {0.85, 0.38}},
+	}},
+	{name: "warning", paths: []iconPath{
+		{{0.5, 0.1}, {0.92, 0.85}, {0.08, 0.85}, {0.5, 0.1}},
+		{{0.5, 0.36}, {0.5, 0.6}},
+		{{0.5, 0.71}, {0.5, 0.73}},
+	}},
+	{name: "battery", paths: []iconPath{
+		{{0.1, 0.3}, {0.82, 0.3}, {0.82, 0.7}, {0.1, 0.7}, {0.1, 0.3}},
+		{{0.82, 0.42}, {0.9, 0.42}, {0.9, 0.58}, {0.82, 0.58}},
+		{{0.25, 0.4}, {0.25, 0.6}},
+		{{0.4, 0.4}, {0.4, 0.6}},
+		{{0.55, 0.4}, {0.55, 0.6}},
+	}},
+	{name: "pin", paths: pinIconPaths()},
+}
+
+// pinIconPaths returns a location pin: a circle that narrows to a point,
+// with a hole in the middle.
+func pinIconPaths() []iconPath {
+	const cx, cy, r, tipY = 0.5, 0.36, 0.27, 0.9
+	// The sides touch the circle where they are tangent to it.
+	spread := math.Acos(r / (tipY - cy))
+	outline := arcPath(cx, cy, r, math.Pi/2+spread, 2*math.Pi+math.Pi/2-spread, 24)
+	outline = append(iconPath{{cx, tipY}}, outline...)
+	outline = append(outline, [2]float64{cx, tipY})
+	return []iconPath{outline, arcPath(cx, cy, 0.1, 0, 2*math.Pi, 12)}
+}
+
+// arcPath approximates a circular arc from angle a0 to a1 (radians, y down).
+func arcPath(cx, cy, r, a0, a1 float64, steps int) iconPath {
+	path := make(iconPath, 0, steps+1)
+	for i := 0; i <= steps; i++ {
+		a := a0 + (a1-a0)*float64(i)/float64(steps)
+		path = append(path, [2]float64{cx + r*math.Cos(a), cy + r*math.Sin(a)})
+	}
+	return path
+}
+
+var customIcons = map[string]*labelIcon{}
+
+// lookupIcon finds a built-in or ICON_DIR icon by case-insensitive name.
+// The "none" icon is nil.
+func lookupIcon(name string) (*labelIcon, bool) {
+	key := strings.ToLower(strings.TrimSpace(name))
+	if key == iconNone {
+		return nil, true
+	}
+	for _, icon := range builtinIcons {
+		if icon.name == key {
+			return icon, true
+		}
 	}
-	thickness := w / 14
-	if thickness < 2 {
-		thickness = 2
+	icon, ok := customIcons[key]
+	return icon, ok
+}
+
+func defaultIcon() *labelIcon {
+	return builtinIcons[0]
+}
+
+func iconNames() []string {
+	names := make([]string, 0, len(customIcons))
+	for _, icon := range customIcons {
+		names = append(names, icon.name)
 	}
-	if thickness > 5 {
-		thickness = 5
+	sort.Strings(names)
+	builtin := make([]string, 0, len(builtinIcons)+1)
+	for _, icon := range builtinIcons {
+		builtin = append(builtin, icon.name)
 	}
+	return append(append(builtin, iconNone), names...)
+}
 
-	frontLeftX := x + int(float64(w)*0.18)
-	frontRightX := x + int(float64(w)*0.82)
-	frontTopY := y + int(float64(h)*0.45)
-	frontBottomY := y + int(float64(h)*0.85)
+func errUnknownIcon(name string) error {
+	return fmt.Errorf("unknown icon %q (available: %s)", name, strings.Join(iconNames(), ", "))
+}
+
+// loadIcons registers every PNG and SVG file in dir under its file name.
+func loadIcons(dir string) error {
+	if dir == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if ext != ".png" && ext != ".svg" {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		icon := &labelIcon{name: name}
+		if ext == ".png" {
+			icon.image, err = png.Decode(bytes.NewReader(data))
+		} else {
+			icon.paths, err = parseSVGIcon(data)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		key := strings.ToLower(name)
+		if _, exists := lookupIcon(key); exists {
+			return fmt.Errorf("%s: duplicate icon name %q", path, name)
+		}
+		customIcons[key] = icon
+		logInfo("loaded icon %q from %s", name, path)
+	}
+	return nil
+}
 
-	flapLeftX := x + int(float64(w)*0.05)
-	flapRightX := x + int(float64(w)*0.95)
-	flapSideY := y + int(float64(h)*0.3)
-	flapPeakX := x + w/2
-	flapPeakY := y + int(float64(h)*0.1)
+func drawIcon(img *image.RGBA, icon *labelIcon, x, y, w, h int) {
+	if icon.image != nil {
+		scaled := icon.scaled(w, h)
+		draw.Draw(img, scaled.Bounds().Add(image.Pt(x, y)), scaled, image.Point{}, draw.Over)
+		return
+	}
+	thickness := iconThickness(w)
+	for _, seg := range icon.segments(x, y, w, h) {
+		drawLine(img, seg.x0, seg.y0, seg.x1, seg.y1, thickness)
+	}
+}
 
-	drawLine(img, frontLeftX, frontTopY, frontRightX, frontTopY, thickness)
-	drawLine(img, frontRightX, frontTopY, frontRightX, frontBottomY, thickness)
-	drawLine(img, frontRightX, frontBottomY, frontLeftX, frontBottomY, thickness)
-	drawLine(img, frontLeftX, frontBottomY, frontLeftX, frontTopY, thickness)
+// segments maps the icon outline onto the box at (x, y) as line segments.
+func (icon *labelIcon) segments(x, y, w, h int) []lineSegment {
+	if w <= 0 || h <= 0 {
+		return nil
+	}
+	px := func(p [2]float64) (int, int) {
+		return x + int(float64(w)*p[0]), y + int(float64(h)*p[1])
+	}
+	var segments []lineSegment
+	for _, path := range icon.paths {
+		for i := 1; i < len(path); i++ {
+			x0, y0 := px(path[i-1])
+			x1, y1 := px(path[i])
+			segments = append(segments, lineSegment{x0, y0, x1, y1})
+		}
+	}
+	return segments
+}
 
-	drawLine(img, frontLeftX, frontTopY, flapLeftX, flapSideY, thickness)
-	drawLine(img, flapLeftX, flapSideY, flapPeakX, flapPeakY, thickness)
-	drawLine(img, flapPeakX, flapPeakY, flapRightX, flapSideY, thickness)
-	drawLine(img, flapRightX, flapSideY, frontRightX, frontTopY, thickness)
+// scaled fits a PNG icon into w x h, keeping its aspect ratio and centring
+// it. Uncovered pixels stay transparent.
+func (icon *labelIcon) scaled(w, h int) *image.NRGBA {
+	out := image.NewNRGBA(image.Rect(0, 0, w, h))
+	bounds := icon.image.Bounds()
+	if bounds.Empty() || w <= 0 || h <= 0 {
+		return out
+	}
+	scale := math.Min(float64(w)/float64(bounds.Dx()), float64(h)/float64(bounds.Dy()))
+	sw := maxInt(1, int(math.Round(float64(bounds.Dx())*scale)))
+	sh := maxInt(1, int(math.Round(float64(bounds.Dy())*scale)))
+	dst := image.Rect((w-sw)/2, (h-sh)/2, (w-sw)/2+sw, (h-sh)/2+sh)
+	xdraw.CatmullRom.Scale(out, dst, icon.image, bounds, xdraw.Src, nil)
+	return out
+}
 
-	drawLine(img, flapPeakX, flapPeakY, flapPeakX, frontTopY, thickness)
+// iconThickness is the stroke width for vector icons drawn w pixels wide.
+func iconThickness(w int) int {
+	return clampInt(w/14, 2, 5)
 }
 
 func drawLine(img *image.RGBA, x0, y0, x1, y1, thickness int) {
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/skip2/go-qrcode"
+	"golang.org/x/image/font"
+)
+
+type elementKind int
+
+const (
+	elementText elementKind = iota
+	elementQR
+	elementIcon
+	elementBarcode
+	elementMatrix
+	elementLine
+	elementBox
+)
+
+// labelElement is a single positioned item on a label. Layouts only decide
+// where elements go; encoders decide how each kind is drawn.
+type labelElement struct {
+	kind      elementKind
+	x         int
+	y         int
+	x2        int
+	y2        int
+	width     int
+	height    int
+	lines     []string
+	font      *labelFont
+	fontSize  float64
+	face      font.Face
+	align     textAlign
+	data      string
+	qr        *qrcode.QRCode
+	qrModule  int
+	icon      *labelIcon
+	modules   []bool
+	matrix    [][]bool
+	symbology string
+	thickness int
+	filled    bool
+}
+
+// labelLayout is positioned in the logical orientation; rotation turns it
+// clockwise by 0, 90, 180 or 270 degrees for output.
+type labelLayout struct {
+	width    int
+	height   int
+	dpi      float64
+	rotation int
+	elements []labelElement
+}
+
+// outputSize returns the physical size of the label after rotation.
+func (l *labelLayout) outputSize() (int, int) {
+	if l.rotation == 90 || l.rotation == 270 {
+		return l.height, l.width
+	}
+	return l.width, l.height
+}
+
+// rotationMatrix maps layout coordinates to output coordinates as
+// a, b, c, d, e, f with x' = a*x + c*y + e and y' = b*x + d*y + f.
+func (l *labelLayout) rotationMatrix() [6]int {
+	switch l.rotation {
+	case 90:
+		return [6]int{0, 1, -1, 0, l.height, 0}
+	case 180:
+		return [6]int{-1, 0, 0, -1, l.width, l.height}
+	case 270:
+		return [6]int{0, -1, 1, 0, 0, l.width}
+	}
+	return [6]int{1, 0, 0, 1, 0, 0}
+}
+
+// rotateRect maps a rectangle from layout to output coordinates.
+func (l *labelLayout) rotateRect(r image.Rectangle) image.Rectangle {
+	m := l.rotationMatrix()
+	return image.Rect(
+		m[0]*r.Min.X+m[2]*r.Min.Y+m[4], m[1]*r.Min.X+m[3]*r.Min.Y+m[5],
+		m[0]*r.Max.X+m[2]*r.Max.Y+m[4], m[1]*r.Max.X+m[3]*r.Max.Y+m[5],
+	)
+}
+
+// qrModuleSize returns the pixels per module of the first QR code that was
+// snapped to whole pixels, or 0.
+func (l *labelLayout) qrModuleSize() int {
+	for _, el := range l.elements {
+		if el.kind == elementQR && el.qrModule > 0 {
+			return el.qrModule
+		}
+	}
+	return 0
+}
+
+func newLabelLayout(params labelParams) *labelLayout {
+	return &labelLayout{
+		width:  params.width,
+		height: params.height,
+		dpi:    params.dpi,
+	}
+}
+
+func (l *labelLayout) add(el labelElement) {
+	l.elements = append(l.elements, el)
+}
+
+// addText adds lines of text in logical order; they are stored in display
+// order.
+func (l *labelLayout) addText(face font.Face, f *labelFont, size float64, lines []string, x, y, maxWidth int, align textAlign) {
+	visual := make([]string, len(lines))
+	for i, line := range lines {
+		visual[i] = visualOrder(line)
+	}
+	l.add(labelElement{
+		kind:     elementText,
+		x:        x,
+		y:        y,
+		width:    maxWidth,
+		height:   textBlockHeight(face, len(lines)),
+		lines:    visual,
+		font:     f,
+		fontSize: size,
+		face:     face,
+		align:    align,
+	})
+}
+
+func buildLayout(params labelParams) (*labelLayout, error) {
+	if params.template != "" && params.template != defaultTemplateName {
+		tpl, ok := lookupTemplate(params.template)
+		if !ok {
+			return nil, errUnknownTemplate(params.template)
+		}
+		logDebug("using template %q", tpl.Name)
+		if params.dynamicLength {
+			logDebug("template %q has a fixed size; ignoring DynamicLength", tpl.Name)
+		}
+		return tpl.layout(params)
+	}
+	switch pickLayout(params) {
+	case layoutModeVertical:
+		logDebug("using vertical layout")
+		return layoutVertical(params)
+	case layoutModeStrip:
+		logDebug("using strip layout")
+		return layoutStrip(params)
+	case layoutModeSquare:
+		logDebug("using square layout")
+		return layoutSquare(params)
+	}
+	return layoutDefault(params)
+}
+
+func drawLayout(layout *labelLayout) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, layout.width, layout.height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+
+	for _, el := range layout.elements {
+		switch el.kind {
+		case elementText:
+			drawer := &font.Drawer{Dst: img, Src: image.Black, Face: el.face}
+			drawTextLines(drawer, el.lines, el.x, el.y, el.width, el.align)
+		case elementQR:
+			if el.qrModule > 0 {
+				drawMatrix(img, el.qr.Bitmap(), el.x, el.y, el.width)
+				continue
+			}
+			qrImg := el.qr.Image(el.width)
+			qrRect := image.Rect(el.x, el.y, el.x+el.width, el.y+el.width)
+			draw.Draw(img, qrRect, qrImg, image.Point{}, draw.Src)
+		case elementIcon:
+			drawIcon(img, el.icon, el.x, el.y, el.width, el.height)
+		case elementBarcode:
+			drawBarcode(img, el.modules, el.x, el.y, el.width, el.height)
+		case elementMatrix:
+			drawMatrix(img, el.matrix, el.x, el.y, el.width)
+		case elementLine:
+			drawLine(img, el.x, el.y, el.x2, el.y2, el.thickness)
+		case elementBox:
+			drawBox(img, el.x, el.y, el.width, el.height, el.thickness, el.filled)
+		}
+	}
+	return rotateImage(img, layout.rotation)
+}
+
+// rotateImage turns img clockwise by 90, 180 or 270 degrees.
+func rotateImage(img *image.RGBA, rotation int) *image.RGBA {
+	if rotation != 90 && rotation != 180 && rotation != 270 {
+		return img
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	outW, outH := w, h
+	if rotation != 180 {
+		outW, outH = h, w
+	}
+	out := image.NewRGBA(image.Rect(0, 0, outW, outH))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := x, y
+			switch rotation {
+			case 90:
+				dx, dy = h-1-y, x
+			case 180:
+				dx, dy = w-1-x, h-1-y
+			case 270:
+				dx, dy = y, w-1-x
+			}
+			copy(out.Pix[out.PixOffset(dx, dy):out.PixOffset(dx, dy)+4], img.Pix[img.PixOffset(x, y):img.PixOffset(x, y)+4])
+		}
+	}
+	return out
+}
+
+func drawBox(img *image.RGBA, x, y, w, h, thickness int, filled bool) {
+	if filled {
+		fillRect(img, x, y, w, h, color.Black)
+		return
+	}
+	if thickness < 1 {
+		thickness = 1
+	}
+	fillRect(img, x, y, w, thickness, color.Black)
+	fillRect(img, x, y+h-thickness, w, thickness, color.Black)
+	fillRect(img, x, y, thickness, h, color.Black)
+	fillRect(img, x+w-thickness, y, thickness, h, color.Black)
+}
@@ -0,0 +1,618 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/image/font"
+)
+
+const (
+	layoutModeAuto      = "auto"
+	layoutModeLandscape = "landscape"
+	layoutModeVertical  = "vertical"
+	layoutModeStrip     = "strip"
+	layoutModeSquare    = "square"
+)
+
+func parseLayoutMode(value string) (string, bool) {
+	switch mode := strings.ToLower(strings.TrimSpace(value)); mode {
+	case "":
+		return layoutModeLandscape, true
+	case layoutModeAuto, layoutModeLandscape, layoutModeVertical, layoutModeStrip, layoutModeSquare:
+		return mode, true
+	}
+	return "", false
+}
+
+// pickLayout resolves Layout=auto from the aspect ratio of the label. Labels
+// that grow with their content keep the landscape layout. Without Layout the
+// landscape layout is used whatever the shape.
+func pickLayout(params labelParams) string {
+	if params.layout != layoutModeAuto {
+		return params.layout
+	}
+	if params.dynamicLength {
+		return layoutModeLandscape
+	}
+	ratio := float64(params.width) / float64(params.height)
+	switch {
+	case ratio >= 2.5:
+		return layoutModeStrip
+	case ratio < 0.8:
+		return layoutModeVertical
+	case ratio <= 1.25:
+		return layoutModeSquare
+	}
+	return layoutModeLandscape
+}
+
+// layoutText holds the texts and faces shared by the vertical, strip and
+// square layouts.
+type layoutText struct {
+	params      labelParams
+	title       string
+	secondary   string
+	id          string
+	fields      []string
+	descFace    font.Face
+	idLabelFace font.Face
+	idValueFace font.Face
+	idLabelSize float64
+	idValueSize float64
+}
+
+func newLayoutText(params labelParams) (*layoutText, error) {
+	t := &layoutText{
+		params:      params,
+		title:       shapeArabic(strings.TrimSpace(params.titleText)),
+		secondary:   shapeArabic(strings.TrimSpace(params.secondaryText)),
+		id:          strings.TrimSpace(params.idText),
+		fields:      fieldRowTexts(params.fields),
+		idLabelSize: maxFloat(params.descriptionFontSize*0.85, 11.0),
+		idValueSize: maxFloat(params.descriptionFontSize*1.4, params.descriptionFontSize+4.0),
+	}
+	var err error
+	if t.descFace, err = newFontFace(params.descriptionFont, params.descriptionFontSize, params.dpi); err != nil {
+		return nil, err
+	}
+	if t.idLabelFace, err = newFontFace(params.descriptionFont, t.idLabelSize, params.dpi); err != nil {
+		return nil, err
+	}
+	if t.idValueFace, err = newFontFace(params.titleFont, t.idValueSize, params.dpi); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// align keeps centred text centred and turns left-aligned right-to-left
+// text to the right.
+func (t *layoutText) align(align textAlign, text string) textAlign {
+	if align == alignCenter {
+		return align
+	}
+	return mirrorAlign(align, text)
+}
+
+// addTitle places the title within width and at most maxHeight pixels (no
+// limit if maxHeight <= 0), honouring TitleMaxLines and TitleMinFontSize. It
+// returns the height used.
+func (t *layoutText) addTitle(layout *labelLayout, x, y, width, maxHeight int, align textAlign) (int, error) {
+	if t.title == "" || width < 1 {
+		return 0, nil
+	}
+	p := t.params
+	minSize := p.titleMinFontSize
+	if minSize <= 0 || minSize > p.titleFontSize {
+		minSize = p.titleFontSize
+	}
+	maxLines := maxInt(p.titleMaxLines, 1)
+	var face font.Face
+	var size float64
+	var lines []string
+	var err error
+	if maxHeight <= 0 && p.titleMaxLines == 0 {
+		size = p.titleFontSize
+		if face, err = newFontFace(p.titleFont, size, p.dpi); err != nil {
+			return 0, err
+		}
+		lines = wrapText(t.title, width, &font.Drawer{Face: face})
+	} else {
+		if face, size, lines, err = fitText(t.title, p.titleFont, p.titleFontSize, minSize, p.dpi, width, maxLines); err != nil {
+			return 0, err
+		}
+		if fit := maxHeight / textBlockHeight(face, 1); maxHeight > 0 && fit < len(lines) {
+			lines = wrapLines(t.title, width, maxInt(fit, 1), &font.Drawer{Face: face})
+		}
+	}
+	layout.addText(face, p.titleFont, size, lines, x, y, width, t.align(align, t.title))
+	return textBlockHeight(face, len(lines)), nil
+}
+
+// addSecondary places the secondary text: one line, or with
+// DescriptionMode=block as many lines as fit maxHeight (no limit if
+// maxHeight <= 0). It returns the height used.
+func (t *layoutText) addSecondary(layout *labelLayout, x, y, width, maxHeight int, align textAlign) int {
+	lineHeight := textBlockHeight(t.descFace, 1)
+	if t.secondary == "" || width < 1 || maxHeight > 0 && maxHeight < lineHeight {
+		return 0
+	}
+	drawer := &font.Drawer{Face: t.descFace}
+	var lines []string
+	switch {
+	case t.params.descriptionBlock:
+		maxLines := 0
+		if maxHeight > 0 {
+			maxLines = maxHeight / lineHeight
+		}
+		lines = wrapBlock(t.secondary, width, maxLines, drawer)
+	case maxHeight <= 0:
+		lines = wrapText(t.secondary, width, drawer)
+	default:
+		lines = []string{truncateWithEllipsis(t.secondary, width, drawer)}
+	}
+	if len(lines) == 0 {
+		return 0
+	}
+	layout.addText(t.descFace, t.params.descriptionFont, t.params.descriptionFontSize, lines, x, y, width, t.align(align, t.secondary))
+	return textBlockHeight(t.descFace, len(lines))
+}
+
+// addFields places as many custom field rows as fit maxHeight (no limit if
+// maxHeight <= 0) and returns the height used.
+func (t *layoutText) addFields(layout *labelLayout, x, y, width, maxHeight int, align textAlign) int {
+	if len(t.fields) == 0 || width < 1 {
+		return 0
+	}
+	maxRows := len(t.fields)
+	if maxHeight > 0 {
+		maxRows = maxHeight / textBlockHeight(t.idLabelFace, 1)
+	}
+	rows := fitFieldRows(t.fields, maxRows, width, &font.Drawer{Face: t.idLabelFace})
+	if len(rows) == 0 {
+		return 0
+	}
+	layout.addText(t.idLabelFace, t.params.descriptionFont, t.idLabelSize, rows, x, y, width, t.align(align, rows[0]))
+	return textBlockHeight(t.idLabelFace, len(rows))
+}
+
+func (t *layoutText) idHeight() int {
+	if t.id == "" {
+		return 0
+	}
+	return textBlockHeight(t.idLabelFace, 1) + t.idGap() + textBlockHeight(t.idValueFace, 1)
+}
+
+func (t *layoutText) idGap() int {
+	return maxInt(2, t.params.padding/2)
+}
+
+// idWidth is the width of the wider ID block line.
+func (t *layoutText) idWidth() int {
+	if t.id == "" {
+		return 0
+	}
+	return maxInt((&font.Drawer{Face: t.idLabelFace}).MeasureString("ID").Ceil(),
+		(&font.Drawer{Face: t.idValueFace}).MeasureString(t.id).Ceil())
+}
+
+func (t *layoutText) addID(layout *labelLayout, x, y, width int, align textAlign) {
+	if t.id == "" || width < 1 {
+		return
+	}
+	p := t.params
+	layout.addText(t.idLabelFace, p.descriptionFont, t.idLabelSize, []string{"ID"}, x, y, width, align)
+	layout.addText(t.idValueFace, p.titleFont, t.idValueSize, []string{t.id}, x, y+textBlockHeight(t.idLabelFace, 1)+t.idGap(), width, align)
+}
+
+// codeSize returns the symbol size for a w x h box: the largest square up to
+// QrSize for 2D codes, snapped with QrScaling=snap, or the full width at half
+// that height for linear barcodes.
+func codeSize(params labelParams, w, h int) (int, int) {
+	size := minInt(w, h)
+	if params.qrSize > 0 {
+		size = minInt(size, params.qrSize)
+	}
+	if size <= 0 {
+		return 0, 0
+	}
+	if isLinearSymbology(params.barcode) {
+		return w, maxInt(1, size/2)
+	}
+	opts := params.codeOptions()
+	if opts.snapQR && params.barcode == symbologyQR {
+		if snapped, module, err := snapQRSize(codeValue(params), opts, size); err == nil {
+			logDebug("snapped QR code from %dpx to %dpx (%dpx per module)", size, snapped, module)
+			size = snapped
+		}
+	}
+	return size, size
+}
+
+// addIcon centres the icon in the w x h box as the largest square up to
+// maxSize (no limit if maxSize <= 0), unless that is below the 12 pixel
+// minimum of the landscape layout.
+func addIcon(layout *labelLayout, params labelParams, x, y, w, h, maxSize int) {
+	if params.icon == nil {
+		logDebug("skipping icon (Icon=none)")
+		return
+	}
+	size := minInt(w, h)
+	if maxSize > 0 {
+		size = minInt(size, maxSize)
+	}
+	if size < 12 {
+		logDebug("skipping icon (size %d < minimum 12)", size)
+		return
+	}
+	x, y = x+(w-size)/2, y+(h-size)/2
+	logDebug("rendering icon %q: %dx%d at (%d,%d)", params.icon.name, size, size, x, y)
+	layout.add(labelElement{kind: elementIcon, x: x, y: y, width: size, height: size, icon: params.icon})
+}
+
+func addCode(layout *labelLayout, params labelParams, x, y, w, h int) error {
+	data := codeValue(params)
+	switch {
+	case w <= 0 || h <= 0:
+		logDebug("skipping %s code (size would be 0)", params.barcode)
+	case params.barcode != symbologyQR && strings.TrimSpace(data) == "":
+		logDebug("skipping %s code (nothing to encode)", params.barcode)
+	default:
+		logDebug("rendering %s code: %dx%d at (%d,%d)", params.barcode, w, h, x, y)
+		code, err := newCodeElement(params.barcode, data, params.codeOptions(), x, y, w, h)
+		if err != nil {
+			return err
+		}
+		layout.add(code)
+	}
+	return nil
+}
+
+func innerSize(params labelParams) (int, int, error) {
+	innerWidth := params.width - 2*params.margin
+	innerHeight := params.height - 2*params.margin
+	if innerWidth < 1 || innerHeight < 1 {
+		logError("invalid inner size after margins: %dx%d (margin=%d)", innerWidth, innerHeight, params.margin)
+		return 0, 0, errors.New("invalid label size")
+	}
+	return innerWidth, innerHeight, nil
+}
+
+// layoutVertical stacks the title, secondary text, field rows, code, icon and
+// ID block centred, for tall labels and narrow tape. The icon takes the space
+// left under the code. With DynamicLength the label grows to fit all of it.
+func layoutVertical(params labelParams) (*labelLayout, error) {
+	layout := newLabelLayout(params)
+	innerWidth, innerHeight, err := innerSize(params)
+	if err != nil {
+		return nil, err
+	}
+	t, err := newLayoutText(params)
+	if err != nil {
+		return nil, err
+	}
+	x := params.margin
+	gap := maxInt(4, params.padding/2)
+	// Text takes at most a third of the height each, so the code keeps room.
+	limit := innerHeight / 3
+	if params.dynamicLength {
+		limit = 0
+	}
+
+	y := params.margin
+	used, err := t.addTitle(layout, x, y, innerWidth, limit, alignCenter)
+	if err != nil {
+		return nil, err
+	}
+	y += used
+	for _, add := range []func(y int) int{
+		func(y int) int { return t.addSecondary(layout, x, y, innerWidth, limit, alignCenter) },
+		func(y int) int { return t.addFields(layout, x, y, innerWidth, limit, alignCenter) },
+	} {
+		top := y
+		if y > params.margin {
+			top += gap
+		}
+		if used := add(top); used > 0 {
+			y = top + used
+		}
+	}
+	if y > params.margin {
+		y += params.padding
+	}
+
+	idHeight := t.idHeight()
+	idSpace := 0
+	if idHeight > 0 {
+		idSpace = params.padding + idHeight
+	}
+	labelHeight := params.height
+	// The icon is at most half the size of the code and a third of the width.
+	maxIcon := func(codeH int) int {
+		if codeH > 0 {
+			return minInt(innerWidth/3, codeH/2)
+		}
+		return innerWidth / 3
+	}
+	var codeW, codeH, iconSize int
+	if params.dynamicLength {
+		codeW, codeH = codeSize(params, innerWidth, innerWidth)
+		if params.icon != nil && maxIcon(codeH) >= 12 {
+			iconSize = maxIcon(codeH)
+		}
+		labelHeight = y + codeH + idSpace + params.margin
+		if iconSize > 0 {
+			labelHeight += params.padding + iconSize
+		}
+		logDebug("dynamic length: label height %d (requested %d)", labelHeight, params.height)
+		layout.height = labelHeight
+	} else {
+		codeW, codeH = codeSize(params, innerWidth, labelHeight-params.margin-idSpace-y)
+		if params.icon != nil {
+			iconSize = minInt(maxIcon(codeH), labelHeight-params.margin-idSpace-y-codeH-params.padding)
+		}
+	}
+	// The code and icon are centred in the space between the text and the ID
+	// block.
+	codeBottom := labelHeight - params.margin - idSpace
+	groupHeight := codeH
+	if iconSize >= 12 {
+		groupHeight += params.padding + iconSize
+	}
+	codeY := y + maxInt(0, (codeBottom-y-groupHeight)/2)
+	if err := addCode(layout, params, x+(innerWidth-codeW)/2, codeY, codeW, codeH); err != nil {
+		return nil, err
+	}
+	if params.icon != nil {
+		addIcon(layout, params, x, codeY+codeH+params.padding, innerWidth, iconSize, 0)
+	}
+	t.addID(layout, x, labelHeight-params.margin-idHeight, innerWidth, alignCenter)
+	return layout, nil
+}
+
+// layoutStrip puts the code on the left at the full inner height, the icon
+// on the right and the text between them, for short labels on narrow tape.
+// The title comes first, the ID block goes to the bottom right of the text
+// and secondary text and field rows fill the space between them.
+func layoutStrip(params labelParams) (*labelLayout, error) {
+	layout := newLabelLayout(params)
+	innerWidth, innerHeight, err := innerSize(params)
+	if err != nil {
+		return nil, err
+	}
+	t, err := newLayoutText(params)
+	if err != nil {
+		return nil, err
+	}
+	if params.dynamicLength {
+		return layoutStripDynamic(layout, params, t, innerWidth)
+	}
+	gap := maxInt(4, params.padding/2)
+	colGap := maxInt(params.padding, 4)
+
+	boxWidth := innerHeight
+	if isLinearSymbology(params.barcode) {
+		boxWidth = innerWidth / 2
+	}
+	codeW, codeH := codeSize(params, minInt(boxWidth, innerWidth/2), innerHeight)
+	if err := addCode(layout, params, params.margin, params.margin+(innerHeight-codeH)/2, codeW, codeH); err != nil {
+		return nil, err
+	}
+
+	textX := params.margin
+	if codeW > 0 {
+		textX += codeW + colGap
+	}
+	textWidth := params.width - params.margin - textX
+	if textWidth < 1 {
+		logDebug("skipping text (no space beside the code)")
+		return layout, nil
+	}
+	if iconSize := stripIconSize(params, textWidth, innerHeight); iconSize > 0 {
+		addIcon(layout, params, params.width-params.margin-iconSize, params.margin, iconSize, innerHeight, 0)
+		textWidth -= iconSize + colGap
+	}
+
+	idHeight := t.idHeight()
+	bottom := params.height - params.margin
+	y := params.margin
+	titleLimit := innerHeight
+	if idHeight > 0 && idHeight+gap < innerHeight {
+		titleLimit = innerHeight - idHeight - gap
+	}
+	used, err := t.addTitle(layout, textX, y, textWidth, titleLimit, alignLeft)
+	if err != nil {
+		return nil, err
+	}
+	y += used
+	if idHeight > 0 && y+gap+idHeight <= bottom {
+		t.addID(layout, textX, bottom-idHeight, textWidth, alignRight)
+		bottom -= idHeight + gap
+	} else if idHeight > 0 {
+		logDebug("skipping ID block (no available space)")
+	}
+	for _, add := range []func(y, maxHeight int) int{
+		func(y, maxHeight int) int { return t.addSecondary(layout, textX, y, textWidth, maxHeight, alignLeft) },
+		func(y, maxHeight int) int { return t.addFields(layout, textX, y, textWidth, maxHeight, alignLeft) },
+	} {
+		top := y
+		if y > params.margin {
+			top += gap
+		}
+		if bottom-top < 1 {
+			break
+		}
+		if used := add(top, bottom-top); used > 0 {
+			y = top + used
+		}
+	}
+	return layout, nil
+}
+
+// stripIconSize is the width of the icon column on the right of the strip
+// layout: a quarter of the text width, at most maxSize (no limit if
+// maxSize <= 0), or 0 without an icon or below the 12 pixel minimum.
+func stripIconSize(params labelParams, textWidth, maxSize int) int {
+	if params.icon == nil {
+		logDebug("skipping icon (Icon=none)")
+		return 0
+	}
+	size := textWidth / 4
+	if maxSize > 0 {
+		size = minInt(size, maxSize)
+	}
+	if size < 12 {
+		logDebug("skipping icon (size %d < minimum 12)", size)
+		return 0
+	}
+	return size
+}
+
+// layoutStripDynamic is the strip layout for DynamicLength. Nothing beside
+// the code is cut off, and the label is as tall as the code or the text,
+// whichever is taller.
+func layoutStripDynamic(layout *labelLayout, params labelParams, t *layoutText, innerWidth int) (*labelLayout, error) {
+	gap := maxInt(4, params.padding/2)
+	colGap := maxInt(params.padding, 4)
+
+	// Without a height to fill, the code is limited by half the width and
+	// QrSize.
+	codeW, codeH := codeSize(params, innerWidth/2, innerWidth/2)
+	textX := params.margin
+	if codeW > 0 {
+		textX += codeW + colGap
+	}
+	textWidth := params.width - params.margin - textX
+	iconSize := stripIconSize(params, textWidth, codeH)
+	if iconSize > 0 {
+		textWidth -= iconSize + colGap
+	}
+
+	y := params.margin
+	idHeight := 0
+	if textWidth < 1 {
+		logDebug("skipping text (no space beside the code)")
+	} else {
+		used, err := t.addTitle(layout, textX, y, textWidth, 0, alignLeft)
+		if err != nil {
+			return nil, err
+		}
+		y += used
+		for _, add := range []func(y int) int{
+			func(y int) int { return t.addSecondary(layout, textX, y, textWidth, 0, alignLeft) },
+			func(y int) int { return t.addFields(layout, textX, y, textWidth, 0, alignLeft) },
+		} {
+			top := y
+			if y > params.margin {
+				top += gap
+			}
+			if used := add(top); used > 0 {
+				y = top + used
+			}
+		}
+		if idHeight = t.idHeight(); idHeight > 0 && y > params.margin {
+			y += gap
+		}
+	}
+
+	contentHeight := maxInt(maxInt(codeH, iconSize), y+idHeight-params.margin)
+	layout.height = contentHeight + 2*params.margin
+	logDebug("dynamic length: label height %d (requested %d)", layout.height, params.height)
+	if err := addCode(layout, params, params.margin, params.margin+(contentHeight-codeH)/2, codeW, codeH); err != nil {
+		return nil, err
+	}
+	if iconSize > 0 {
+		addIcon(layout, params, params.width-params.margin-iconSize, params.margin, iconSize, contentHeight, 0)
+	}
+	if idHeight > 0 {
+		t.addID(layout, textX, layout.height-params.margin-idHeight, textWidth, alignRight)
+	}
+	return layout, nil
+}
+
+// layoutSquare puts the title and secondary text on top and centres the
+// code under them. The ID block sits in the bottom-right corner beside the
+// code if there is room, or under it otherwise, and the icon in the space
+// left of the code. With DynamicLength no text is cut off and the label ends
+// below the code.
+func layoutSquare(params labelParams) (*labelLayout, error) {
+	layout := newLabelLayout(params)
+	innerWidth, innerHeight, err := innerSize(params)
+	if err != nil {
+		return nil, err
+	}
+	t, err := newLayoutText(params)
+	if err != nil {
+		return nil, err
+	}
+	x := params.margin
+	gap := maxInt(4, params.padding/2)
+
+	titleLimit, textLimit := innerHeight/3, innerHeight/4
+	if params.dynamicLength {
+		titleLimit, textLimit = 0, 0
+	}
+
+	y := params.margin
+	used, err := t.addTitle(layout, x, y, innerWidth, titleLimit, alignLeft)
+	if err != nil {
+		return nil, err
+	}
+	y += used
+	for _, add := range []func(y int) int{
+		func(y int) int { return t.addSecondary(layout, x, y, innerWidth, textLimit, alignLeft) },
+		func(y int) int { return t.addFields(layout, x, y, innerWidth, textLimit, alignLeft) },
+	} {
+		top := y
+		if y > params.margin {
+			top += gap
+		}
+		if used := add(top); used > 0 {
+			y = top + used
+		}
+	}
+	if y > params.margin {
+		y += params.padding
+	}
+
+	bottom := params.height - params.margin
+	idHeight := t.idHeight()
+	idAlign := alignRight
+	var codeW, codeH int
+	if params.dynamicLength {
+		codeW, codeH = codeSize(params, innerWidth, innerWidth)
+		bottom = y + maxInt(codeH, idHeight)
+		if idHeight > 0 && (innerWidth-codeW)/2 < t.idWidth()+gap {
+			bottom = y + codeH
+			layout.height = bottom + params.padding + idHeight + params.margin
+			idAlign = alignCenter
+		} else {
+			layout.height = bottom + params.margin
+		}
+		logDebug("dynamic length: label height %d (requested %d)", layout.height, params.height)
+	} else {
+		codeW, codeH = codeSize(params, innerWidth, bottom-y)
+		if idHeight > 0 && (innerWidth-codeW)/2 < t.idWidth()+gap {
+			// The ID does not fit beside the code, so it goes below it.
+			codeW, codeH = codeSize(params, innerWidth, bottom-y-idHeight-params.padding)
+			bottom -= idHeight + params.padding
+			idAlign = alignCenter
+		}
+	}
+	codeY := y + maxInt(0, (bottom-y-codeH)/2)
+	if err := addCode(layout, params, x+(innerWidth-codeW)/2, codeY, codeW, codeH); err != nil {
+		return nil, err
+	}
+	if params.icon != nil {
+		// The icon mirrors the ID block, left of the code and level with its
+		// bottom edge.
+		size := minInt((innerWidth-codeW)/2-gap, codeH)
+		addIcon(layout, params, x, codeY+codeH-size, size, size, 0)
+	}
+	if idY := layout.height - params.margin - idHeight; idHeight > 0 && idY < y {
+		logDebug("skipping ID block (no available space)")
+	} else {
+		t.addID(layout, x, idY, innerWidth, idAlign)
+	}
+	return layout, nil
+}
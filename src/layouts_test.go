@@ -0,0 +1,165 @@
+package main
+
+import (
+	"image"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDynamicLengthLayouts(t *testing.T) {
+	const long = "Cordless drill with two batteries, a charger and a case full of bits"
+	for _, mode := range []string{layoutModeVertical, layoutModeStrip, layoutModeSquare} {
+		t.Run(mode, func(t *testing.T) {
+			heights := map[string]int{}
+			for _, title := range []string{"Saw", long} {
+				values := url.Values{
+					"Layout":        {mode},
+					"DynamicLength": {"true"},
+					"Width":         {"400"},
+					"TitleText":     {title},
+					"URL":           {"https://x.example/item/1"},
+					"ID":            {"000-101"},
+				}
+				params, err := parseLabelParams(values)
+				if err != nil {
+					t.Fatal(err)
+				}
+				layout, err := layoutLabel(params)
+				if err != nil {
+					t.Fatal(err)
+				}
+				heights[title] = layout.height
+
+				var text []string
+				icons := 0
+				for _, el := range layout.elements {
+					if el.kind == elementIcon {
+						icons++
+					}
+					if el.y < 0 || el.y+el.height > layout.height || el.x+el.width > layout.width {
+						t.Errorf("%q: element %d at (%d,%d) %dx%d outside the %dx%d label",
+							title, el.kind, el.x, el.y, el.width, el.height, layout.width, layout.height)
+					}
+					text = append(text, el.lines...)
+				}
+				if got := strings.Join(text, " "); !strings.Contains(got, strings.Fields(title)[len(strings.Fields(title))-1]) || strings.Contains(got, "...") {
+					t.Errorf("%q: title cut off: %q", title, got)
+				}
+				if !containsLines(text, "ID", "000-101") {
+					t.Errorf("%q: ID block missing: %q", title, text)
+				}
+				if icons != 1 {
+					t.Errorf("%q: %d icons, want 1", title, icons)
+				}
+			}
+			if heights[long] <= heights["Saw"] {
+				t.Errorf("label does not grow with the title: %v", heights)
+			}
+		})
+	}
+}
+
+// containsLines reports whether want appears as consecutive text lines.
+func containsLines(lines []string, want ...string) bool {
+	for i := 0; i+len(want) <= len(lines); i++ {
+		if reflect.DeepEqual(lines[i:i+len(want)], want) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPickLayout(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		// Without Layout every shape keeps the landscape layout.
+		{"Width=300&Height=300", layoutModeLandscape},
+		{"Width=96&Height=96", layoutModeLandscape},
+		{"Width=800&Height=100", layoutModeLandscape},
+		{"Width=240&Height=400", layoutModeLandscape},
+		{"Width=300&Height=300&Layout=auto", layoutModeSquare},
+		{"Width=800&Height=100&Layout=auto", layoutModeStrip},
+		{"Width=240&Height=400&Layout=auto", layoutModeVertical},
+		{"Width=320&Height=240&Layout=auto", layoutModeLandscape},
+		{"Width=320&Height=240&Rotate=90&Layout=auto", layoutModeLandscape},
+		{"Width=800&Height=100&Layout=auto&DynamicLength=true", layoutModeLandscape},
+		{"Width=320&Height=240&Layout=strip", layoutModeStrip},
+	}
+	for _, tt := range tests {
+		values, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		params, err := parseLabelParams(values)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		if got := pickLayout(params); got != tt.want {
+			t.Errorf("%s: layout %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestLayoutVariantsIcon(t *testing.T) {
+	tests := []struct {
+		mode   string
+		width  int
+		height int
+	}{
+		{layoutModeVertical, 240, 400},
+		{layoutModeStrip, 800, 100},
+		{layoutModeSquare, 300, 300},
+	}
+	for _, tt := range tests {
+		for _, icon := range []string{"tool", "none"} {
+			t.Run(tt.mode+"/"+icon, func(t *testing.T) {
+				params, err := parseLabelParams(url.Values{
+					"Layout":    {tt.mode},
+					"Width":     {strconv.Itoa(tt.width)},
+					"Height":    {strconv.Itoa(tt.height)},
+					"Icon":      {icon},
+					"TitleText": {"Drill"},
+					"URL":       {"https://x.example/item/1"},
+					"ID":        {"000-101"},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				layout, err := layoutLabel(params)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var placed *labelElement
+				var occupied []image.Rectangle
+				for i, el := range layout.elements {
+					if el.kind == elementIcon {
+						placed = &layout.elements[i]
+						continue
+					}
+					occupied = append(occupied, elementBounds(layout, el)...)
+				}
+				if icon == "none" {
+					if placed != nil {
+						t.Error("icon placed with Icon=none")
+					}
+					return
+				}
+				if placed == nil {
+					t.Fatal("icon was left out")
+				}
+				if placed.icon != params.icon {
+					t.Errorf("icon %q, want %q", placed.icon.name, params.icon.name)
+				}
+				r := image.Rect(placed.x, placed.y, placed.x+placed.width, placed.y+placed.height)
+				if !r.In(image.Rect(0, 0, layout.width, layout.height)) || overlapsAny(r, occupied) {
+					t.Errorf("icon %v overlaps the layout or leaves the %dx%d label", r, layout.width, layout.height)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	logoDefaultName = "default"
+	logoNone        = "none"
+
+	logoAtIcon        = "icon"
+	logoAtTopLeft     = "top-left"
+	logoAtTopRight    = "top-right"
+	logoAtBottomLeft  = "bottom-left"
+	logoAtBottomRight = "bottom-right"
+)
+
+var logos = map[string]*labelIcon{}
+
+// loadLogos registers the LOGO_FILE image as the "default" logo and every
+// PNG in dir under its file name.
+func loadLogos(file, dir string) error {
+	if file != "" {
+		if err := loadLogo(logoDefaultName, file); err != nil {
+			return err
+		}
+	}
+	if dir == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(entry.Name()), ".png") {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		if err := loadLogo(name, filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadLogo(name, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	key := strings.ToLower(name)
+	if _, exists := logos[key]; exists || key == logoNone {
+		return fmt.Errorf("%s: duplicate logo name %q", path, name)
+	}
+	logos[key] = &labelIcon{name: name, image: img}
+	logInfo("loaded logo %q from %s", name, path)
+	return nil
+}
+
+// lookupLogo finds a logo by case-insensitive name. An empty name selects the
+// LOGO_FILE logo, if any; "none" and a missing default are nil.
+func lookupLogo(name string) (*labelIcon, bool) {
+	key := strings.ToLower(strings.TrimSpace(name))
+	switch key {
+	case "":
+		return logos[logoDefaultName], true
+	case logoNone:
+		return nil, true
+	}
+	logo, ok := logos[key]
+	return logo, ok
+}
+
+func errUnknownLogo(name string) error {
+	names := make([]string, 0, len(logos)+1)
+	for _, logo := range logos {
+		names = append(names, logo.name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("unknown logo %q (available: %s)", name, strings.Join(append(names, logoNone), ", "))
+}
+
+func parseLogoPosition(value string) (string, bool) {
+	switch position := strings.ToLower(strings.TrimSpace(value)); position {
+	case "":
+		return logoAtIcon, true
+	case logoAtIcon, logoAtTopLeft, logoAtTopRight, logoAtBottomLeft, logoAtBottomRight:
+		return position, true
+	}
+	return "", false
+}
+
+// addLogo overlays the logo in a corner of the label, inside the margin. It
+// is scaled to fit a LogoSize square, keeping its aspect ratio, and shrunk to
+// at most half that size to stay clear of the layout; a logo that still
+// overlaps is left out. Logos in the icon position are placed by the layout
+// instead.
+func addLogo(layout *labelLayout, params labelParams) {
+	if params.logo == nil || params.logoPosition == logoAtIcon {
+		return
+	}
+	size := minInt(params.logoSize, minInt(layout.width, layout.height)-2*params.margin)
+	if size < 1 {
+		logDebug("skipping logo (no available space)")
+		return
+	}
+	var occupied []image.Rectangle
+	for _, el := range layout.elements {
+		occupied = append(occupied, elementBounds(layout, el)...)
+	}
+	bounds := params.logo.image.Bounds()
+	for minSize := (size + 1) / 2; size >= minSize; size-- {
+		scale := math.Min(float64(size)/float64(bounds.Dx()), float64(size)/float64(bounds.Dy()))
+		w := maxInt(1, int(math.Round(float64(bounds.Dx())*scale)))
+		h := maxInt(1, int(math.Round(float64(bounds.Dy())*scale)))
+		x, y := params.margin, params.margin
+		if strings.HasSuffix(params.logoPosition, "right") {
+			x = layout.width - params.margin - w
+		}
+		if strings.HasPrefix(params.logoPosition, "bottom") {
+			y = layout.height - params.margin - h
+		}
+		if overlapsAny(image.Rect(x, y, x+w, y+h), occupied) {
+			continue
+		}
+		logDebug("rendering logo %q: %dx%d at (%d,%d)", params.logo.name, w, h, x, y)
+		layout.add(labelElement{kind: elementIcon, x: x, y: y, width: w, height: h, icon: params.logo})
+		return
+	}
+	logDebug("skipping logo %q (the %s corner is taken by the layout)", params.logo.name, params.logoPosition)
+}
+
+// elementBounds returns the areas an element draws on. Text takes the width
+// of each line rather than its whole box.
+func elementBounds(layout *labelLayout, el labelElement) []image.Rectangle {
+	switch el.kind {
+	case elementText:
+		metrics := el.face.Metrics()
+		var out []image.Rectangle
+		for _, line := range placeTextLines(el, layout.height) {
+			last := len(line.runes) - 1
+			advance, _ := el.face.GlyphAdvance(line.runes[last])
+			out = append(out, image.Rect(line.x[0].Floor(), line.baseline-metrics.Ascent.Ceil(),
+				(line.x[last]+advance).Ceil(), line.baseline+metrics.Descent.Ceil()))
+		}
+		return out
+	case elementLine:
+		t := maxInt(1, el.thickness)
+		return []image.Rectangle{image.Rect(minInt(el.x, el.x2)-t/2, minInt(el.y, el.y2)-t/2,
+			maxInt(el.x, el.x2)+t-t/2, maxInt(el.y, el.y2)+t-t/2)}
+	}
+	return []image.Rectangle{image.Rect(el.x, el.y, el.x+el.width, el.y+el.height)}
+}
+
+func overlapsAny(r image.Rectangle, others []image.Rectangle) bool {
+	for _, other := range others {
+		if r.Overlaps(other) {
+			return true
+		}
+	}
+	return false
+}
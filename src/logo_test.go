@@ -0,0 +1,72 @@
+package main
+
+import (
+	"image"
+	"net/url"
+	"testing"
+)
+
+func TestAddLogoAvoidsLayout(t *testing.T) {
+	logo := &labelIcon{name: "test", image: image.NewRGBA(image.Rect(0, 0, 64, 32))}
+	tests := []struct {
+		query   string
+		skipped bool
+		shrunk  bool
+	}{
+		// The title starts in the top-left corner and the QR code and ID fill
+		// the bottom corners of the default layout.
+		{"LogoPosition=top-left", true, false},
+		{"LogoPosition=top-right", false, false},
+		{"LogoPosition=bottom-left", true, false},
+		{"LogoPosition=bottom-right", true, false},
+		{"LogoPosition=top-right&LogoSize=100", false, true},
+		{"LogoPosition=bottom-left&Layout=vertical", false, false},
+		{"LogoPosition=top-right&Layout=strip", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			values, err := url.ParseQuery("TitleText=Drill&DescriptionText=Garage&URL=https://x.example/item/1&" + tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			params, err := parseLabelParams(values)
+			if err != nil {
+				t.Fatalf("parseLabelParams: %v", err)
+			}
+			params.logo = logo
+			layout, err := layoutLabel(params)
+			if err != nil {
+				t.Fatalf("layoutLabel: %v", err)
+			}
+
+			var placed *labelElement
+			var occupied []image.Rectangle
+			for i, el := range layout.elements {
+				if el.icon == logo {
+					placed = &layout.elements[i]
+					continue
+				}
+				occupied = append(occupied, elementBounds(layout, el)...)
+			}
+			if tt.skipped {
+				if placed != nil {
+					t.Errorf("logo placed at (%d,%d), want it left out", placed.x, placed.y)
+				}
+				return
+			}
+			if placed == nil {
+				t.Fatal("logo was left out")
+			}
+			r := image.Rect(placed.x, placed.y, placed.x+placed.width, placed.y+placed.height)
+			if overlapsAny(r, occupied) {
+				t.Errorf("logo %v overlaps the layout", r)
+			}
+			if placed.width != 2*placed.height {
+				t.Errorf("logo is %dx%d, want a 2:1 aspect ratio", placed.width, placed.height)
+			}
+			if shrunk := placed.width < params.logoSize; shrunk != tt.shrunk {
+				t.Errorf("logo width %d with LogoSize %d, want shrunk %v", placed.width, params.logoSize, tt.shrunk)
+			}
+		})
+	}
+}
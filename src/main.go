@@ -12,15 +12,45 @@ func main() {
 	port := envString("PORT", "8080")
 	timeout := envDuration("HBOX_LABEL_MAKER_LABEL_SERVICE_TIMEOUT", 30*time.Second)
 	maxUpload := envInt("HBOX_WEB_MAX_UPLOAD_SIZE", defaultMaxUpload)
+	templateDir := envString("TEMPLATE_DIR", "")
+	fontDir := envString("FONT_DIR", "")
+	fontFallback := envString("FONT_FALLBACK", "")
+	iconDir := envString("ICON_DIR", "")
+	logoFile := envString("LOGO_FILE", "")
+	logoDir := envString("LOGO_DIR", "")
 
 	logInfo("HomeBox Label Service starting")
 	logDebug("  port: %s", port)
 	logDebug("  timeout: %v", timeout)
 	logDebug("  max upload size: %d bytes", maxUpload)
+	logDebug("  template dir: %q", templateDir)
+	logDebug("  font dir: %q", fontDir)
+	logDebug("  icon dir: %q", iconDir)
+	logDebug("  logo file: %q", logoFile)
+	logDebug("  logo dir: %q", logoDir)
+
+	// Templates may refer to fonts and icons, so load those first.
+	if err := loadFonts(fontDir); err != nil {
+		log.Fatalf("font loading failed: %v", err)
+	}
+	if err := setFontFallbacks(fontFallback); err != nil {
+		log.Fatalf("font fallback setup failed: %v", err)
+	}
+	if err := loadIcons(iconDir); err != nil {
+		log.Fatalf("icon loading failed: %v", err)
+	}
+	if err := loadLogos(logoFile, logoDir); err != nil {
+		log.Fatalf("logo loading failed: %v", err)
+	}
+	if err := loadTemplates(templateDir); err != nil {
+		log.Fatalf("template loading failed: %v", err)
+	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health", healthHandler)
 	mux.HandleFunc("/healthz", healthHandler)
+	mux.HandleFunc("/batch", batchHandler)
+	mux.HandleFunc("/media", mediaHandler)
 	mux.HandleFunc("/", labelHandler)
 
 	server := &http.Server{
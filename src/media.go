@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// labelMedia is a named label stock. Width runs across the print head and
+// height along the feed; a height of 0 is continuous media. The printable
+// area and margin are in dots at dpi.
+type labelMedia struct {
+	id          string
+	aliases     []string
+	description string
+	widthMM     float64
+	heightMM    float64
+	dpi         float64
+	printWidth  int
+	printHeight int
+	margin      int
+	// brother is the raster media of Brother stock, used by Format=brother.
+	brother *brotherMedia
+}
+
+// mediaTable lists the Brother stock of brotherMediaTable followed by
+// LabelWriter and Zebra labels. Dymo printable areas leave about 1mm at the
+// sides and 2mm at each end unprinted.
+var mediaTable = append(brotherMediaPresets(),
+	labelMedia{id: "DYMO-99010", aliases: []string{"99010", "30252"}, description: "Dymo LabelWriter address 28x89mm", widthMM: 28, heightMM: 89, dpi: 300, printWidth: 307, printHeight: 1004, margin: 12},
+	labelMedia{id: "DYMO-99012", aliases: []string{"99012"}, description: "Dymo LabelWriter large address 36x89mm", widthMM: 36, heightMM: 89, dpi: 300, printWidth: 401, printHeight: 1004, margin: 12},
+	labelMedia{id: "DYMO-99014", aliases: []string{"99014"}, description: "Dymo LabelWriter shipping 54x101mm", widthMM: 54, heightMM: 101, dpi: 300, printWidth: 614, printHeight: 1146, margin: 16},
+	labelMedia{id: "DYMO-11352", aliases: []string{"11352"}, description: "Dymo LabelWriter return address 25x54mm", widthMM: 25, heightMM: 54, dpi: 300, printWidth: 272, printHeight: 591, margin: 10},
+	labelMedia{id: "DYMO-11354", aliases: []string{"11354"}, description: "Dymo LabelWriter multipurpose 32x57mm", widthMM: 32, heightMM: 57, dpi: 300, printWidth: 354, printHeight: 626, margin: 12},
+	labelMedia{id: "DYMO-11355", aliases: []string{"11355"}, description: "Dymo LabelWriter multipurpose 19x51mm", widthMM: 19, heightMM: 51, dpi: 300, printWidth: 200, printHeight: 555, margin: 8},
+	labelMedia{id: "ZEBRA-4X6", aliases: []string{"4X6"}, description: "Zebra 4x6in shipping label at 203 dpi", widthMM: 101.6, heightMM: 152.4, dpi: 203, printWidth: 812, printHeight: 1218, margin: 24},
+	labelMedia{id: "ZEBRA-4X3", aliases: []string{"4X3"}, description: "Zebra 4x3in label at 203 dpi", widthMM: 101.6, heightMM: 76.2, dpi: 203, printWidth: 812, printHeight: 609, margin: 20},
+	labelMedia{id: "ZEBRA-4X2", aliases: []string{"4X2"}, description: "Zebra 4x2in label at 203 dpi", widthMM: 101.6, heightMM: 50.8, dpi: 203, printWidth: 812, printHeight: 406, margin: 16},
+	labelMedia{id: "ZEBRA-3X2", aliases: []string{"3X2"}, description: "Zebra 3x2in label at 203 dpi", widthMM: 76.2, heightMM: 50.8, dpi: 203, printWidth: 609, printHeight: 406, margin: 16},
+	labelMedia{id: "ZEBRA-2.25X1.25", aliases: []string{"2.25X1.25"}, description: "Zebra 2.25x1.25in label at 203 dpi", widthMM: 57.15, heightMM: 31.75, dpi: 203, printWidth: 457, printHeight: 254, margin: 12},
+	labelMedia{id: "ZEBRA-2X1", aliases: []string{"2X1"}, description: "Zebra 2x1in label at 203 dpi", widthMM: 50.8, heightMM: 25.4, dpi: 203, printWidth: 406, printHeight: 203, margin: 10},
+)
+
+// brotherMediaPresets describes the Brother stock at the head resolution,
+// with the printable dots the raster output accepts.
+func brotherMediaPresets() []labelMedia {
+	presets := make([]labelMedia, 0, len(brotherMediaTable))
+	for i := range brotherMediaTable {
+		media := &brotherMediaTable[i]
+		kind := "QL continuous"
+		size := fmt.Sprintf("%dmm", media.widthMM)
+		if media.family == brotherPT {
+			kind = "P-touch TZe"
+		} else if !media.continuous() {
+			kind = "QL die-cut"
+			size = fmt.Sprintf("%dx%dmm", media.widthMM, media.lengthMM)
+		}
+		dpi := media.nativeDPI()
+		presets = append(presets, labelMedia{
+			id:          media.id,
+			aliases:     media.aliases,
+			description: "Brother " + kind + " " + size,
+			widthMM:     float64(media.widthMM),
+			heightMM:    float64(media.lengthMM),
+			dpi:         dpi,
+			printWidth:  media.printable,
+			printHeight: media.lengthDots,
+			margin:      minInt(roundDots(1.5*dotsPerMM(dpi)), media.printable/8),
+			brother:     media,
+		})
+	}
+	return presets
+}
+
+func lookupMedia(name string) (*labelMedia, bool) {
+	name = strings.TrimSpace(name)
+	for i := range mediaTable {
+		media := &mediaTable[i]
+		if strings.EqualFold(media.id, name) {
+			return media, true
+		}
+		for _, alias := range media.aliases {
+			if strings.EqualFold(alias, name) {
+				return media, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func errUnknownMedia(name string) error {
+	ids := make([]string, 0, len(mediaTable))
+	for _, media := range mediaTable {
+		ids = append(ids, media.id)
+	}
+	sort.Strings(ids)
+	return fmt.Errorf("unknown media %q (available: %s)", name, strings.Join(ids, ", "))
+}
+
+func (m *labelMedia) continuous() bool {
+	return m.heightMM == 0
+}
+
+// mediaScale is the factor for the default QR code and font sizes on a
+// width x height label from a preset. The defaults suit the 320x240 default
+// label; they grow on larger stock but never shrink, so text on small stock
+// stays readable. Labels that grow with their content scale with the width.
+func mediaScale(width, height int, dynamic bool) float64 {
+	scale := float64(width) / defaultWidth
+	if !dynamic {
+		long, short := maxInt(width, height), minInt(width, height)
+		scale = math.Min(float64(long)/defaultWidth, float64(short)/defaultHeight)
+	}
+	return math.Max(1, scale)
+}
+
+// mediaInfo is the JSON form of a preset for GET /media.
+type mediaInfo struct {
+	ID              string   `json:"id"`
+	Aliases         []string `json:"aliases"`
+	Description     string   `json:"description"`
+	WidthMM         float64  `json:"widthMM"`
+	HeightMM        float64  `json:"heightMM"`
+	Continuous      bool     `json:"continuous"`
+	Dpi             float64  `json:"dpi"`
+	Width           int      `json:"width"`
+	Height          int      `json:"height"`
+	PrintableWidth  int      `json:"printableWidth"`
+	PrintableHeight int      `json:"printableHeight"`
+	Margin          int      `json:"margin"`
+}
+
+func mediaHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		logError("media list method not allowed: %s", r.Method)
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	list := make([]mediaInfo, 0, len(mediaTable))
+	for _, media := range mediaTable {
+		aliases := media.aliases
+		if aliases == nil {
+			aliases = []string{}
+		}
+		list = append(list, mediaInfo{
+			ID:              media.id,
+			Aliases:         aliases,
+			Description:     media.description,
+			WidthMM:         media.widthMM,
+			HeightMM:        media.heightMM,
+			Continuous:      media.continuous(),
+			Dpi:             media.dpi,
+			Width:           roundDots(media.widthMM / 25.4 * media.dpi),
+			Height:          roundDots(media.heightMM / 25.4 * media.dpi),
+			PrintableWidth:  media.printWidth,
+			PrintableHeight: media.printHeight,
+			Margin:          media.margin,
+		})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
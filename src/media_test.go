@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestMediaDefaultSizes(t *testing.T) {
+	tests := []struct {
+		query     string
+		qrSize    int
+		titleSize float64
+		descSize  float64
+	}{
+		{"", defaultQRSize, defaultTitleFontSize, defaultDescFontSize},
+		// 812x1218: the long side is 3.81 times 320, the short side 3.38
+		// times 240.
+		{"Media=ZEBRA-4X6", 575, 95, 54},
+		{"Media=4x6&Orientation=landscape", 575, 95, 54},
+		{"Media=ZEBRA-4X6&QrSize=200&TitleFontSize=40", 200, 40, 54},
+		{"Media=ZEBRA-4X6&Width=320&Height=240", defaultQRSize, defaultTitleFontSize, defaultDescFontSize},
+		// Continuous 62mm stock, 696 dots wide.
+		{"Media=DK-22205", 370, 61, 35},
+		// Small stock keeps the defaults.
+		{"Media=DYMO-11355", defaultQRSize, defaultTitleFontSize, defaultDescFontSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			params, err := parseLabelParams(values)
+			if err != nil {
+				t.Fatalf("parseLabelParams: %v", err)
+			}
+			if params.qrSize != tt.qrSize || params.titleFontSize != tt.titleSize || params.descriptionFontSize != tt.descSize {
+				t.Errorf("QrSize %d, TitleFontSize %g, DescriptionFontSize %g; want %d, %g, %g",
+					params.qrSize, params.titleFontSize, params.descriptionFontSize, tt.qrSize, tt.titleSize, tt.descSize)
+			}
+		})
+	}
+}
+
+func TestMediaBrotherFormat(t *testing.T) {
+	tests := []struct {
+		query string
+		media string
+		dpi   float64
+		width int
+		err   string
+	}{
+		{"Format=brother", defaultBrotherMedia, 300, 696, ""},
+		{"format=Brother", defaultBrotherMedia, 300, 696, ""},
+		{"Format=brother&Dpi=203&Width=400", defaultBrotherMedia, 203, 400, ""},
+		{"Format=brother&Media=DK-11209", "DK-11209", 300, 696, ""},
+		{"Format=brother&Media=62x29", "DK-11209", 300, 696, ""},
+		{"Format=brother&Media=tze-12mm", "TZE-12MM", 180, 70, ""},
+		{"Format=brother&Media=ZEBRA-4X6", "", 0, 0, "media ZEBRA-4X6 is not Brother stock and cannot be used with Format=brother"},
+		{"Format=png&Media=ZEBRA-4X6", "", 203, 812, ""},
+		{"Format=png", "", defaultDPI, defaultWidth, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			params, err := parseLabelParams(values)
+			if err != nil {
+				t.Fatalf("parseLabelParams: %v", err)
+			}
+			opts, err := parseOutputOptions(values, "", params.media)
+			if tt.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+					t.Fatalf("error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseOutputOptions: %v", err)
+			}
+			if params.dpi != tt.dpi || params.width != tt.width {
+				t.Errorf("label %dpx wide at %g dpi, want %dpx at %g dpi", params.width, params.dpi, tt.width, tt.dpi)
+			}
+			if got := ""; opts.brotherMedia != nil {
+				got = opts.brotherMedia.id
+				if got != tt.media {
+					t.Errorf("Brother media %s, want %s", got, tt.media)
+				}
+			} else if tt.media != "" {
+				t.Errorf("no Brother media, want %s", tt.media)
+			}
+		})
+	}
+}
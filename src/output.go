@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	formatPNG     = "png"
+	formatPDF     = "pdf"
+	formatSVG     = "svg"
+	formatZPL     = "zpl"
+	formatBrother = "brother"
+	formatESCPOS  = "escpos"
+)
+
+var formatContentTypes = map[string]string{
+	formatPNG:     "image/png",
+	formatPDF:     "application/pdf",
+	formatSVG:     "image/svg+xml",
+	formatZPL:     "application/zpl",
+	formatBrother: "application/octet-stream",
+	formatESCPOS:  "application/octet-stream",
+}
+
+var acceptFormats = map[string]string{
+	"image/png":       formatPNG,
+	"application/pdf": formatPDF,
+	"image/svg+xml":   formatSVG,
+	"application/zpl": formatZPL,
+	"image/*":         formatPNG,
+	"*/*":             formatPNG,
+}
+
+// outputOptions holds the per-request settings of the output encoders.
+type outputOptions struct {
+	format       string
+	brotherMedia *brotherMedia
+	compress     bool
+	dither       string
+	paperWidth   int
+	mono         bool
+	sheet        *labelSheet
+	skip         int
+	copies       int
+}
+
+// parseOutputOptions reads the output settings. media is the preset already
+// resolved by parseLabelParams, if any.
+func parseOutputOptions(values url.Values, accept string, media *labelMedia) (outputOptions, error) {
+	format, err := negotiateFormat(values, accept)
+	if err != nil {
+		return outputOptions{}, err
+	}
+	opts := outputOptions{format: format}
+	if err := parseColorOptions(values, &opts); err != nil {
+		return opts, err
+	}
+	if err := parseSheetOptions(values, &opts); err != nil {
+		return opts, err
+	}
+
+	if format == formatBrother {
+		// parseLabelParams already resolves Format=brother without Media to
+		// the default stock.
+		switch {
+		case media == nil:
+			opts.brotherMedia, _ = lookupBrotherMedia(defaultBrotherMedia)
+		case media.brother == nil:
+			return opts, fmt.Errorf("media %s is not Brother stock and cannot be used with Format=brother (Brother media: %s)", media.id, strings.Join(brotherMediaIDs(), ", "))
+		default:
+			opts.brotherMedia = media.brother
+		}
+
+		switch compression := strings.ToLower(strings.TrimSpace(queryGet(values, "Compression"))); compression {
+		case "", "none":
+		case "tiff", "packbits":
+			opts.compress = true
+		default:
+			return opts, fmt.Errorf("unsupported compression %q", compression)
+		}
+	}
+
+	if format == formatESCPOS {
+		opts.paperWidth = parseInt(values, "PaperWidth", defaultEscposPaper)
+		if opts.paperWidth <= 10 {
+			return opts, fmt.Errorf("invalid paper width %d", opts.paperWidth)
+		}
+	}
+	return opts, nil
+}
+
+// parseColorOptions reads the ColorMode and Dither settings shared by all
+// raster outputs.
+func parseColorOptions(values url.Values, opts *outputOptions) error {
+	dither, ok := parseDither(queryGet(values, "Dither"))
+	if !ok {
+		return fmt.Errorf("unsupported dither %q", queryGet(values, "Dither"))
+	}
+	opts.dither = dither
+
+	switch colorMode := strings.ToLower(strings.TrimSpace(queryGet(values, "ColorMode"))); colorMode {
+	case "", "color":
+	case "mono":
+		opts.mono = true
+	default:
+		return fmt.Errorf("unsupported color mode %q", colorMode)
+	}
+	return nil
+}
+
+// parseSheetOptions reads Sheet, Skip and Copies. Sheets are always
+// written as PDF.
+func parseSheetOptions(values url.Values, opts *outputOptions) error {
+	name := queryGet(values, "Sheet")
+	if name == "" {
+		return nil
+	}
+	sheet, ok := lookupSheet(name)
+	if !ok {
+		return fmt.Errorf("unknown sheet %q (available: %s)", name, strings.Join(sheetIDs(), ", "))
+	}
+	if explicit := strings.ToLower(strings.TrimSpace(queryGet(values, "Format"))); explicit != "" && explicit != formatPDF {
+		return fmt.Errorf("sheets are only available as pdf, not %q", explicit)
+	}
+	opts.format = formatPDF
+	opts.sheet = sheet
+
+	opts.skip = parseInt(values, "Skip", 0)
+	if opts.skip < 0 || opts.skip >= sheet.capacity() {
+		return fmt.Errorf("skip %d out of range for %s (0-%d)", opts.skip, sheet.id, sheet.capacity()-1)
+	}
+	opts.copies = parseInt(values, "Copies", 1)
+	if opts.copies < 1 || opts.copies > maxSheetCopies {
+		return fmt.Errorf("copies must be between 1 and %d", maxSheetCopies)
+	}
+	return nil
+}
+
+// negotiateFormat picks the output format from the Format parameter, then
+// from the Accept header, and falls back to PNG.
+func negotiateFormat(values url.Values, accept string) (string, error) {
+	if explicit := strings.ToLower(strings.TrimSpace(queryGet(values, "Format"))); explicit != "" {
+		if _, ok := formatContentTypes[explicit]; !ok {
+			return "", fmt.Errorf("unsupported format %q", explicit)
+		}
+		return explicit, nil
+	}
+
+	best := formatPNG
+	bestQ := -1.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, q := parseAcceptPart(part)
+		format, ok := acceptFormats[mediaType]
+		if !ok || q <= 0 {
+			continue
+		}
+		// PNG wins ties, so browsers listing image/svg+xml next to image/*
+		// for <img> keep getting PNG; other formats need a higher quality.
+		if q > bestQ || q == bestQ && format == formatPNG {
+			best = format
+			bestQ = q
+		}
+	}
+	return best, nil
+}
+
+func parseAcceptPart(part string) (string, float64) {
+	fields := strings.Split(part, ";")
+	mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+	q := 1.0
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok || strings.TrimSpace(key) != "q" {
+			continue
+		}
+		if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+			q = parsed
+		}
+	}
+	return mediaType, q
+}
+
+// checkLayout reports label sizes or rotations the selected output cannot
+// represent.
+func checkLayout(layout *labelLayout, opts outputOptions) error {
+	width, height := layout.outputSize()
+	switch opts.format {
+	case formatBrother:
+		return opts.brotherMedia.checkFits(width, height)
+	case formatESCPOS:
+		if dots := escposDots(opts.paperWidth, layout.dpi); width > dots {
+			return fmt.Errorf("label width %d exceeds the %d printable dots of %dmm paper", width, dots, opts.paperWidth)
+		}
+	}
+	return nil
+}
+
+func encodeLayout(layout *labelLayout, opts outputOptions) ([]byte, error) {
+	switch opts.format {
+	case formatPDF:
+		return encodePDF(layout)
+	case formatSVG:
+		return encodeSVG(layout)
+	case formatZPL:
+		return encodeZPL(layout)
+	case formatBrother:
+		bitmap := monoBitmapFrom(drawLayout(layout), opts.dither)
+		return encodeBrotherRaster(bitmap, opts.brotherMedia, opts.compress, layout.dpi)
+	case formatESCPOS:
+		bitmap := monoBitmapFrom(drawLayout(layout), opts.dither)
+		return encodeESCPOS(bitmap, opts.paperWidth, layout.dpi)
+	default:
+		if opts.mono {
+			return encodePNGWithDPI(monoBitmapFrom(drawLayout(layout), opts.dither).paletted(), layout.dpi)
+		}
+		return encodePNGWithDPI(drawLayout(layout), layout.dpi)
+	}
+}
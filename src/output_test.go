@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		query  string
+		accept string
+		want   string
+	}{
+		{"", "", formatPNG},
+		{"", "text/html", formatPNG},
+		{"", "application/pdf", formatPDF},
+		{"", "image/svg+xml", formatSVG},
+		{"", "application/zpl", formatZPL},
+		// Chrome's Accept header for <img>.
+		{"", "image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8", formatPNG},
+		// Firefox's Accept header for <img>.
+		{"", "image/avif,image/webp,image/png,image/svg+xml,image/*;q=0.8,*/*;q=0.5", formatPNG},
+		{"", "image/*,image/svg+xml", formatPNG},
+		{"", "application/pdf,*/*", formatPNG},
+		{"", "application/pdf,*/*;q=0.9", formatPDF},
+		{"", "image/svg+xml;q=1.0,image/*;q=0.9", formatSVG},
+		{"", "application/pdf;q=0.5,image/svg+xml;q=0.8", formatSVG},
+		{"", "application/pdf,image/svg+xml", formatPDF},
+		{"", "image/png;q=0,application/pdf;q=0.1", formatPDF},
+		{"Format=svg", "image/png", formatSVG},
+		{"Format=PDF", "", formatPDF},
+	}
+	for _, tt := range tests {
+		values, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := negotiateFormat(values, tt.accept)
+		if err != nil {
+			t.Errorf("%q, %q: %v", tt.query, tt.accept, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q, Accept %q: %s, want %s", tt.query, tt.accept, got, tt.want)
+		}
+	}
+	if _, err := negotiateFormat(url.Values{"Format": {"gif"}}, ""); err == nil {
+		t.Error("Format=gif accepted")
+	}
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"fmt"
+	"math"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/skip2/go-qrcode"
 )
 
 func parseLabelParams(values url.Values) (labelParams, error) {
@@ -46,8 +51,21 @@ func parseLabelParams(values url.Values) (labelParams, error) {
 		descPrimary = ""
 	}
 
+	descriptionMode := strings.ToLower(strings.TrimSpace(queryGet(values, "DescriptionMode")))
+	if descriptionMode != "" && descriptionMode != "line" && descriptionMode != "block" {
+		return labelParams{}, fmt.Errorf("unsupported description mode %q (use line or block)", descriptionMode)
+	}
+	descriptionBlock := descriptionMode == "block"
+
 	secondaryText := strings.TrimSpace(rawAdditional)
-	if secondaryText == "" {
+	if descriptionBlock {
+		// The block keeps every description line not used as the title.
+		remaining := descLines
+		if len(descLines) > 0 && descPrimary == "" {
+			remaining = descLines[1:]
+		}
+		secondaryText = strings.Join(append(splitNonEmptyLines(secondaryText), remaining...), "\n")
+	} else if secondaryText == "" {
 		if descPrimary != "" {
 			secondaryText = descPrimary
 		} else if descSecondary != "" {
@@ -64,26 +82,218 @@ func parseLabelParams(values url.Values) (labelParams, error) {
 		idText = rawTitleTrim
 	}
 
+	templateName := strings.ToLower(strings.TrimSpace(queryGet(values, "Template")))
+	widthFallback := defaultWidth
+	heightFallback := defaultHeight
+	if templateName != "" && templateName != defaultTemplateName {
+		tpl, ok := lookupTemplate(templateName)
+		if !ok {
+			return labelParams{}, errUnknownTemplate(templateName)
+		}
+		if tpl.Width > 0 {
+			widthFallback = tpl.Width
+		}
+		if tpl.Height > 0 {
+			heightFallback = tpl.Height
+		}
+	}
+
+	// Media presets replace the defaults and template size; explicit
+	// parameters still win.
+	dpiFallback := defaultDPI
+	marginFallback := defaultMargin
+	dynamicFallback := false
+	var media *labelMedia
+	mediaName := queryGet(values, "Media")
+	if mediaName == "" && strings.EqualFold(strings.TrimSpace(queryGet(values, "Format")), formatBrother) {
+		// Brother raster output prints on the default stock, so the label
+		// takes its size and head resolution too.
+		mediaName = defaultBrotherMedia
+	}
+	if mediaName != "" {
+		var ok bool
+		if media, ok = lookupMedia(mediaName); !ok {
+			return labelParams{}, errUnknownMedia(mediaName)
+		}
+		dpiFallback = media.dpi
+		marginFallback = media.margin
+		widthFallback = media.printWidth
+		if media.continuous() {
+			dynamicFallback = true
+		} else {
+			heightFallback = media.printHeight
+		}
+		logDebug("using media %s: %dx%d at %g dpi", media.id, widthFallback, heightFallback, media.dpi)
+	}
+
+	titleFont, descriptionFont := fontBold, fontRegular
+	if name := queryGet(values, "TitleFont"); name != "" {
+		var ok bool
+		if titleFont, ok = lookupFont(name); !ok {
+			return labelParams{}, errUnknownFont(name)
+		}
+	}
+	if name := queryGet(values, "DescriptionFont"); name != "" {
+		var ok bool
+		if descriptionFont, ok = lookupFont(name); !ok {
+			return labelParams{}, errUnknownFont(name)
+		}
+	}
+
+	icon := defaultIcon()
+	if name := queryGet(values, "Icon"); name != "" {
+		var ok bool
+		if icon, ok = lookupIcon(name); !ok {
+			return labelParams{}, errUnknownIcon(name)
+		}
+	}
+
+	logo, ok := lookupLogo(queryGet(values, "Logo"))
+	if !ok {
+		return labelParams{}, errUnknownLogo(queryGet(values, "Logo"))
+	}
+	logoPosition, ok := parseLogoPosition(queryGet(values, "LogoPosition"))
+	if !ok {
+		return labelParams{}, fmt.Errorf("unsupported logo position %q (use icon, top-left, top-right, bottom-left or bottom-right)", queryGet(values, "LogoPosition"))
+	}
+	if logo != nil && logoPosition == logoAtIcon {
+		icon = logo
+	}
+
+	rotation := 0
+	if value := strings.TrimSpace(queryGet(values, "Rotate")); value != "" {
+		var err error
+		rotation, err = strconv.Atoi(value)
+		if err != nil || rotation%90 != 0 || rotation < 0 || rotation >= 360 {
+			return labelParams{}, fmt.Errorf("unsupported rotation %q (use 0, 90, 180 or 270)", value)
+		}
+	}
+	orientation := strings.ToLower(strings.TrimSpace(queryGet(values, "Orientation")))
+	if orientation != "" && orientation != orientationPortrait && orientation != orientationLandscape {
+		return labelParams{}, fmt.Errorf("unsupported orientation %q (use portrait or landscape)", orientation)
+	}
+
+	layoutMode, ok := parseLayoutMode(queryGet(values, "Layout"))
+	if !ok {
+		return labelParams{}, fmt.Errorf("unsupported layout %q (use auto, landscape, vertical, strip or square)", queryGet(values, "Layout"))
+	}
+
+	symbology := symbologyQR
+	if name := queryGet(values, "Barcode"); name != "" {
+		var ok bool
+		if symbology, ok = lookupSymbology(name); !ok {
+			return labelParams{}, fmt.Errorf("unsupported barcode %q", name)
+		}
+	}
+	barcodeData := strings.ToLower(strings.TrimSpace(queryGet(values, "BarcodeData")))
+	if barcodeData != "" && barcodeData != "url" && barcodeData != "id" {
+		return labelParams{}, fmt.Errorf("unsupported barcode data %q (use url or id)", barcodeData)
+	}
+
+	qrLevel := qrcode.Medium
+	if name := queryGet(values, "QrErrorCorrection"); name != "" {
+		var ok bool
+		if qrLevel, ok = parseQRLevel(name); !ok {
+			return labelParams{}, fmt.Errorf("unsupported QR error correction %q (use L, M, Q or H)", name)
+		}
+	}
+	titleMaxLines := parseInt(values, "TitleMaxLines", 0)
+	if titleMaxLines < 0 {
+		return labelParams{}, fmt.Errorf("invalid title max lines %d", titleMaxLines)
+	}
+	qrScaling := strings.ToLower(strings.TrimSpace(queryGet(values, "QrScaling")))
+	if qrScaling != "" && qrScaling != "fit" && qrScaling != "snap" {
+		return labelParams{}, fmt.Errorf("unsupported QR scaling %q (use fit or snap)", qrScaling)
+	}
+	fields, err := parseFields(values)
+	if err != nil {
+		return labelParams{}, err
+	}
+
+	dpi := parseFloat(values, "Dpi", dpiFallback)
+	if dpi <= 0 {
+		dpi = dpiFallback
+	}
+	// Sizes are pixels unless they have a unit suffix, which is converted at
+	// Dpi.
+	var width, height, margin, padding, qrSize, logoSize int
+	for _, size := range []struct {
+		key      string
+		fallback int
+		dst      *int
+	}{
+		{"Width", widthFallback, &width},
+		{"Height", heightFallback, &height},
+		{"Margin", marginFallback, &margin},
+		{"ComponentPadding", defaultPadding, &padding},
+		{"QrSize", defaultQRSize, &qrSize},
+		{"LogoSize", 0, &logoSize},
+	} {
+		if *size.dst, err = parseSize(values, size.key, size.fallback, dpi); err != nil {
+			return labelParams{}, err
+		}
+	}
+	var titleFontSize, titleMinFontSize, descriptionFontSize float64
+	for _, size := range []struct {
+		key      string
+		fallback float64
+		dst      *float64
+	}{
+		{"TitleFontSize", defaultTitleFontSize, &titleFontSize},
+		{"TitleMinFontSize", 0, &titleMinFontSize},
+		{"DescriptionFontSize", defaultDescFontSize, &descriptionFontSize},
+	} {
+		if *size.dst, err = parseLength(values, size.key, size.fallback, dpi); err != nil {
+			return labelParams{}, err
+		}
+	}
+
+	quietZone := parseInt(values, "QrQuietZone", 0)
+	if quietZone < 0 {
+		return labelParams{}, fmt.Errorf("invalid QR quiet zone %d", quietZone)
+	}
+
 	params := labelParams{
-		width:               parseInt(values, "Width", defaultWidth),
-		height:              parseInt(values, "Height", defaultHeight),
-		dpi:                 parseFloat(values, "Dpi", defaultDPI),
-		margin:              parseInt(values, "Margin", defaultMargin),
-		padding:             parseInt(values, "ComponentPadding", defaultPadding),
-		qrSize:              parseInt(values, "QrSize", defaultQRSize),
+		width:               width,
+		height:              height,
+		dpi:                 dpi,
+		margin:              margin,
+		padding:             padding,
+		qrSize:              qrSize,
 		url:                 rawURL,
 		titleText:           titleText,
 		secondaryText:       secondaryText,
 		idText:              idText,
-		titleFontSize:       parseFloat(values, "TitleFontSize", defaultTitleFontSize),
-		descriptionFontSize: parseFloat(values, "DescriptionFontSize", defaultDescFontSize),
+		titleFontSize:       titleFontSize,
+		titleMinFontSize:    titleMinFontSize,
+		titleMaxLines:       titleMaxLines,
+		descriptionBlock:    descriptionBlock,
+		descriptionFontSize: descriptionFontSize,
+		template:            templateName,
+		titleFont:           titleFont,
+		descriptionFont:     descriptionFont,
+		dynamicLength:       parseBool(values, "DynamicLength", dynamicFallback),
+		media:               media,
+		barcode:             symbology,
+		barcodeData:         barcodeData,
+		qrLevel:             qrLevel,
+		qrQuietZone:         quietZone,
+		qrSnap:              qrScaling == "snap",
+		fields:              fields,
+		icon:                icon,
+		logo:                logo,
+		logoPosition:        logoPosition,
+		logoSize:            logoSize,
+		rotation:            rotation,
+		orientation:         orientation,
+		layout:              layoutMode,
 	}
 
 	if params.width <= 0 {
-		params.width = defaultWidth
+		params.width = widthFallback
 	}
 	if params.height <= 0 {
-		params.height = defaultHeight
+		params.height = heightFallback
 	}
 	if params.dpi <= 0 {
 		params.dpi = defaultDPI
@@ -102,12 +312,29 @@ func parseLabelParams(values url.Values) (labelParams, error) {
 	if params.padding < 0 {
 		params.padding = 0
 	}
+	if params.logoSize <= 0 {
+		params.logoSize = minDim / 4
+	}
 	if params.titleFontSize <= 0 {
 		params.titleFontSize = defaultTitleFontSize
 	}
 	if params.descriptionFontSize <= 0 {
 		params.descriptionFontSize = defaultDescFontSize
 	}
+	// Default QR and font sizes grow with the label stock.
+	if media != nil {
+		scale := mediaScale(params.width, params.height, params.dynamicLength)
+		if queryGet(values, "QrSize") == "" {
+			params.qrSize = roundDots(defaultQRSize * scale)
+		}
+		if queryGet(values, "TitleFontSize") == "" {
+			params.titleFontSize = math.Round(defaultTitleFontSize * scale)
+		}
+		if queryGet(values, "DescriptionFontSize") == "" {
+			params.descriptionFontSize = math.Round(defaultDescFontSize * scale)
+		}
+		logDebug("media %s: default sizes scaled by %.2f", media.id, scale)
+	}
 
 	if params.qrSize <= 0 {
 		params.qrSize = defaultQRSize
@@ -116,7 +343,7 @@ func parseLabelParams(values url.Values) (labelParams, error) {
 	if maxQR < 1 {
 		maxQR = 1
 	}
-	if params.qrSize > maxQR {
+	if params.qrSize > maxQR && !params.dynamicLength {
 		logDebug("QR size %d clamped to maximum %d (label size: %dx%d, margin: %d)",
 			params.qrSize, maxQR, params.width, params.height, params.margin)
 		params.qrSize = maxQR
@@ -133,6 +360,86 @@ func parseLabelParams(values url.Values) (labelParams, error) {
 	return params, nil
 }
 
+// parseQRLevel maps the QR error correction letters to go-qrcode levels,
+// which name Q and H "High" and "Highest".
+func parseQRLevel(value string) (qrcode.RecoveryLevel, bool) {
+	switch strings.ToUpper(strings.TrimSpace(value)) {
+	case "L":
+		return qrcode.Low, true
+	case "M":
+		return qrcode.Medium, true
+	case "Q":
+		return qrcode.High, true
+	case "H":
+		return qrcode.Highest, true
+	}
+	return qrcode.Medium, false
+}
+
+// codeValue returns the text encoded by the label's machine-readable code.
+// By default 2D symbols carry the URL and linear barcodes the ID, falling
+// back to the other when it is empty.
+func codeValue(params labelParams) string {
+	if params.barcode == symbologyQR && params.barcodeData != "id" {
+		return params.url
+	}
+	url, id := strings.TrimSpace(params.url), params.idText
+	if params.barcodeData == "id" || params.barcodeData == "" && isLinearSymbology(params.barcode) {
+		return firstNonEmpty(id, url)
+	}
+	return firstNonEmpty(url, id)
+}
+
+// parseFields collects custom fields: repeated Field=Name:Value parameters in
+// request order, then Field.<Name>=<Value> parameters sorted by name. Keys
+// differing only in case (Field, field) are read in sorted order. Fields with
+// an empty value are skipped.
+func parseFields(values url.Values) ([]labelField, error) {
+	// Map order is random, so case variants of Field and the Field.Name keys
+	// are read in sorted order.
+	var plain, named []string
+	for key := range values {
+		switch {
+		case strings.EqualFold(key, "Field"):
+			plain = append(plain, key)
+		case len(key) > len("Field.") && strings.EqualFold(key[:len("Field.")], "Field."):
+			named = append(named, key)
+		}
+	}
+	sort.Strings(plain)
+	var fields []labelField
+	for _, key := range plain {
+		for _, raw := range values[key] {
+			name, value, ok := strings.Cut(raw, ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid field %q (use Name:Value)", raw)
+			}
+			if strings.TrimSpace(name) == "" {
+				return nil, fmt.Errorf("invalid field %q (empty name)", raw)
+			}
+			fields = append(fields, labelField{name: strings.TrimSpace(name), value: strings.TrimSpace(value)})
+		}
+	}
+	sort.Strings(named)
+	for _, key := range named {
+		name := strings.TrimSpace(key[len("Field."):])
+		if name == "" {
+			return nil, fmt.Errorf("invalid field parameter %q (empty name)", key)
+		}
+		for _, value := range values[key] {
+			fields = append(fields, labelField{name: name, value: strings.TrimSpace(value)})
+		}
+	}
+
+	kept := fields[:0]
+	for _, f := range fields {
+		if f.value != "" {
+			kept = append(kept, f)
+		}
+	}
+	return kept, nil
+}
+
 func queryGet(values url.Values, key string) string {
 	if value := values.Get(key); value != "" {
 		return value
@@ -145,6 +452,68 @@ func queryGet(values url.Values, key string) string {
 	return ""
 }
 
+// parseSize reads a length with parseLength and rounds it to whole dots.
+func parseSize(values url.Values, key string, fallback int, dpi float64) (int, error) {
+	if queryGet(values, key) == "" {
+		return fallback, nil
+	}
+	length, err := parseLength(values, key, 0, dpi)
+	if err != nil {
+		return 0, err
+	}
+	return roundDots(length), nil
+}
+
+// roundDots rounds half up, ignoring float noise such as 2.4in at 300 dpi
+// giving 719.9999 dots.
+func roundDots(v float64) int {
+	return int(math.Floor(v + 0.5 + 1e-6))
+}
+
+// parseLength reads a length in pixels, or with a px, mm, cm, in or pt
+// suffix converted to pixels at dpi.
+func parseLength(values url.Values, key string, fallback, dpi float64) (float64, error) {
+	raw := queryGet(values, key)
+	if raw == "" {
+		return fallback, nil
+	}
+	value := strings.ToLower(strings.TrimSpace(raw))
+	scale := 1.0
+	for _, unit := range []struct {
+		suffix string
+		scale  float64
+	}{
+		{"px", 1},
+		{"mm", dotsPerMM(dpi)},
+		{"cm", 10 * dotsPerMM(dpi)},
+		{"in", dpi},
+		{"pt", dpi / 72},
+	} {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			scale = unit.scale
+			break
+		}
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+		return 0, fmt.Errorf("invalid %s %q (use pixels or a number with mm, cm, in or pt)", key, raw)
+	}
+	return number * scale, nil
+}
+
+// dotsPerMM converts dpi to dots per millimetre. Printers sold as 203 dpi
+// have exactly 8 dots/mm (203.2 dpi), so a dpi within 0.5% of a whole
+// number of dots per millimetre uses that number; 62mm is then 496 dots
+// rather than 495.5.
+func dotsPerMM(dpi float64) float64 {
+	perMM := dpi / 25.4
+	if whole := math.Round(perMM); whole > 0 && math.Abs(perMM-whole) <= whole*0.005 {
+		return whole
+	}
+	return perMM
+}
+
 func parseInt(values url.Values, key string, fallback int) int {
 	value := queryGet(values, key)
 	if value == "" {
@@ -169,6 +538,18 @@ func parseFloat(values url.Values, key string, fallback float64) float64 {
 	return parsed
 }
 
+func parseBool(values url.Values, key string, fallback bool) bool {
+	value := queryGet(values, key)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return fallback
+	}
+	return parsed
+}
+
 func firstNonEmpty(values ...string) string {
 	for _, value := range values {
 		if strings.TrimSpace(value) != "" {
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value string
+		dpi   float64
+		want  int
+	}{
+		{"320", 203, 320},
+		{"320px", 203, 320},
+		// 203 dpi printers have exactly 8 dots/mm.
+		{"62mm", 203, 496},
+		{"6.2cm", 203, 496},
+		{"1in", 203, 203},
+		// 11.81 dots/mm is 1.6% off 12, so 300 dpi is not snapped.
+		{"62mm", 300, 732},
+		{"29mm", 300, 343},
+		// 2.4 x 300 is 719.9999... in floating point.
+		{"2.4in", 300, 720},
+		{"72pt", 203, 203},
+		{"36pt", 300, 150},
+		{"10.5pt", 72, 11},
+		// Within 0.5% of 8 dots/mm snaps, beyond it does not.
+		{"100mm", 204.2, 800},
+		{"100mm", 204.3, 804},
+		{"100mm", 202.2, 800},
+		{"100mm", 202.1, 796},
+		// Half up: .5 rounds up, anything below it down.
+		{"10.5", 203, 11},
+		{"10.4999", 203, 10},
+		{"0.5", 203, 1},
+		{" 62 MM ", 203, 496},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(url.Values{"Width": {tt.value}}, "Width", -1, tt.dpi)
+		if err != nil {
+			t.Errorf("parseSize(%q at %g dpi): %v", tt.value, tt.dpi, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseSize(%q at %g dpi) = %d, want %d", tt.value, tt.dpi, got, tt.want)
+		}
+	}
+	if got, err := parseSize(url.Values{}, "Width", 42, 203); err != nil || got != 42 {
+		t.Errorf("missing Width = %d, %v, want the fallback 42", got, err)
+	}
+}
+
+func TestParseLength(t *testing.T) {
+	tests := []struct {
+		value string
+		dpi   float64
+		want  float64
+	}{
+		{"12pt", 300, 50},
+		{"12pt", 72, 12},
+		{"2mm", 203, 16},
+		{"16.5", 203, 16.5},
+	}
+	for _, tt := range tests {
+		got, err := parseLength(url.Values{"TitleFontSize": {tt.value}}, "TitleFontSize", 0, tt.dpi)
+		if err != nil || got != tt.want {
+			t.Errorf("parseLength(%q at %g dpi) = %g, %v, want %g", tt.value, tt.dpi, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseSizeInvalid(t *testing.T) {
+	for _, value := range []string{"abc", "62ft", "62mmm", "mm", "1,5mm", "NaN", "Infmm", "1e400", "62 m m"} {
+		if _, err := parseSize(url.Values{"Width": {value}}, "Width", 320, 203); err == nil {
+			t.Errorf("parseSize(%q) accepted", value)
+		}
+	}
+
+	// Invalid sizes are rejected rather than replaced by the default.
+	for _, query := range []string{"Width=abc", "Height=62ft", "QrSize=big", "TitleFontSize=12px12"} {
+		req := httptest.NewRequest(http.MethodGet, "/?"+query, nil)
+		rec := httptest.NewRecorder()
+		labelHandler(rec, req)
+		key := strings.SplitN(query, "=", 2)[0]
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid "+key) {
+			t.Errorf("%s: status %d %q, want 400 naming %s", query, rec.Code, rec.Body, key)
+		}
+	}
+}
+
+func TestDotsPerMM(t *testing.T) {
+	tests := []struct {
+		dpi  float64
+		want float64
+	}{
+		{203, 8},
+		{203.2, 8},
+		{304.8, 12},
+		{305, 12},
+		{609.6, 24},
+		// 0 means not snapped.
+		{600, 0},
+		{180, 0},
+		{300, 0},
+		{72, 0},
+	}
+	for _, tt := range tests {
+		want := tt.want
+		if want == 0 {
+			want = tt.dpi / 25.4
+		}
+		if got := dotsPerMM(tt.dpi); got != want {
+			t.Errorf("dotsPerMM(%g) = %g, want %g", tt.dpi, got, want)
+		}
+	}
+}
+
+func TestParseFields(t *testing.T) {
+	values := url.Values{
+		"field":        {"Colour:red"},
+		"Field":        {"Serial: SN-1", "Bin:B3", "Empty:"},
+		"FIELD":        {"Owner:Sam"},
+		"Field.Room":   {"Garage"},
+		"field.Aisle":  {"4"},
+		"Field.Unused": {" "},
+	}
+	want := []labelField{
+		{"Owner", "Sam"},
+		{"Serial", "SN-1"},
+		{"Bin", "B3"},
+		{"Colour", "red"},
+		{"Room", "Garage"},
+		{"Aisle", "4"},
+	}
+	// Map order differs between runs, so repeat to catch a merge that
+	// depends on it.
+	for i := 0; i < 20; i++ {
+		got, err := parseFields(values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("parseFields = %v, want %v", got, want)
+		}
+	}
+
+	for _, values := range []url.Values{
+		{"Field": {"no colon"}},
+		{"field": {" :value"}},
+		{"Field. ": {"value"}},
+	} {
+		if _, err := parseFields(values); err == nil {
+			t.Errorf("parseFields(%v) accepted", values)
+		}
+	}
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// pdfDocument writes a minimal PDF with one page per label. Text is drawn
+// with embedded TrueType fonts and everything else as vector paths; label
+// sheets place rendered labels as image XObjects.
+type pdfDocument struct {
+	objects   [][]byte
+	pageIDs   []int
+	pagesID   int
+	resources int
+	fonts     []*pdfFont
+	images    []int
+	// written counts the bytes of the objects so far, to stop large
+	// documents early.
+	written int
+}
+
+type pdfFont struct {
+	id     int
+	name   string
+	source *labelFont
+	sfnt   *sfnt.Font
+	buf    sfnt.Buffer
+	glyphs map[sfnt.GlyphIndex]rune
+}
+
+func encodePDF(layouts ...*labelLayout) ([]byte, error) {
+	doc := newPDFDocument()
+	for _, layout := range layouts {
+		if err := doc.addPage(layout); err != nil {
+			return nil, err
+		}
+	}
+	return doc.bytes()
+}
+
+func newPDFDocument() *pdfDocument {
+	doc := &pdfDocument{}
+	doc.reserve() // catalog
+	doc.pagesID = doc.reserve()
+	doc.resources = doc.reserve()
+	return doc
+}
+
+func (d *pdfDocument) reserve() int {
+	d.objects = append(d.objects, nil)
+	return len(d.objects)
+}
+
+func (d *pdfDocument) set(id int, body string) {
+	d.objects[id-1] = []byte(body)
+	d.written += len(body)
+}
+
+func (d *pdfDocument) addStream(dict string, data []byte) (int, error) {
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	if _, err := zw.Write(data); err != nil {
+		return 0, err
+	}
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+	id := d.reserve()
+	var obj bytes.Buffer
+	fmt.Fprintf(&obj, "<< %s /Filter /FlateDecode /Length %d >>\nstream\n", dict, compressed.Len())
+	obj.Write(compressed.Bytes())
+	obj.WriteString("\nendstream")
+	d.objects[id-1] = obj.Bytes()
+	d.written += obj.Len()
+	return id, nil
+}
+
+func (d *pdfDocument) addPage(layout *labelLayout) error {
+	if layout.dpi <= 0 {
+		return errors.New("invalid dpi")
+	}
+	content, err := d.pageContent(layout)
+	if err != nil {
+		return err
+	}
+	scale := 72.0 / layout.dpi
+	width, height := layout.outputSize()
+	return d.addRawPage(float64(width)*scale, float64(height)*scale, content)
+}
+
+// addRawPage adds a page of the given size in points with a ready-made
+// content stream.
+func (d *pdfDocument) addRawPage(width, height float64, content []byte) error {
+	contentID, err := d.addStream("", content)
+	if err != nil {
+		return err
+	}
+	pageID := d.reserve()
+	d.set(pageID, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %s %s] /Resources %d 0 R /Contents %d 0 R >>",
+		d.pagesID, formatNumber(width), formatNumber(height), d.resources, contentID))
+	d.pageIDs = append(d.pageIDs, pageID)
+	return nil
+}
+
+// addImage stores img as an image XObject and returns its resource name.
+// Images without colour are written as DeviceGray.
+func (d *pdfDocument) addImage(img image.Image) (string, error) {
+	bounds := img.Bounds()
+	gray := true
+	rgb := make([]byte, 0, bounds.Dx()*bounds.Dy()*3)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			// Composite onto white like the PNG viewers do.
+			r := blendWhite(c.R, c.A)
+			g := blendWhite(c.G, c.A)
+			b := blendWhite(c.B, c.A)
+			gray = gray && r == g && g == b
+			rgb = append(rgb, r, g, b)
+		}
+	}
+	colorSpace := "/DeviceRGB"
+	data := rgb
+	if gray {
+		colorSpace = "/DeviceGray"
+		data = make([]byte, len(rgb)/3)
+		for i := range data {
+			data[i] = rgb[i*3]
+		}
+	}
+	id, err := d.addStream(fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8",
+		bounds.Dx(), bounds.Dy(), colorSpace), data)
+	if err != nil {
+		return "", err
+	}
+	d.images = append(d.images, id)
+	return "Im" + strconv.Itoa(len(d.images)), nil
+}
+
+func blendWhite(v, alpha uint8) uint8 {
+	return uint8((int(v)*int(alpha) + 255*(255-int(alpha)) + 127) / 255)
+}
+
+func (d *pdfDocument) bytes() ([]byte, error) {
+	if len(d.pageIDs) == 0 {
+		return nil, errors.New("pdf has no pages")
+	}
+	for _, f := range d.fonts {
+		if err := d.writeFont(f); err != nil {
+			return nil, err
+		}
+	}
+
+	fontRefs := make([]string, 0, len(d.fonts))
+	for _, f := range d.fonts {
+		fontRefs = append(fontRefs, fmt.Sprintf("/%s %d 0 R", f.name, f.id))
+	}
+	resources := fmt.Sprintf("/Font << %s >>", strings.Join(fontRefs, " "))
+	if len(d.images) > 0 {
+		imageRefs := make([]string, 0, len(d.images))
+		for i, id := range d.images {
+			imageRefs = append(imageRefs, fmt.Sprintf("/Im%d %d 0 R", i+1, id))
+		}
+		resources += fmt.Sprintf(" /XObject << %s >>", strings.Join(imageRefs, " "))
+	}
+	d.set(d.resources, "<< "+resources+" >>")
+
+	kids := make([]string, 0, len(d.pageIDs))
+	for _, id := range d.pageIDs {
+		kids = append(kids, fmt.Sprintf("%d 0 R", id))
+	}
+	d.set(d.pagesID, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pageIDs)))
+	d.set(1, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", d.pagesID))
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(d.objects))
+	for i, obj := range d.objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n", i+1)
+		out.Write(obj)
+		out.WriteString("\nendobj\n")
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(d.objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(d.objects)+1, xref)
+	return out.Bytes(), nil
+}
+
+func (d *pdfDocument) font(f *labelFont) (*pdfFont, error) {
+	for _, existing := range d.fonts {
+		if existing.source == f {
+			return existing, nil
+		}
+	}
+	parsed, err := f.parse()
+	if err != nil {
+		return nil, err
+	}
+	pf := &pdfFont{
+		id:     d.reserve(),
+		name:   "F" + strconv.Itoa(len(d.fonts)+1),
+		source: f,
+		sfnt:   parsed,
+		glyphs: map[sfnt.GlyphIndex]rune{},
+	}
+	d.fonts = append(d.fonts, pf)
+	return pf, nil
+}
+
+func (f *pdfFont) glyph(r rune) sfnt.GlyphIndex {
+	gid, err := f.sfnt.GlyphIndex(&f.buf, r)
+	if err != nil {
+		gid = 0
+	}
+	if _, ok := f.glyphs[gid]; !ok && gid != 0 {
+		f.glyphs[gid] = r
+	}
+	return gid
+}
+
+// glyphWidth returns the unhinted advance of gid in 1/1000 em, the unit PDF
+// uses for glyph widths and TJ adjustments.
+func (f *pdfFont) glyphWidth(gid sfnt.GlyphIndex) float64 {
+	upem := fixed.I(int(f.sfnt.UnitsPerEm()))
+	advance, err := f.sfnt.GlyphAdvance(&f.buf, gid, upem, font.HintingNone)
+	if err != nil {
+		return 0
+	}
+	return float64(advance) / float64(upem) * 1000
+}
+
+func (d *pdfDocument) writeFont(f *pdfFont) error {
+	upem := fixed.I(int(f.sfnt.UnitsPerEm()))
+	toThousandths := func(v fixed.Int26_6) int {
+		return int(math.Round(float64(v) / float64(upem) * 1000))
+	}
+	bounds, err := f.sfnt.Bounds(&f.buf, upem, font.HintingNone)
+	if err != nil {
+		return err
+	}
+	metrics, err := f.sfnt.Metrics(&f.buf, upem, font.HintingNone)
+	if err != nil {
+		return err
+	}
+	gids := make([]int, 0, len(f.glyphs))
+	runes := make([]rune, 0, len(f.glyphs))
+	for gid, r := range f.glyphs {
+		gids = append(gids, int(gid))
+		runes = append(runes, r)
+	}
+	sort.Ints(gids)
+
+	// TrueType fonts are subset to the glyphs used and named with a subset
+	// tag. OpenType fonts with CFF outlines are embedded whole as FontFile3;
+	// the CIDs of a CIDFontType0 are then glyph indices like with
+	// CIDToGIDMap.
+	baseName := pdfName(f.source.name)
+	data, subset := f.source.subset(runes)
+	fontFileKey, fontFileDict, cidSubtype, cidToGID := "FontFile2", fmt.Sprintf("/Length1 %d", len(data)), "CIDFontType2", " /CIDToGIDMap /Identity"
+	if f.source.isCFF() {
+		fontFileKey, fontFileDict, cidSubtype, cidToGID = "FontFile3", "/Subtype /OpenType", "CIDFontType0", ""
+	} else if subset {
+		baseName = subsetTag(gids) + "+" + baseName
+	}
+	fontFileID, err := d.addStream(fontFileDict, data)
+	if err != nil {
+		return err
+	}
+	descriptorID := d.reserve()
+	d.set(descriptorID, fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags 4 /FontBBox [%d %d %d %d] /ItalicAngle 0 /Ascent %d /Descent %d /CapHeight %d /StemV 80 /%s %d 0 R >>",
+		baseName, toThousandths(bounds.Min.X), -toThousandths(bounds.Max.Y), toThousandths(bounds.Max.X), -toThousandths(bounds.Min.Y),
+		toThousandths(metrics.Ascent), -toThousandths(metrics.Descent), toThousandths(metrics.CapHeight), fontFileKey, fontFileID))
+
+	var widths strings.Builder
+	for _, gid := range gids {
+		fmt.Fprintf(&widths, "%d [%s] ", gid, formatNumber(f.glyphWidth(sfnt.GlyphIndex(gid))))
+	}
+	cidFontID := d.reserve()
+	d.set(cidFontID, fmt.Sprintf("<< /Type /Font /Subtype /%s /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %d 0 R /W [%s]%s >>",
+		cidSubtype, baseName, descriptorID, strings.TrimSpace(widths.String()), cidToGID))
+
+	toUnicodeID, err := d.addStream("", pdfToUnicodeCMap(gids, f.glyphs))
+	if err != nil {
+		return err
+	}
+	d.set(f.id, fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>",
+		baseName, cidFontID, toUnicodeID))
+	return nil
+}
+
+func pdfToUnicodeCMap(gids []int, glyphs map[sfnt.GlyphIndex]rune) []byte {
+	var b bytes.Buffer
+	b.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n")
+	b.WriteString("/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n")
+	b.WriteString("/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n")
+	b.WriteString("1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	for start := 0; start < len(gids); start += 100 {
+		end := minInt(start+100, len(gids))
+		fmt.Fprintf(&b, "%d beginbfchar\n", end-start)
+		for _, gid := range gids[start:end] {
+			var unicode strings.Builder
+			for _, unit := range utf16.Encode([]rune{glyphs[sfnt.GlyphIndex(gid)]}) {
+				fmt.Fprintf(&unicode, "%04X", unit)
+			}
+			fmt.Fprintf(&b, "<%04X> <%s>\n", gid, unicode.String())
+		}
+		b.WriteString("endbfchar\n")
+	}
+	b.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+	return b.Bytes()
+}
+
+func (d *pdfDocument) pageContent(layout *labelLayout) ([]byte, error) {
+	var b bytes.Buffer
+	scale := 72.0 / layout.dpi
+	_, outHeight := layout.outputSize()
+	height := float64(outHeight)
+	// Work in pixel units with a top-left origin like the raster renderer.
+	fmt.Fprintf(&b, "%s 0 0 %s 0 %s cm\n0 g 0 G\n", formatNumber(scale), formatNumber(-scale), formatNumber(height*scale))
+	if layout.rotation != 0 {
+		m := layout.rotationMatrix()
+		fmt.Fprintf(&b, "%d %d %d %d %d %d cm\n", m[0], m[1], m[2], m[3], m[4], m[5])
+	}
+
+	for _, el := range layout.elements {
+		switch el.kind {
+		case elementText:
+			if err := d.writeText(&b, layout, el); err != nil {
+				return nil, err
+			}
+		case elementQR:
+			writePDFMatrix(&b, el.qr.Bitmap(), float64(el.x), float64(el.y), float64(el.width))
+		case elementMatrix:
+			module, offset := matrixModule(len(el.matrix), el.width)
+			writePDFMatrix(&b, el.matrix, float64(el.x+offset), float64(el.y+offset), float64(module*len(el.matrix)))
+		case elementIcon:
+			if el.icon.image != nil {
+				name, err := d.addImage(el.icon.scaled(el.width, el.height))
+				if err != nil {
+					return nil, err
+				}
+				// Images are drawn in a unit square with y up.
+				fmt.Fprintf(&b, "q %d 0 0 %d %d %d cm /%s Do Q\n", el.width, -el.height, el.x, el.y+el.height, name)
+				continue
+			}
+			writePDFSegments(&b, el.icon.segments(el.x, el.y, el.width, el.height), iconThickness(el.width))
+		case elementBarcode:
+			module := barcodeModuleWidth(el.modules, el.width)
+			offsetX := el.x + maxInt(0, (el.width-module*len(el.modules))/2)
+			for _, run := range darkRuns(el.modules) {
+				fmt.Fprintf(&b, "%d %d %d %d re\n", offsetX+run[0]*module, el.y, (run[1]-run[0])*module, el.height)
+			}
+			b.WriteString("f\n")
+		case elementLine:
+			writePDFSegments(&b, []lineSegment{{el.x, el.y, el.x2, el.y2}}, el.thickness)
+		case elementBox:
+			if el.filled {
+				fmt.Fprintf(&b, "%d %d %d %d re f\n", el.x, el.y, el.width, el.height)
+				continue
+			}
+			t := float64(maxInt(1, el.thickness))
+			fmt.Fprintf(&b, "%s w %s %s %s %s re S\n", formatNumber(t),
+				formatNumber(float64(el.x)+t/2), formatNumber(float64(el.y)+t/2), formatNumber(float64(el.width)-t), formatNumber(float64(el.height)-t))
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// writeText places every glyph at the pixel position the raster renderer
+// uses, so PDF and PNG output line up exactly.
+// Runes drawn from fallback fonts get their own text object.
+func (d *pdfDocument) writeText(b *bytes.Buffer, layout *labelLayout, el labelElement) error {
+	for _, line := range placeTextLines(el, layout.height) {
+		for _, run := range line.runs() {
+			pf, err := d.font(line.fonts[run[0]])
+			if err != nil {
+				return err
+			}
+			runes, xs := line.runes[run[0]:run[1]], line.x[run[0]:run[1]]
+			gids := make([]sfnt.GlyphIndex, len(runes))
+			for j, r := range runes {
+				gids[j] = pf.glyph(r)
+			}
+
+			// The page matrix flips y, so flip the text matrix back.
+			fmt.Fprintf(b, "BT /%s %s Tf 1 0 0 -1 %s %d Tm [", pf.name, formatNumber(el.fontSize), formatFixed(xs[0]), line.baseline)
+			for j, gid := range gids {
+				if j > 0 {
+					natural := pf.glyphWidth(gids[j-1])
+					actual := float64(xs[j]-xs[j-1]) / 64 / el.fontSize * 1000
+					if adjust := natural - actual; math.Abs(adjust) > 0.01 {
+						b.WriteString(formatNumber(adjust))
+					}
+				}
+				fmt.Fprintf(b, "<%04X>", int(gid))
+			}
+			b.WriteString("] TJ ET\n")
+		}
+	}
+	return nil
+}
+
+func writePDFMatrix(b *bytes.Buffer, bitmap [][]bool, x, y, size float64) {
+	if len(bitmap) == 0 {
+		return
+	}
+	module := size / float64(len(bitmap))
+	for row, cells := range bitmap {
+		for _, run := range darkRuns(cells) {
+			fmt.Fprintf(b, "%s %s %s %s re\n", formatNumber(x+float64(run[0])*module), formatNumber(y+float64(row)*module),
+				formatNumber(float64(run[1]-run[0])*module), formatNumber(module))
+		}
+	}
+	b.WriteString("f\n")
+}
+
+func writePDFSegments(b *bytes.Buffer, segments []lineSegment, thickness int) {
+	if len(segments) == 0 {
+		return
+	}
+	// drawThickPoint centres odd brushes half a pixel right/down.
+	offset := 0.5 * float64(thickness%2)
+	fmt.Fprintf(b, "%d w 2 J 0 j\n", thickness)
+	for _, seg := range segments {
+		fmt.Fprintf(b, "%s %s m %s %s l\n",
+			formatNumber(float64(seg.x0)+offset), formatNumber(float64(seg.y0)+offset),
+			formatNumber(float64(seg.x1)+offset), formatNumber(float64(seg.y1)+offset))
+	}
+	b.WriteString("S\n")
+}
+
+// darkRuns returns the [start, end) index pairs of consecutive dark modules.
+func darkRuns(modules []bool) [][2]int {
+	var runs [][2]int
+	for i := 0; i < len(modules); i++ {
+		if !modules[i] {
+			continue
+		}
+		start := i
+		for i < len(modules) && modules[i] {
+			i++
+		}
+		runs = append(runs, [2]int{start, i})
+	}
+	return runs
+}
+
+func pdfName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if r > ' ' && r < 0x7f && !strings.ContainsRune("()<>[]{}/%#", r) {
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() == 0 {
+		return "Font"
+	}
+	return b.String()
+}
@@ -0,0 +1,63 @@
+package main
+
+// galoisField is GF(2^m) built from a primitive polynomial, as used by the
+// Reed-Solomon error correction of DataMatrix and Aztec.
+type galoisField struct {
+	size int
+	exp  []int
+	log  []int
+}
+
+func newGaloisField(primitive, size int) *galoisField {
+	gf := &galoisField{size: size, exp: make([]int, size), log: make([]int, size)}
+	x := 1
+	for i := 0; i < size; i++ {
+		gf.exp[i] = x
+		x <<= 1
+		if x >= size {
+			x ^= primitive
+		}
+	}
+	for i := 0; i < size-1; i++ {
+		gf.log[gf.exp[i]] = i
+	}
+	return gf
+}
+
+func (gf *galoisField) mul(a, b int) int {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gf.exp[(gf.log[a]+gf.log[b])%(gf.size-1)]
+}
+
+// rsGenerator returns the coefficients of (x - a^1)(x - a^2)...(x - a^n),
+// highest power first.
+func (gf *galoisField) rsGenerator(n int) []int {
+	poly := []int{1}
+	for i := 1; i <= n; i++ {
+		next := make([]int, len(poly)+1)
+		root := gf.exp[i%(gf.size-1)]
+		for j, c := range poly {
+			next[j] ^= c
+			next[j+1] ^= gf.mul(c, root)
+		}
+		poly = next
+	}
+	return poly
+}
+
+// rsEncode returns n error correction words for data.
+func (gf *galoisField) rsEncode(data []int, n int) []int {
+	generator := gf.rsGenerator(n)
+	ecc := make([]int, n)
+	for _, d := range data {
+		factor := d ^ ecc[0]
+		copy(ecc, ecc[1:])
+		ecc[n-1] = 0
+		for i := 0; i < n; i++ {
+			ecc[i] ^= gf.mul(generator[i+1], factor)
+		}
+	}
+	return ecc
+}
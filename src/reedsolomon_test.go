@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestGaloisFieldTables(t *testing.T) {
+	fields := map[string]*galoisField{"datamatrix": dataMatrixField}
+	for wordSize, gf := range aztecFields {
+		fields[fmt.Sprintf("aztec GF(2^%d)", wordSize)] = gf
+	}
+	for name, gf := range fields {
+		seen := make([]bool, gf.size)
+		for i := 0; i < gf.size-1; i++ {
+			v := gf.exp[i]
+			if v == 0 || seen[v] {
+				t.Fatalf("%s: exp[%d] = %d repeats; polynomial is not primitive", name, i, v)
+			}
+			seen[v] = true
+			if gf.log[v] != i {
+				t.Fatalf("%s: log[exp[%d]] = %d", name, i, gf.log[v])
+			}
+		}
+		if gf.mul(gf.exp[1], gf.exp[gf.size-2]) != 1 {
+			t.Errorf("%s: a * a^-1 != 1", name)
+		}
+	}
+}
+
+func TestRSEncodeDataMatrix(t *testing.T) {
+	tests := []struct {
+		data []int
+		want []int
+	}{
+		// ISO/IEC 16022 annex example for "123456" in a 10x10 symbol.
+		{[]int{142, 164, 186}, []int{114, 25, 5, 88, 102}},
+		{[]int{66, 129, 70}, []int{138, 234, 82, 82, 95}},
+	}
+	for _, tt := range tests {
+		if got := dataMatrixField.rsEncode(tt.data, len(tt.want)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("rsEncode(%v) = %v, want %v", tt.data, got, tt.want)
+		}
+	}
+}
+
+// The generator must vanish at each of its roots a^1..a^n.
+func TestRSGeneratorRoots(t *testing.T) {
+	gf := dataMatrixField
+	for _, n := range []int{5, 18, 68} {
+		generator := gf.rsGenerator(n)
+		if len(generator) != n+1 || generator[0] != 1 {
+			t.Fatalf("rsGenerator(%d) = %v", n, generator)
+		}
+		for i := 1; i <= n; i++ {
+			root, value := gf.exp[i], 0
+			for _, c := range generator {
+				value = gf.mul(value, root) ^ c
+			}
+			if value != 0 {
+				t.Errorf("rsGenerator(%d) at a^%d = %d", n, i, value)
+			}
+		}
+	}
+}
@@ -3,17 +3,22 @@ package main
 import (
 	"errors"
 	"image"
-	"image/color"
-	"image/draw"
 	"strings"
 
-	"github.com/skip2/go-qrcode"
 	"golang.org/x/image/font"
-	"golang.org/x/image/font/gofont/gobold"
-	"golang.org/x/image/font/gofont/goregular"
 )
 
 func renderLabel(params labelParams) (image.Image, error) {
+	layout, err := layoutLabel(params)
+	if err != nil {
+		return nil, err
+	}
+	return drawLayout(layout), nil
+}
+
+// layoutLabel positions all label elements without drawing them, so every
+// output format shares the same layout.
+func layoutLabel(params labelParams) (*labelLayout, error) {
 	logDebug("starting label rendering: %dx%d", params.width, params.height)
 
 	if params.width <= 0 || params.height <= 0 {
@@ -21,8 +26,49 @@ func renderLabel(params labelParams) (image.Image, error) {
 		return nil, errors.New("invalid label size")
 	}
 
-	img := image.NewRGBA(image.Rect(0, 0, params.width, params.height))
-	draw.Draw(img, img.Bounds(), &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+	// The layout is built in the logical orientation and only turned when
+	// it is drawn, so Width and Height stay the physical output size.
+	rotation := params.labelRotation()
+	if rotation == 90 || rotation == 270 {
+		params.width, params.height = params.height, params.width
+		if params.dynamicLength {
+			logDebug("label is rotated by %d degrees; ignoring DynamicLength", rotation)
+			params.dynamicLength = false
+		}
+	}
+
+	layout, err := buildLayout(params)
+	if err != nil {
+		return nil, err
+	}
+	addLogo(layout, params)
+	layout.rotation = rotation
+	if rotation != 0 {
+		logDebug("rotating label by %d degrees", rotation)
+	}
+
+	logDebug("label rendering completed successfully")
+	return layout, nil
+}
+
+// labelRotation returns the clockwise rotation from the logical layout to the
+// physical Width x Height output. Unless Rotate is set, Orientation turns the
+// layout by 90 degrees when the physical shape does not match it.
+func (p labelParams) labelRotation() int {
+	if p.rotation != 0 || p.orientation == "" || p.width == p.height {
+		return p.rotation
+	}
+	if (p.orientation == orientationPortrait) != (p.height > p.width) {
+		return 90
+	}
+	return 0
+}
+
+// layoutDefault is the built-in "default" template: title on top, secondary
+// text below it, QR bottom-left, open-box icon on the right and the ID block
+// bottom-right.
+func layoutDefault(params labelParams) (*labelLayout, error) {
+	layout := newLabelLayout(params)
 
 	innerWidth := params.width - 2*params.margin
 	innerHeight := params.height - 2*params.margin
@@ -33,35 +79,27 @@ func renderLabel(params labelParams) (image.Image, error) {
 
 	logDebug("inner dimensions: %dx%d (margins: %d)", innerWidth, innerHeight, params.margin)
 
-	titleFace, err := newFontFace(gobold.TTF, params.titleFontSize, params.dpi)
+	titleFace, err := newFontFace(params.titleFont, params.titleFontSize, params.dpi)
 	if err != nil {
 		return nil, err
 	}
-	descFace, err := newFontFace(goregular.TTF, params.descriptionFontSize, params.dpi)
+	descFace, err := newFontFace(params.descriptionFont, params.descriptionFontSize, params.dpi)
 	if err != nil {
 		return nil, err
 	}
 	idLabelSize := maxFloat(params.descriptionFontSize*0.85, 11.0)
 	idValueSize := maxFloat(params.descriptionFontSize*1.4, params.descriptionFontSize+4.0)
-	idLabelFace, err := newFontFace(goregular.TTF, idLabelSize, params.dpi)
+	idLabelFace, err := newFontFace(params.descriptionFont, idLabelSize, params.dpi)
 	if err != nil {
 		return nil, err
 	}
-	idValueFace, err := newFontFace(gobold.TTF, idValueSize, params.dpi)
+	idValueFace, err := newFontFace(params.titleFont, idValueSize, params.dpi)
 	if err != nil {
 		return nil, err
 	}
 
-	titleDrawer := &font.Drawer{
-		Dst:  img,
-		Src:  image.Black,
-		Face: titleFace,
-	}
-	descDrawer := &font.Drawer{
-		Dst:  img,
-		Src:  image.Black,
-		Face: descFace,
-	}
+	titleDrawer := &font.Drawer{Face: titleFace}
+	descDrawer := &font.Drawer{Face: descFace}
 
 	// Calculate column layout first to determine header width
 	colGap := maxInt(params.padding, 4)
@@ -91,101 +129,252 @@ func renderLabel(params labelParams) (image.Image, error) {
 		rightColX = leftColX
 	}
 
-	// Title uses full width and stays on one line to avoid shrinking QR space.
+	// Title uses full width and stays on one line to avoid shrinking QR space,
+	// unless the label length grows with its content or TitleMaxLines and
+	// TitleMinFontSize allow wrapping and shrinking it.
 	headerWidth := innerWidth
 	headerX := params.margin
 	cursorY := params.margin
 	titleBottom := cursorY
-	titleText := strings.TrimSpace(params.titleText)
+	// Arabic is shaped before measuring, since joined forms have other
+	// widths; right-to-left text is aligned to the right edge.
+	titleText := shapeArabic(strings.TrimSpace(params.titleText))
 	if titleText != "" {
-		if titleDrawer.MeasureString(titleText).Ceil() > headerWidth {
-			titleText = truncateWithEllipsis(titleText, headerWidth, titleDrawer)
+		titleLines := []string{titleText}
+		titleSize := params.titleFontSize
+		minTitleSize := params.titleMinFontSize
+		if minTitleSize <= 0 || minTitleSize > titleSize {
+			minTitleSize = titleSize
 		}
-		drawTextLines(titleDrawer, []string{titleText}, headerX, cursorY, headerWidth, alignLeft)
-		cursorY += textBlockHeight(titleDrawer.Face, 1)
+		maxTitleLines := maxInt(params.titleMaxLines, 1)
+		switch {
+		case params.dynamicLength && params.titleMaxLines == 0:
+			titleLines = wrapText(titleText, headerWidth, titleDrawer)
+		case maxTitleLines > 1 || minTitleSize < titleSize:
+			titleFace, titleSize, titleLines, err = fitText(titleText, params.titleFont, titleSize, minTitleSize, params.dpi, headerWidth, maxTitleLines)
+			if err != nil {
+				return nil, err
+			}
+			titleDrawer.Face = titleFace
+			logDebug("title fitted at %.1fpx on %d of %d lines", titleSize, len(titleLines), maxTitleLines)
+		case titleDrawer.MeasureString(titleText).Ceil() > headerWidth:
+			titleLines[0] = truncateWithEllipsis(titleText, headerWidth, titleDrawer)
+		}
+		layout.addText(titleFace, params.titleFont, titleSize, titleLines, headerX, cursorY, headerWidth, mirrorAlign(alignLeft, titleText))
+		cursorY += textBlockHeight(titleDrawer.Face, len(titleLines))
 		titleBottom = cursorY
 	}
 
-	// Show AdditionalInformation/DescriptionText between title and QR code
-	secondaryText := strings.TrimSpace(params.secondaryText)
-	if secondaryText != "" {
-		headerGap := maxInt(4, params.padding/2)
+	// Show AdditionalInformation/DescriptionText between title and QR code.
+	// A description block goes beside the code instead, or above it when
+	// there is only one column.
+	secondaryText := shapeArabic(strings.TrimSpace(params.secondaryText))
+	headerText := secondaryText
+	var blockLines []string
+	headerGap := maxInt(4, params.padding/2)
+	if params.descriptionBlock && !singleColumn {
+		headerText = ""
+		blockLines = wrapBlock(secondaryText, rightColWidth, 0, descDrawer)
+	}
+	if headerText != "" {
 		if cursorY > params.margin {
 			cursorY += headerGap
 		}
-		// Only truncate if text doesn't fit
-		if descDrawer.MeasureString(secondaryText).Ceil() > leftColWidth {
-			secondaryText = truncateWithEllipsis(secondaryText, leftColWidth, descDrawer)
+		secondaryLines := []string{secondaryText}
+		if params.descriptionBlock {
+			maxLines := 0
+			if !params.dynamicLength {
+				// Leave at least half of the remaining height to the code.
+				maxLines = maxInt(1, (params.height-params.margin-cursorY)/2/textBlockHeight(descFace, 1))
+			}
+			secondaryLines = wrapBlock(secondaryText, leftColWidth, maxLines, descDrawer)
+		} else if params.dynamicLength {
+			secondaryLines = wrapText(secondaryText, leftColWidth, descDrawer)
+		} else if descDrawer.MeasureString(secondaryText).Ceil() > leftColWidth {
+			// Only truncate if text doesn't fit
+			secondaryLines[0] = truncateWithEllipsis(secondaryText, leftColWidth, descDrawer)
+		}
+		layout.addText(descFace, params.descriptionFont, params.descriptionFontSize, secondaryLines, leftColX, cursorY, leftColWidth, mirrorAlign(alignLeft, secondaryText))
+		cursorY += textBlockHeight(descDrawer.Face, len(secondaryLines))
+	}
+
+	// Custom field rows use the small ID caption font. They go under the
+	// description in the right column, or under the header in a single
+	// column, where they take at most half of the remaining height.
+	fieldRows := fieldRowTexts(params.fields)
+	fieldDrawer := &font.Drawer{Face: idLabelFace}
+	fieldRowHeight := textBlockHeight(idLabelFace, 1)
+	headerRows := 0
+	if singleColumn && len(fieldRows) > 0 {
+		if cursorY > params.margin {
+			cursorY += headerGap
+		}
+		maxRows := len(fieldRows)
+		if !params.dynamicLength {
+			maxRows = (params.height - params.margin - cursorY) / 2 / fieldRowHeight
+		}
+		rows := fitFieldRows(fieldRows, maxRows, leftColWidth, fieldDrawer)
+		if len(rows) > 0 {
+			layout.addText(idLabelFace, params.descriptionFont, idLabelSize, rows, leftColX, cursorY, leftColWidth, mirrorAlign(alignLeft, rows[0]))
+			cursorY += textBlockHeight(idLabelFace, len(rows))
 		}
-		drawTextLines(descDrawer, []string{secondaryText}, leftColX, cursorY, leftColWidth, alignLeft)
-		cursorY += textBlockHeight(descDrawer.Face, 1)
+		headerRows = len(rows)
+		fieldRows = nil
 	}
 
-	if titleText != "" || secondaryText != "" {
+	if titleText != "" || headerText != "" || headerRows > 0 {
 		cursorY += params.padding
 	}
 
 	contentTop := cursorY
 
-	qr, err := qrcode.New(params.url, qrcode.Medium)
-	if err != nil {
-		logError("QR code creation failed: %v", err)
-		return nil, err
+	idText := strings.TrimSpace(params.idText)
+	idGap := maxInt(2, params.padding/2)
+	idLabelHeight := textBlockHeight(idLabelFace, 1)
+	idValueHeight := textBlockHeight(idValueFace, 1)
+	idBlockHeight := 0
+	if idText != "" {
+		idBlockHeight = idLabelHeight + idGap + idValueHeight
+	}
+	blockTop := titleBottom
+	if titleBottom > params.margin {
+		blockTop += headerGap
+	}
+
+	// Linear barcodes use the whole QR column but only half of QrSize as
+	// bar height.
+	linear := isLinearSymbology(params.barcode)
+	codeHeight := func(size int) int {
+		if linear {
+			return maxInt(1, size/2)
+		}
+		return size
 	}
-	qr.DisableBorder = true
 
-	availableHeight := params.height - params.margin - contentTop
-	if availableHeight < 1 {
-		availableHeight = 1
+	// A snapped QR code is shrunk to whole pixels per module before it is
+	// placed; the leftover widens the gap above it, or shortens the label in
+	// dynamic length mode.
+	codeData := codeValue(params)
+	codeOpts := params.codeOptions()
+	snapCode := func(size int) int {
+		if !codeOpts.snapQR || params.barcode != symbologyQR || size <= 0 {
+			return size
+		}
+		snapped, module, err := snapQRSize(codeData, codeOpts, size)
+		if err != nil {
+			// newCodeElement reports the error.
+			return size
+		}
+		logDebug("snapped QR code from %dpx to %dpx (%dpx per module)", size, snapped, module)
+		return snapped
 	}
+
+	labelHeight := params.height
 	qrSize := params.qrSize
-	if qrSize <= 0 {
-		qrSize = minInt(leftColWidth, availableHeight)
-	}
-	qrSize = minInt(qrSize, leftColWidth)
-	qrSize = minInt(qrSize, availableHeight)
-	if qrSize > 0 {
-		logDebug("rendering QR code: %dx%d at (%d,%d)", qrSize, qrSize, leftColX, params.height-params.margin-qrSize)
-		qrImg := qr.Image(qrSize)
-		qrX := leftColX
-		qrY := params.height - params.margin - qrSize
-		qrRect := image.Rect(qrX, qrY, qrX+qrSize, qrY+qrSize)
-		draw.Draw(img, qrRect, qrImg, image.Point{}, draw.Src)
+	qrY := 0
+	if params.dynamicLength {
+		// Width is the tape width; the length follows the content.
+		if qrSize <= 0 {
+			qrSize = leftColWidth
+		}
+		qrSize = snapCode(minInt(qrSize, leftColWidth))
+		if singleColumn {
+			labelHeight = contentTop + codeHeight(qrSize)
+			if idBlockHeight > 0 {
+				labelHeight += params.padding + idBlockHeight
+			}
+		} else {
+			labelHeight = maxInt(contentTop+codeHeight(qrSize), titleBottom+idBlockHeight)
+			if len(blockLines) > 0 || len(fieldRows) > 0 {
+				sideHeight := textBlockHeight(descFace, len(blockLines)) + fieldRowHeight*len(fieldRows)
+				labelHeight = maxInt(labelHeight, blockTop+sideHeight+params.padding+idBlockHeight)
+			}
+		}
+		labelHeight = maxInt(labelHeight+params.margin, 2*params.margin+1)
+		qrY = contentTop
+		if !singleColumn {
+			qrY = labelHeight - params.margin - codeHeight(qrSize)
+		}
+		logDebug("dynamic length: label height %d (requested %d)", labelHeight, params.height)
+		layout.height = labelHeight
 	} else {
-		logDebug("skipping QR code (size would be 0)")
+		availableHeight := params.height - params.margin - contentTop
+		if availableHeight < 1 {
+			availableHeight = 1
+		}
+		if qrSize <= 0 {
+			qrSize = minInt(leftColWidth, availableHeight)
+		}
+		qrSize = minInt(qrSize, leftColWidth)
+		qrSize = snapCode(minInt(qrSize, availableHeight))
+		qrY = params.height - params.margin - codeHeight(qrSize)
+	}
+	switch {
+	case qrSize <= 0:
+		logDebug("skipping %s code (size would be 0)", params.barcode)
+	case params.barcode != symbologyQR && strings.TrimSpace(codeData) == "":
+		logDebug("skipping %s code (nothing to encode)", params.barcode)
+	default:
+		codeWidth := qrSize
+		if linear {
+			codeWidth = leftColWidth
+		}
+		logDebug("rendering %s code: %dx%d at (%d,%d)", params.barcode, codeWidth, codeHeight(qrSize), leftColX, qrY)
+		code, err := newCodeElement(params.barcode, codeData, codeOpts, leftColX, qrY, codeWidth, codeHeight(qrSize))
+		if err != nil {
+			return nil, err
+		}
+		layout.add(code)
 	}
 
 	// Show ID label with extracted ID in bottom right
-	idText := strings.TrimSpace(params.idText)
-	idBlockHeight := 0
 	if idText != "" {
-		idLabelDrawer := &font.Drawer{Dst: img, Src: image.Black, Face: idLabelFace}
-		idValueDrawer := &font.Drawer{Dst: img, Src: image.Black, Face: idValueFace}
-		idGap := maxInt(2, params.padding/2)
-		idLabelHeight := textBlockHeight(idLabelFace, 1)
-		idValueHeight := textBlockHeight(idValueFace, 1)
-		idBlockHeight = idLabelHeight + idGap + idValueHeight
-		idTop := params.height - params.margin - idBlockHeight
-		drawTextLines(idLabelDrawer, []string{"ID"}, rightColX, idTop, rightColWidth, alignRight)
-		drawTextLines(idValueDrawer, []string{idText}, rightColX, idTop+idLabelHeight+idGap, rightColWidth, alignRight)
+		idTop := labelHeight - params.margin - idBlockHeight
+		layout.addText(idLabelFace, params.descriptionFont, idLabelSize, []string{"ID"}, rightColX, idTop, rightColWidth, alignRight)
+		layout.addText(idValueFace, params.titleFont, idValueSize, []string{idText}, rightColX, idTop+idLabelHeight+idGap, rightColWidth, alignRight)
 	}
 
 	iconAreaTop := contentTop
 	if !singleColumn {
 		iconAreaTop = titleBottom
 	}
-	iconAreaBottom := params.height - params.margin
+	iconAreaBottom := labelHeight - params.margin
 	if idBlockHeight > 0 {
-		iconAreaBottom = params.height - params.margin - idBlockHeight - params.padding
+		iconAreaBottom = labelHeight - params.margin - idBlockHeight - params.padding
+	}
+	sideY := blockTop
+	if len(blockLines) > 0 {
+		maxLines := (iconAreaBottom - sideY) / textBlockHeight(descFace, 1)
+		if maxLines < 1 {
+			logDebug("skipping description block (no available space: height=%d)", iconAreaBottom-sideY)
+		} else {
+			blockLines = wrapBlock(secondaryText, rightColWidth, maxLines, descDrawer)
+			logDebug("rendering description block: %d lines at (%d,%d)", len(blockLines), rightColX, sideY)
+			layout.addText(descFace, params.descriptionFont, params.descriptionFontSize, blockLines, rightColX, sideY, rightColWidth, mirrorAlign(alignLeft, secondaryText))
+			sideY += textBlockHeight(descFace, len(blockLines))
+		}
+	}
+	if len(fieldRows) > 0 {
+		rows := fitFieldRows(fieldRows, (iconAreaBottom-sideY)/fieldRowHeight, rightColWidth, fieldDrawer)
+		if len(rows) > 0 {
+			logDebug("rendering %d field rows at (%d,%d)", len(rows), rightColX, sideY)
+			layout.addText(idLabelFace, params.descriptionFont, idLabelSize, rows, rightColX, sideY, rightColWidth, mirrorAlign(alignLeft, rows[0]))
+			sideY += textBlockHeight(idLabelFace, len(rows))
+			iconAreaTop = sideY + params.padding
+		}
 	}
 	iconAreaHeight := iconAreaBottom - iconAreaTop
-	if iconAreaHeight > 0 && rightColWidth > 0 {
+	if params.icon == nil {
+		logDebug("skipping icon (Icon=none)")
+	} else if len(blockLines) > 0 {
+		logDebug("skipping icon (description block shown)")
+	} else if iconAreaHeight > 0 && rightColWidth > 0 {
 		iconSize := minInt(rightColWidth, iconAreaHeight)
 		if iconSize >= 12 {
 			iconX := rightColX + (rightColWidth-iconSize)/2
 			iconY := iconAreaTop + (iconAreaHeight-iconSize)/2
-			logDebug("rendering icon: %dx%d at (%d,%d)", iconSize, iconSize, iconX, iconY)
-			drawOpenBoxIcon(img, iconX, iconY, iconSize, iconSize)
+			logDebug("rendering icon %q: %dx%d at (%d,%d)", params.icon.name, iconSize, iconSize, iconX, iconY)
+			layout.add(labelElement{kind: elementIcon, x: iconX, y: iconY, width: iconSize, height: iconSize, icon: params.icon})
 		} else {
 			logDebug("skipping icon (size %d < minimum 12)", iconSize)
 		}
@@ -193,6 +382,5 @@ func renderLabel(params labelParams) (image.Image, error) {
 		logDebug("skipping icon (no available space: height=%d, width=%d)", iconAreaHeight, rightColWidth)
 	}
 
-	logDebug("label rendering completed successfully")
-	return img, nil
+	return layout, nil
 }
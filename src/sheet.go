@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// labelSheet describes a sheet of sticker labels for office printers. All
+// measurements are in millimetres from the top-left corner of the page.
+type labelSheet struct {
+	id            string
+	aliases       []string
+	description   string
+	pageWidthMM   float64
+	pageHeightMM  float64
+	columns       int
+	rows          int
+	labelWidthMM  float64
+	labelHeightMM float64
+	leftMM        float64
+	topMM         float64
+	pitchXMM      float64
+	pitchYMM      float64
+}
+
+const (
+	a4WidthMM      = 210.0
+	a4HeightMM     = 297.0
+	letterWidthMM  = 215.9
+	letterHeightMM = 279.4
+	maxSheetCopies = 1000
+)
+
+var labelSheetTable = []labelSheet{
+	{id: "L7159", aliases: []string{"J8159"}, description: "Avery A4, 24 labels 63.5x33.9mm", pageWidthMM: a4WidthMM, pageHeightMM: a4HeightMM, columns: 3, rows: 8, labelWidthMM: 63.5, labelHeightMM: 33.9, leftMM: 7.2, topMM: 12.9, pitchXMM: 66.0, pitchYMM: 33.9},
+	{id: "L7160", aliases: []string{"J8160"}, description: "Avery A4, 21 labels 63.5x38.1mm", pageWidthMM: a4WidthMM, pageHeightMM: a4HeightMM, columns: 3, rows: 7, labelWidthMM: 63.5, labelHeightMM: 38.1, leftMM: 7.2, topMM: 15.1, pitchXMM: 66.0, pitchYMM: 38.1},
+	{id: "L7163", aliases: []string{"J8163"}, description: "Avery A4, 14 labels 99.1x38.1mm", pageWidthMM: a4WidthMM, pageHeightMM: a4HeightMM, columns: 2, rows: 7, labelWidthMM: 99.1, labelHeightMM: 38.1, leftMM: 4.7, topMM: 15.1, pitchXMM: 101.6, pitchYMM: 38.1},
+	{id: "L7173", aliases: []string{"J8173"}, description: "Avery A4, 10 labels 99.1x57mm", pageWidthMM: a4WidthMM, pageHeightMM: a4HeightMM, columns: 2, rows: 5, labelWidthMM: 99.1, labelHeightMM: 57, leftMM: 4.7, topMM: 6, pitchXMM: 101.6, pitchYMM: 57},
+	{id: "L7651", aliases: []string{"J8651"}, description: "Avery A4, 65 labels 38.1x21.2mm", pageWidthMM: a4WidthMM, pageHeightMM: a4HeightMM, columns: 5, rows: 13, labelWidthMM: 38.1, labelHeightMM: 21.2, leftMM: 4.7, topMM: 10.7, pitchXMM: 40.6, pitchYMM: 21.2},
+	{id: "3422", aliases: []string{"A4-70X35"}, description: "Avery Zweckform A4, 24 labels 70x35mm", pageWidthMM: a4WidthMM, pageHeightMM: a4HeightMM, columns: 3, rows: 8, labelWidthMM: 70, labelHeightMM: 35, leftMM: 0, topMM: 8.5, pitchXMM: 70, pitchYMM: 35},
+	{id: "3474", aliases: []string{"A4-70X37"}, description: "Avery Zweckform A4, 24 labels 70x37mm", pageWidthMM: a4WidthMM, pageHeightMM: a4HeightMM, columns: 3, rows: 8, labelWidthMM: 70, labelHeightMM: 37, leftMM: 0, topMM: 0.5, pitchXMM: 70, pitchYMM: 37},
+	{id: "3475", aliases: []string{"A4-70X36"}, description: "Avery Zweckform A4, 24 labels 70x36mm", pageWidthMM: a4WidthMM, pageHeightMM: a4HeightMM, columns: 3, rows: 8, labelWidthMM: 70, labelHeightMM: 36, leftMM: 0, topMM: 4.5, pitchXMM: 70, pitchYMM: 36},
+	{id: "4360", description: "Herma A4, 24 labels 70x36mm", pageWidthMM: a4WidthMM, pageHeightMM: a4HeightMM, columns: 3, rows: 8, labelWidthMM: 70, labelHeightMM: 36, leftMM: 0, topMM: 4.5, pitchXMM: 70, pitchYMM: 36},
+	{id: "4609", description: "Herma A4, 65 labels 38.1x21.2mm", pageWidthMM: a4WidthMM, pageHeightMM: a4HeightMM, columns: 5, rows: 13, labelWidthMM: 38.1, labelHeightMM: 21.2, leftMM: 4.75, topMM: 10.7, pitchXMM: 40.6, pitchYMM: 21.2},
+	{id: "5160", aliases: []string{"8160"}, description: "Avery Letter, 30 labels 2.625x1in", pageWidthMM: letterWidthMM, pageHeightMM: letterHeightMM, columns: 3, rows: 10, labelWidthMM: 66.675, labelHeightMM: 25.4, leftMM: 4.7625, topMM: 12.7, pitchXMM: 69.85, pitchYMM: 25.4},
+	{id: "5163", aliases: []string{"8163"}, description: "Avery Letter, 10 labels 4x2in", pageWidthMM: letterWidthMM, pageHeightMM: letterHeightMM, columns: 2, rows: 5, labelWidthMM: 101.6, labelHeightMM: 50.8, leftMM: 4.7625, topMM: 12.7, pitchXMM: 104.775, pitchYMM: 50.8},
+	{id: "5167", aliases: []string{"8167"}, description: "Avery Letter, 80 labels 1.75x0.5in", pageWidthMM: letterWidthMM, pageHeightMM: letterHeightMM, columns: 4, rows: 20, labelWidthMM: 44.45, labelHeightMM: 12.7, leftMM: 7.62, topMM: 12.7, pitchXMM: 52.07, pitchYMM: 12.7},
+}
+
+func lookupSheet(name string) (*labelSheet, bool) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "AVERY-"), "HERMA-")
+	for i := range labelSheetTable {
+		sheet := &labelSheetTable[i]
+		if sheet.id == name {
+			return sheet, true
+		}
+		for _, alias := range sheet.aliases {
+			if alias == name {
+				return sheet, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func sheetIDs() []string {
+	ids := make([]string, 0, len(labelSheetTable))
+	for _, sheet := range labelSheetTable {
+		ids = append(ids, sheet.id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (s *labelSheet) capacity() int {
+	return s.columns * s.rows
+}
+
+// cellParams sizes a label to one cell of the sheet at the requested DPI.
+func (s *labelSheet) cellParams(params labelParams) labelParams {
+	perMM := dotsPerMM(params.dpi)
+	params.width = roundDots(s.labelWidthMM * perMM)
+	params.height = roundDots(s.labelHeightMM * perMM)
+	if params.dynamicLength {
+		logDebug("sheet %s has a fixed label size; ignoring DynamicLength", s.id)
+		params.dynamicLength = false
+	}
+	return params
+}
+
+// encodeSheet renders each label with renderLabel and places it on PDF pages
+// of the sheet, row by row, leaving the first skip positions empty. Identical
+// labels share one image.
+func encodeSheet(sheet *labelSheet, skip int, labels []labelParams, opts outputOptions, limit int) ([]byte, error) {
+	if skip < 0 || skip >= sheet.capacity() {
+		return nil, fmt.Errorf("skip %d out of range for %s (0-%d)", skip, sheet.id, sheet.capacity()-1)
+	}
+	doc := newPDFDocument()
+	// Keyed by the printed parameters, as labelParams holds slices.
+	images := map[string]string{}
+	const pt = 72 / 25.4
+	var content bytes.Buffer
+	for i, params := range labels {
+		position := skip + i
+		if position > skip && position%sheet.capacity() == 0 {
+			if err := doc.addRawPage(sheet.pageWidthMM*pt, sheet.pageHeightMM*pt, content.Bytes()); err != nil {
+				return nil, err
+			}
+			content.Reset()
+		}
+
+		key := fmt.Sprint(params)
+		name, ok := images[key]
+		if !ok {
+			img, err := renderLabel(params)
+			if err != nil {
+				return nil, err
+			}
+			if opts.mono {
+				img = monoBitmapFrom(img, opts.dither).paletted()
+			}
+			name, err = doc.addImage(img)
+			if err != nil {
+				return nil, err
+			}
+			if doc.written > limit {
+				return nil, errOutputTooLarge
+			}
+			images[key] = name
+		}
+
+		cell := position % sheet.capacity()
+		column, row := cell%sheet.columns, cell/sheet.columns
+		x := sheet.leftMM + float64(column)*sheet.pitchXMM
+		y := sheet.pageHeightMM - sheet.topMM - float64(row)*sheet.pitchYMM - sheet.labelHeightMM
+		fmt.Fprintf(&content, "q %s 0 0 %s %s %s cm /%s Do Q\n",
+			formatNumber(sheet.labelWidthMM*pt), formatNumber(sheet.labelHeightMM*pt), formatNumber(x*pt), formatNumber(y*pt), name)
+	}
+	if err := doc.addRawPage(sheet.pageWidthMM*pt, sheet.pageHeightMM*pt, content.Bytes()); err != nil {
+		return nil, err
+	}
+	pages := int(math.Ceil(float64(skip+len(labels)) / float64(sheet.capacity())))
+	logDebug("sheet %s: %d labels on %d pages (%d distinct, skip %d)", sheet.id, len(labels), pages, len(images), skip)
+	return doc.bytes()
+}
+
+// repeatLabels returns every label copies times in a row.
+func repeatLabels(labels []labelParams, copies int) []labelParams {
+	out := make([]labelParams, 0, len(labels)*copies)
+	for _, params := range labels {
+		for i := 0; i < copies; i++ {
+			out = append(out, params)
+		}
+	}
+	return out
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestCellParams(t *testing.T) {
+	tests := []struct {
+		sheet         string
+		dpi           float64
+		width, height int
+	}{
+		// 203 DPI snaps to 8 dots/mm, as for Width=63.5mm.
+		{"L7160", 203, 508, 305},
+		{"L7160", 300, 750, 450},
+		// 2.625 x 1 in is 787.5 x 300 dots at 300 DPI.
+		{"5160", 300, 788, 300},
+		{"5167", 203, 356, 102},
+	}
+	for _, tt := range tests {
+		sheet, ok := lookupSheet(tt.sheet)
+		if !ok {
+			t.Fatalf("sheet %s not found", tt.sheet)
+		}
+		params := sheet.cellParams(labelParams{dpi: tt.dpi, dynamicLength: true})
+		if params.width != tt.width || params.height != tt.height || params.dynamicLength {
+			t.Errorf("%s at %g DPI: %dx%d dynamic %v, want %dx%d", tt.sheet, tt.dpi, params.width, params.height, params.dynamicLength, tt.width, tt.height)
+		}
+	}
+}
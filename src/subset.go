@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"golang.org/x/image/font/sfnt"
+)
+
+// subset returns the font data to embed in PDF and SVG output for runes and
+// whether it is a subset. TrueType fonts are cut down to the glyphs the runes
+// need; OpenType fonts with CFF outlines are returned whole.
+func (f *labelFont) subset(runes []rune) ([]byte, bool) {
+	if f.isCFF() {
+		return f.data, false
+	}
+	ft, err := f.parse()
+	if err != nil {
+		return f.data, false
+	}
+	var buf sfnt.Buffer
+	cmap := map[rune]uint16{}
+	for _, r := range runes {
+		if gid, err := ft.GlyphIndex(&buf, r); err == nil && gid != 0 {
+			cmap[r] = uint16(gid)
+		}
+	}
+	data, err := subsetTrueType(f.data, cmap)
+	if err != nil {
+		logDebug("embedding font %q whole: %v", f.name, err)
+		return f.data, false
+	}
+	return data, true
+}
+
+// subsetTag returns the six-letter tag that prefixes the name of a subset
+// font in PDF, derived from the glyphs it contains.
+func subsetTag(gids []int) string {
+	h := uint32(2166136261)
+	for _, gid := range gids {
+		h = (h ^ uint32(gid)) * 16777619
+	}
+	tag := make([]byte, 6)
+	for i := range tag {
+		tag[i] = byte('A' + h%26)
+		h /= 26
+	}
+	return string(tag)
+}
+
+// subsetTables are the TrueType tables kept in a subset. Layout tables,
+// kerning and device metrics refer to dropped glyphs and are not needed
+// because every glyph is positioned explicitly.
+var subsetTables = []string{"OS/2", "cmap", "cvt ", "fpgm", "gasp", "glyf", "head", "hhea", "hmtx", "loca", "maxp", "name", "post", "prep"}
+
+// subsetTrueType keeps the glyphs in cmap, the .notdef glyph and the
+// components of composite glyphs. Glyph indices do not change, so the PDF
+// can keep using them as CIDs; glyphs above the highest one kept are cut
+// off and the others are left empty.
+func subsetTrueType(data []byte, cmap map[rune]uint16) ([]byte, error) {
+	tables, err := sfntTables(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range []string{"head", "hhea", "hmtx", "loca", "maxp", "glyf", "post"} {
+		if tables[tag] == nil {
+			return nil, fmt.Errorf("missing %s table", tag)
+		}
+	}
+	head, hhea, maxp := tables["head"], tables["hhea"], tables["maxp"]
+	if len(head) < 54 || len(hhea) < 36 || len(maxp) < 6 || len(tables["post"]) < 32 {
+		return nil, errors.New("truncated font tables")
+	}
+	numGlyphs := int(binary.BigEndian.Uint16(maxp[4:]))
+	glyphs, err := sfntGlyphs(tables["glyf"], tables["loca"], numGlyphs, binary.BigEndian.Uint16(head[50:]) != 0)
+	if err != nil {
+		return nil, err
+	}
+
+	keep := map[int]bool{0: true}
+	queue := []int{0}
+	for _, gid := range cmap {
+		if int(gid) < numGlyphs && !keep[int(gid)] {
+			keep[int(gid)] = true
+			queue = append(queue, int(gid))
+		}
+	}
+	last := 0
+	for len(queue) > 0 {
+		gid := queue[0]
+		queue = queue[1:]
+		last = maxInt(last, gid)
+		components, err := glyphComponents(glyphs[gid])
+		if err != nil {
+			return nil, fmt.Errorf("glyph %d: %w", gid, err)
+		}
+		for _, c := range components {
+			if c >= numGlyphs {
+				return nil, fmt.Errorf("glyph %d: component %d out of range", gid, c)
+			}
+			if !keep[c] {
+				keep[c] = true
+				queue = append(queue, c)
+			}
+		}
+	}
+	numGlyphs = last + 1
+
+	var glyf bytes.Buffer
+	loca := make([]byte, 4*(numGlyphs+1))
+	for gid := 0; gid < numGlyphs; gid++ {
+		if keep[gid] {
+			glyf.Write(glyphs[gid])
+			for glyf.Len()%4 != 0 {
+				glyf.WriteByte(0)
+			}
+		}
+		binary.BigEndian.PutUint32(loca[4*(gid+1):], uint32(glyf.Len()))
+	}
+
+	// hmtx holds numberOfHMetrics full metrics followed by left side
+	// bearings for the remaining glyphs.
+	metrics := int(binary.BigEndian.Uint16(hhea[34:]))
+	if len(tables["hmtx"]) < 4*metrics+2*(int(binary.BigEndian.Uint16(maxp[4:]))-metrics) {
+		return nil, errors.New("truncated hmtx table")
+	}
+	hmtx := tables["hmtx"][:4*minInt(metrics, numGlyphs)]
+	if numGlyphs > metrics {
+		hmtx = append(append([]byte(nil), hmtx...), tables["hmtx"][4*metrics:4*metrics+2*(numGlyphs-metrics)]...)
+	}
+	metrics = minInt(metrics, numGlyphs)
+
+	head = append([]byte(nil), head...)
+	binary.BigEndian.PutUint32(head[8:], 0)
+	binary.BigEndian.PutUint16(head[50:], 1)
+	hhea = append([]byte(nil), hhea...)
+	binary.BigEndian.PutUint16(hhea[34:], uint16(metrics))
+	maxp = append([]byte(nil), maxp...)
+	binary.BigEndian.PutUint16(maxp[4:], uint16(numGlyphs))
+	// Version 3 post tables carry no glyph names.
+	post := append([]byte(nil), tables["post"][:32]...)
+	binary.BigEndian.PutUint32(post, 0x00030000)
+
+	out := map[string][]byte{
+		"cmap": subsetCmap(cmap),
+		"glyf": glyf.Bytes(),
+		"head": head,
+		"hhea": hhea,
+		"hmtx": hmtx,
+		"loca": loca,
+		"maxp": maxp,
+		"post": post,
+	}
+	for _, tag := range subsetTables {
+		if out[tag] == nil && tables[tag] != nil {
+			out[tag] = tables[tag]
+		}
+	}
+	font := writeSFNT(out)
+	binary.BigEndian.PutUint32(font[sfntTableOffset(font, "head")+8:], 0xB1B0AFBA-sfntChecksum(font))
+	return font, nil
+}
+
+// sfntTables returns the tables of a font keyed by tag.
+func sfntTables(data []byte) (map[string][]byte, error) {
+	if len(data) < 12 {
+		return nil, errors.New("truncated font")
+	}
+	count := int(binary.BigEndian.Uint16(data[4:]))
+	if len(data) < 12+16*count {
+		return nil, errors.New("truncated table directory")
+	}
+	tables := map[string][]byte{}
+	for i := 0; i < count; i++ {
+		record := data[12+16*i:]
+		offset, length := binary.BigEndian.Uint32(record[8:]), binary.BigEndian.Uint32(record[12:])
+		if uint64(offset)+uint64(length) > uint64(len(data)) {
+			return nil, fmt.Errorf("table %q out of range", record[:4])
+		}
+		tables[string(record[:4])] = data[offset : offset+length]
+	}
+	return tables, nil
+}
+
+// sfntGlyphs splits the glyf table into the data of each glyph.
+func sfntGlyphs(glyf, loca []byte, numGlyphs int, longOffsets bool) ([][]byte, error) {
+	offset := func(i int) int {
+		if longOffsets {
+			return int(binary.BigEndian.Uint32(loca[4*i:]))
+		}
+		return 2 * int(binary.BigEndian.Uint16(loca[2*i:]))
+	}
+	size := 2
+	if longOffsets {
+		size = 4
+	}
+	if len(loca) < size*(numGlyphs+1) {
+		return nil, errors.New("truncated loca table")
+	}
+	glyphs := make([][]byte, numGlyphs)
+	for i := range glyphs {
+		start, end := offset(i), offset(i+1)
+		if start > end || end > len(glyf) {
+			return nil, fmt.Errorf("glyph %d out of range", i)
+		}
+		glyphs[i] = glyf[start:end]
+	}
+	return glyphs, nil
+}
+
+// glyphComponents returns the glyphs a composite glyph is built from.
+func glyphComponents(glyph []byte) ([]int, error) {
+	if len(glyph) < 10 || int16(binary.BigEndian.Uint16(glyph)) >= 0 {
+		return nil, nil
+	}
+	const (
+		argsAreWords  = 0x0001
+		haveScale     = 0x0008
+		moreComponent = 0x0020
+		haveXYScale   = 0x0040
+		haveTwoByTwo  = 0x0080
+	)
+	var components []int
+	for pos := 10; ; {
+		if pos+4 > len(glyph) {
+			return nil, errors.New("truncated composite glyph")
+		}
+		flags := binary.BigEndian.Uint16(glyph[pos:])
+		components = append(components, int(binary.BigEndian.Uint16(glyph[pos+2:])))
+		pos += 4
+		if flags&argsAreWords != 0 {
+			pos += 4
+		} else {
+			pos += 2
+		}
+		switch {
+		case flags&haveScale != 0:
+			pos += 2
+		case flags&haveXYScale != 0:
+			pos += 4
+		case flags&haveTwoByTwo != 0:
+			pos += 8
+		}
+		if flags&moreComponent == 0 {
+			return components, nil
+		}
+	}
+}
+
+// subsetCmap builds a cmap with a format 4 subtable for the Basic
+// Multilingual Plane and a format 12 subtable for all runes.
+func subsetCmap(cmap map[rune]uint16) []byte {
+	runes := make([]rune, 0, len(cmap))
+	for r := range cmap {
+		runes = append(runes, r)
+	}
+	sort.Slice(runes, func(i, j int) bool { return runes[i] < runes[j] })
+
+	// Format 4 with one segment per rune, plus the final 0xFFFF segment.
+	var bmp []rune
+	for _, r := range runes {
+		if r < 0xFFFF {
+			bmp = append(bmp, r)
+		}
+	}
+	segments := len(bmp) + 1
+	format4 := make([]byte, 16+8*segments)
+	binary.BigEndian.PutUint16(format4[0:], 4)
+	binary.BigEndian.PutUint16(format4[2:], uint16(len(format4)))
+	binary.BigEndian.PutUint16(format4[6:], uint16(2*segments))
+	searchRange, selector := searchParams(segments)
+	binary.BigEndian.PutUint16(format4[8:], uint16(2*searchRange))
+	binary.BigEndian.PutUint16(format4[10:], uint16(selector))
+	binary.BigEndian.PutUint16(format4[12:], uint16(2*(segments-searchRange)))
+	ends, starts, deltas := format4[14:], format4[16+2*segments:], format4[16+4*segments:]
+	for i, r := range bmp {
+		binary.BigEndian.PutUint16(ends[2*i:], uint16(r))
+		binary.BigEndian.PutUint16(starts[2*i:], uint16(r))
+		binary.BigEndian.PutUint16(deltas[2*i:], cmap[r]-uint16(r))
+	}
+	binary.BigEndian.PutUint16(ends[2*len(bmp):], 0xFFFF)
+	binary.BigEndian.PutUint16(starts[2*len(bmp):], 0xFFFF)
+	binary.BigEndian.PutUint16(deltas[2*len(bmp):], 1)
+
+	format12 := make([]byte, 16+12*len(runes))
+	binary.BigEndian.PutUint16(format12[0:], 12)
+	binary.BigEndian.PutUint32(format12[4:], uint32(len(format12)))
+	binary.BigEndian.PutUint32(format12[12:], uint32(len(runes)))
+	for i, r := range runes {
+		group := format12[16+12*i:]
+		binary.BigEndian.PutUint32(group[0:], uint32(r))
+		binary.BigEndian.PutUint32(group[4:], uint32(r))
+		binary.BigEndian.PutUint32(group[8:], uint32(cmap[r]))
+	}
+
+	header := make([]byte, 4+8*2)
+	binary.BigEndian.PutUint16(header[2:], 2)
+	binary.BigEndian.PutUint16(header[4:], 3)
+	binary.BigEndian.PutUint16(header[6:], 1)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(header)))
+	binary.BigEndian.PutUint16(header[12:], 3)
+	binary.BigEndian.PutUint16(header[14:], 10)
+	binary.BigEndian.PutUint32(header[16:], uint32(len(header)+len(format4)))
+	return append(append(header, format4...), format12...)
+}
+
+// searchParams returns the largest power of two not above n and its log2,
+// as used by binary search headers in sfnt tables.
+func searchParams(n int) (int, int) {
+	power, log := 1, 0
+	for power*2 <= n {
+		power *= 2
+		log++
+	}
+	return power, log
+}
+
+// writeSFNT assembles a TrueType font from its tables, sorted by tag.
+func writeSFNT(tables map[string][]byte) []byte {
+	tags := make([]string, 0, len(tables))
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	power, log := searchParams(len(tags))
+	header := make([]byte, 12+16*len(tags))
+	binary.BigEndian.PutUint32(header[0:], 0x00010000)
+	binary.BigEndian.PutUint16(header[4:], uint16(len(tags)))
+	binary.BigEndian.PutUint16(header[6:], uint16(16*power))
+	binary.BigEndian.PutUint16(header[8:], uint16(log))
+	binary.BigEndian.PutUint16(header[10:], uint16(16*(len(tags)-power)))
+
+	out := bytes.NewBuffer(header)
+	for i, tag := range tags {
+		table := tables[tag]
+		record := out.Bytes()[12+16*i:]
+		copy(record, tag)
+		binary.BigEndian.PutUint32(record[4:], sfntChecksum(table))
+		binary.BigEndian.PutUint32(record[8:], uint32(out.Len()))
+		binary.BigEndian.PutUint32(record[12:], uint32(len(table)))
+		out.Write(table)
+		for out.Len()%4 != 0 {
+			out.WriteByte(0)
+		}
+	}
+	return out.Bytes()
+}
+
+func sfntTableOffset(font []byte, tag string) int {
+	count := int(binary.BigEndian.Uint16(font[4:]))
+	for i := 0; i < count; i++ {
+		record := font[12+16*i:]
+		if string(record[:4]) == tag {
+			return int(binary.BigEndian.Uint32(record[8:]))
+		}
+	}
+	return -1
+}
+
+// sfntChecksum sums data as big-endian 32-bit words, zero-padded.
+func sfntChecksum(data []byte) uint32 {
+	var sum uint32
+	for i := 0; i < len(data); i += 4 {
+		var word [4]byte
+		copy(word[:], data[i:])
+		sum += binary.BigEndian.Uint32(word[:])
+	}
+	return sum
+}
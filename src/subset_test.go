@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// glyphOutline returns the outline and advance of r in f at 1000 units per
+// em, or nil segments if f has no glyph for it.
+func glyphOutline(t *testing.T, f *sfnt.Font, r rune) (sfnt.GlyphIndex, sfnt.Segments, fixed.Int26_6) {
+	t.Helper()
+	var buf sfnt.Buffer
+	gid, err := f.GlyphIndex(&buf, r)
+	if err != nil {
+		t.Fatalf("GlyphIndex(%q): %v", r, err)
+	}
+	if gid == 0 {
+		return 0, nil, 0
+	}
+	segments, err := f.LoadGlyph(&buf, gid, fixed.I(1000), nil)
+	if err != nil {
+		t.Fatalf("LoadGlyph(%q): %v", r, err)
+	}
+	advance, err := f.GlyphAdvance(&buf, gid, fixed.I(1000), font.HintingNone)
+	if err != nil {
+		t.Fatalf("GlyphAdvance(%q): %v", r, err)
+	}
+	return gid, append(sfnt.Segments(nil), segments...), advance
+}
+
+// checkSubset checks that subset is a valid font drawing the runes in keep
+// exactly like full and has no glyphs for the runes in drop.
+func checkSubset(t *testing.T, full, subset []byte, keep, drop string) {
+	t.Helper()
+	if sum := sfntChecksum(subset); sum != 0xB1B0AFBA {
+		t.Errorf("font checksum %#x", sum)
+	}
+	tables, err := sfntTables(subset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(tables); i++ {
+		record := subset[12+16*i:]
+		tag := string(record[:4])
+		if tag != "head" && binary.BigEndian.Uint32(record[4:]) != sfntChecksum(tables[tag]) {
+			t.Errorf("%s table checksum mismatch", tag)
+		}
+	}
+
+	want, err := sfnt.Parse(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := sfnt.Parse(subset)
+	if err != nil {
+		t.Fatalf("parse subset: %v", err)
+	}
+	for _, r := range keep {
+		wantGID, wantOutline, wantAdvance := glyphOutline(t, want, r)
+		gotGID, gotOutline, gotAdvance := glyphOutline(t, got, r)
+		if gotGID != wantGID {
+			t.Errorf("%q: glyph %d, want %d", r, gotGID, wantGID)
+		}
+		if !reflect.DeepEqual(gotOutline, wantOutline) || gotAdvance != wantAdvance {
+			t.Errorf("%q: outline or advance differs from the full font", r)
+		}
+	}
+	for _, r := range drop {
+		if gid, _, _ := glyphOutline(t, got, r); gid != 0 {
+			t.Errorf("%q: subset still maps it to glyph %d", r, gid)
+		}
+	}
+
+	// Readers prefer the format 12 subtable; drop it to check format 4.
+	bmpOnly := append([]byte(nil), subset...)
+	binary.BigEndian.PutUint16(bmpOnly[sfntTableOffset(bmpOnly, "cmap")+2:], 1)
+	bmp, err := sfnt.Parse(bmpOnly)
+	if err != nil {
+		t.Fatalf("parse subset with format 4 cmap: %v", err)
+	}
+	var buf sfnt.Buffer
+	for _, r := range keep + drop {
+		wantGID, _ := got.GlyphIndex(&buf, r)
+		if gid, err := bmp.GlyphIndex(&buf, r); err != nil || gid != wantGID {
+			t.Errorf("%q: format 4 maps to glyph %d, want %d", r, gid, wantGID)
+		}
+	}
+}
+
+func TestSubsetTrueType(t *testing.T) {
+	tests := []struct {
+		name string
+		font *labelFont
+		keep string
+		drop string
+	}{
+		{"regular", fontRegular, "Zahnstange 0-9 ÄÖÜß", "QxЖ"},
+		{"bold", fontBold, "Box 1 (18V) é", "qwЯ"},
+		{"single rune", fontRegular, "A", "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, ok := tt.font.subset([]rune(tt.keep))
+			if !ok {
+				t.Fatal("font was not subset")
+			}
+			if len(data) > len(tt.font.data)/4 {
+				t.Errorf("subset is %d bytes, full font %d", len(data), len(tt.font.data))
+			}
+			checkSubset(t, tt.font.data, data, tt.keep, tt.drop)
+		})
+	}
+}
+
+func TestSubsetTrueTypeComposite(t *testing.T) {
+	// Rebuild Go Regular with é as a composite of e and the acute accent,
+	// so subsetting has to follow the component references.
+	tables, err := sfntTables(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	numGlyphs := int(binary.BigEndian.Uint16(tables["maxp"][4:]))
+	glyphs, err := sfntGlyphs(tables["glyf"], tables["loca"], numGlyphs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := sfnt.Parse(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf sfnt.Buffer
+	gid := func(r rune) int {
+		g, err := full.GlyphIndex(&buf, r)
+		if err != nil || g == 0 {
+			t.Fatalf("no glyph for %q", r)
+		}
+		return int(g)
+	}
+	composite := []byte{0xFF, 0xFF}
+	composite = append(composite, glyphs[gid('e')][2:10]...)
+	for i, component := range []int{gid('e'), gid('´')} {
+		// ARG_1_AND_2_ARE_WORDS | ARGS_ARE_XY_VALUES, with MORE_COMPONENTS
+		// on all but the last, and a zero offset.
+		flags := uint16(0x0003)
+		if i == 0 {
+			flags |= 0x0020
+		}
+		composite = binary.BigEndian.AppendUint16(composite, flags)
+		composite = binary.BigEndian.AppendUint16(composite, uint16(component))
+		composite = append(composite, 0, 0, 0, 0)
+	}
+	glyphs[gid('é')] = composite
+
+	var glyf []byte
+	loca := make([]byte, 4*(numGlyphs+1))
+	for i, glyph := range glyphs {
+		glyf = append(glyf, glyph...)
+		for len(glyf)%4 != 0 {
+			glyf = append(glyf, 0)
+		}
+		binary.BigEndian.PutUint32(loca[4*(i+1):], uint32(len(glyf)))
+	}
+	head := append([]byte(nil), tables["head"]...)
+	binary.BigEndian.PutUint16(head[50:], 1)
+	tables["glyf"], tables["loca"], tables["head"] = glyf, loca, head
+	data := writeSFNT(tables)
+
+	subset, err := subsetTrueType(data, map[rune]uint16{'é': uint16(gid('é'))})
+	if err != nil {
+		t.Fatalf("subsetTrueType: %v", err)
+	}
+	out, err := sfntTables(subset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept, err := sfntGlyphs(out["glyf"], out["loca"], int(binary.BigEndian.Uint16(out["maxp"][4:])), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range "e´" {
+		if len(kept[gid(r)]) == 0 {
+			t.Errorf("component %q was dropped", r)
+		}
+	}
+	if len(kept[gid('a')]) != 0 {
+		t.Error("unused glyph a was kept")
+	}
+	checkSubset(t, data, subset, "é", "ea")
+}
+
+func TestGlyphComponents(t *testing.T) {
+	simple := []byte{0, 1, 0, 0, 0, 0, 0, 10, 0, 10}
+	if got, err := glyphComponents(simple); err != nil || got != nil {
+		t.Errorf("simple glyph: %v, %v", got, err)
+	}
+	composite := []byte{0xFF, 0xFF, 0, 0, 0, 0, 0, 0, 0, 0,
+		0x00, 0x28, 0x00, 0x05, 1, 2, 0x40, 0x00, // byte args, scale, more
+		0x00, 0x61, 0x00, 0x07, 0, 1, 0, 2, 0, 0, 0, 0, // word args, x/y scale, more
+		0x00, 0x80, 0x00, 0x09, 1, 2, 0, 0, 0, 0, 0, 0, 0, 0, // 2x2 matrix
+	}
+	if got, err := glyphComponents(composite); err != nil || !reflect.DeepEqual(got, []int{5, 7, 9}) {
+		t.Errorf("composite glyph: %v, %v", got, err)
+	}
+	if _, err := glyphComponents(composite[:20]); err == nil {
+		t.Error("truncated composite glyph accepted")
+	}
+}
+
+func TestSubsetCFFWhole(t *testing.T) {
+	f := &labelFont{name: "cff", data: append([]byte("OTTO"), gobold.TTF[4:]...)}
+	if data, ok := f.subset([]rune("A")); ok || len(data) != len(f.data) {
+		t.Error("CFF font was subset")
+	}
+}
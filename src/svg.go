@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"strings"
+)
+
+// encodeSVG writes the layout as a resolution-independent SVG document. Text
+// keeps the raster glyph positions and uses the embedded label fonts.
+func encodeSVG(layout *labelLayout) ([]byte, error) {
+	if layout.dpi <= 0 {
+		return nil, errors.New("invalid dpi")
+	}
+
+	var body bytes.Buffer
+	fonts := &svgFonts{runes: map[*labelFont][]rune{}}
+	for _, el := range layout.elements {
+		switch el.kind {
+		case elementText:
+			writeSVGText(&body, layout, el, fonts)
+		case elementQR:
+			writeSVGMatrix(&body, el.qr.Bitmap(), float64(el.x), float64(el.y), float64(el.width))
+		case elementMatrix:
+			module, offset := matrixModule(len(el.matrix), el.width)
+			writeSVGMatrix(&body, el.matrix, float64(el.x+offset), float64(el.y+offset), float64(module*len(el.matrix)))
+		case elementIcon:
+			if el.icon.image != nil {
+				if err := writeSVGImage(&body, el.icon.scaled(el.width, el.height), el.x, el.y); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			writeSVGSegments(&body, el.icon.segments(el.x, el.y, el.width, el.height), iconThickness(el.width))
+		case elementBarcode:
+			module := barcodeModuleWidth(el.modules, el.width)
+			offsetX := el.x + maxInt(0, (el.width-module*len(el.modules))/2)
+			body.WriteString("<g fill=\"#000\">\n")
+			for _, run := range darkRuns(el.modules) {
+				fmt.Fprintf(&body, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\"/>\n",
+					offsetX+run[0]*module, el.y, (run[1]-run[0])*module, el.height)
+			}
+			body.WriteString("</g>\n")
+		case elementLine:
+			writeSVGSegments(&body, []lineSegment{{el.x, el.y, el.x2, el.y2}}, el.thickness)
+		case elementBox:
+			if el.filled {
+				fmt.Fprintf(&body, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"#000\"/>\n", el.x, el.y, el.width, el.height)
+				continue
+			}
+			t := float64(maxInt(1, el.thickness))
+			fmt.Fprintf(&body, "<rect x=\"%s\" y=\"%s\" width=\"%s\" height=\"%s\" fill=\"none\" stroke=\"#000\" stroke-width=\"%s\"/>\n",
+				formatNumber(float64(el.x)+t/2), formatNumber(float64(el.y)+t/2), formatNumber(float64(el.width)-t), formatNumber(float64(el.height)-t), formatNumber(t))
+		}
+	}
+
+	width, height := layout.outputSize()
+	var out bytes.Buffer
+	out.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprintf(&out, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%sin\" height=\"%sin\" viewBox=\"0 0 %d %d\">\n",
+		formatNumber(float64(width)/layout.dpi), formatNumber(float64(height)/layout.dpi), width, height)
+	if len(fonts.fonts) > 0 {
+		out.WriteString("<defs><style>\n")
+		for _, f := range fonts.fonts {
+			mimeType, format := "font/ttf", "truetype"
+			if f.isCFF() {
+				mimeType, format = "font/otf", "opentype"
+			}
+			// subset falls back to the whole font when it cannot cut it down.
+			data, _ := f.subset(fonts.runes[f])
+			fmt.Fprintf(&out, "@font-face { font-family: %s; src: url(data:%s;base64,%s) format(\"%s\"); }\n",
+				xmlEscape(cssString(f.name)), mimeType, base64.StdEncoding.EncodeToString(data), format)
+		}
+		out.WriteString("</style></defs>\n")
+	}
+	fmt.Fprintf(&out, "<rect width=\"%d\" height=\"%d\" fill=\"#fff\"/>\n", width, height)
+	if layout.rotation != 0 {
+		m := layout.rotationMatrix()
+		fmt.Fprintf(&out, "<g transform=\"matrix(%d %d %d %d %d %d)\">\n", m[0], m[1], m[2], m[3], m[4], m[5])
+		out.Write(body.Bytes())
+		out.WriteString("</g>\n")
+	} else {
+		out.Write(body.Bytes())
+	}
+	out.WriteString("</svg>\n")
+	return out.Bytes(), nil
+}
+
+// svgFonts collects the fonts a document uses, in order of first use, and
+// the runes drawn with each so the embedded fonts can be subset.
+type svgFonts struct {
+	fonts []*labelFont
+	runes map[*labelFont][]rune
+}
+
+func (u *svgFonts) add(f *labelFont, runes []rune) {
+	if _, ok := u.runes[f]; !ok {
+		u.fonts = append(u.fonts, f)
+	}
+	u.runes[f] = append(u.runes[f], runes...)
+}
+
+// writeSVGText writes one <text> per line and font run and records the fonts
+// it used.
+func writeSVGText(b *bytes.Buffer, layout *labelLayout, el labelElement, used *svgFonts) {
+	for _, line := range placeTextLines(el, layout.height) {
+		for _, run := range line.runs() {
+			f := line.fonts[run[0]]
+			used.add(f, line.runes[run[0]:run[1]])
+			xs := make([]string, 0, run[1]-run[0])
+			for _, x := range line.x[run[0]:run[1]] {
+				xs = append(xs, formatFixed(x))
+			}
+			fmt.Fprintf(b, "<text x=\"%s\" y=\"%d\" font-family=\"%s\" font-size=\"%s\" xml:space=\"preserve\">",
+				strings.Join(xs, " "), line.baseline, xmlEscape(cssString(f.name)), formatNumber(el.fontSize))
+			b.WriteString(xmlEscape(string(line.runes[run[0]:run[1]])))
+			b.WriteString("</text>\n")
+		}
+	}
+}
+
+func writeSVGMatrix(b *bytes.Buffer, bitmap [][]bool, x, y, size float64) {
+	if len(bitmap) == 0 {
+		return
+	}
+	module := size / float64(len(bitmap))
+	b.WriteString("<g fill=\"#000\" shape-rendering=\"crispEdges\">\n")
+	for row, cells := range bitmap {
+		for _, run := range darkRuns(cells) {
+			fmt.Fprintf(b, "<rect x=\"%s\" y=\"%s\" width=\"%s\" height=\"%s\"/>\n",
+				formatNumber(x+float64(run[0])*module), formatNumber(y+float64(row)*module),
+				formatNumber(float64(run[1]-run[0])*module), formatNumber(module))
+		}
+	}
+	b.WriteString("</g>\n")
+}
+
+func writeSVGSegments(b *bytes.Buffer, segments []lineSegment, thickness int) {
+	if len(segments) == 0 {
+		return
+	}
+	// drawThickPoint centres odd brushes half a pixel right/down.
+	offset := 0.5 * float64(thickness%2)
+	var d strings.Builder
+	for _, seg := range segments {
+		fmt.Fprintf(&d, "M%s %sL%s %s",
+			formatNumber(float64(seg.x0)+offset), formatNumber(float64(seg.y0)+offset),
+			formatNumber(float64(seg.x1)+offset), formatNumber(float64(seg.y1)+offset))
+	}
+	fmt.Fprintf(b, "<path d=\"%s\" fill=\"none\" stroke=\"#000\" stroke-width=\"%d\" stroke-linecap=\"square\"/>\n", d.String(), thickness)
+}
+
+// writeSVGImage embeds img as a PNG data URI.
+func writeSVGImage(b *bytes.Buffer, img image.Image, x, y int) error {
+	var data bytes.Buffer
+	if err := png.Encode(&data, img); err != nil {
+		return err
+	}
+	bounds := img.Bounds()
+	fmt.Fprintf(b, "<image x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" href=\"data:image/png;base64,%s\"/>\n",
+		x, y, bounds.Dx(), bounds.Dy(), base64.StdEncoding.EncodeToString(data.Bytes()))
+	return nil
+}
+
+func xmlEscape(value string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(value))
+	return b.String()
+}
+
+// cssString quotes value as a CSS string. Font names come from FONT_DIR file
+// names, so quotes, backslashes and control characters are escaped.
+func cssString(value string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range value {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, "\\%x ", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
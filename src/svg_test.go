@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSSString(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Go-Regular", `"Go-Regular"`},
+		{"DejaVu Sans, Bold", `"DejaVu Sans, Bold"`},
+		{`My "Font"`, `"My \"Font\""`},
+		{`a\b`, `"a\\b"`},
+		{"a\nb", `"a\a b"`},
+		{"tab\there\x7f", `"tab\9 here\7f "`},
+		{"Größe", `"Größe"`},
+		// Go quoting writes \u200b for the zero width space, which CSS
+		// reads as "u200b".
+		{"a\u200bb", "\"a\u200bb\""},
+	}
+	for _, tt := range tests {
+		if got := cssString(tt.in); got != tt.want {
+			t.Errorf("cssString(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeSVGFontFamily(t *testing.T) {
+	params, err := parseLabelParams(url.Values{"TitleText": {"Drill"}, "URL": {"https://x.example/item/1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout, err := layoutLabel(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := encodeSVG(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The @font-face rule and the text elements name the font the same way.
+	var doc struct {
+		Style string `xml:"defs>style"`
+		Text  []struct {
+			Family string `xml:"font-family,attr"`
+		} `xml:"text"`
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Text) == 0 {
+		t.Fatal("no text elements")
+	}
+	for _, text := range doc.Text {
+		if !strings.Contains(doc.Style, "font-family: "+text.Family+";") {
+			t.Errorf("font-family %s has no @font-face rule in\n%.200s", text.Family, doc.Style)
+		}
+	}
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// curveSteps is the number of straight segments used for each curve.
+const curveSteps = 12
+
+// parseSVGIcon reads a simple SVG icon as polylines in a unit square. Only
+// the geometry of path, line, polyline, polygon, rect, circle and ellipse
+// elements is used; every shape is drawn as an outline, so fill, stroke and
+// colours are ignored. Transforms are not supported.
+func parseSVGIcon(data []byte) ([]iconPath, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var paths []iconPath
+	var viewBox []float64
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "defs", "clipPath", "mask", "symbol", "pattern", "marker":
+			// Only shapes that are drawn directly are used.
+			if err := decoder.Skip(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		attrs := map[string]string{}
+		for _, attr := range start.Attr {
+			attrs[attr.Name.Local] = attr.Value
+		}
+		if _, ok := attrs["transform"]; ok {
+			return nil, fmt.Errorf("<%s>: transforms are not supported", start.Name.Local)
+		}
+		shapes, err := svgShape(start.Name.Local, attrs)
+		if err != nil {
+			return nil, fmt.Errorf("<%s>: %w", start.Name.Local, err)
+		}
+		paths = append(paths, shapes...)
+		if start.Name.Local == "svg" && viewBox == nil {
+			viewBox, err = svgViewBox(attrs)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(paths) == 0 {
+		return nil, errors.New("svg has no supported shapes")
+	}
+	if viewBox == nil {
+		viewBox = pathBounds(paths)
+	}
+	return normalizePaths(paths, viewBox), nil
+}
+
+// svgViewBox returns the viewBox as x, y, width and height, falling back to
+// the width and height attributes.
+func svgViewBox(attrs map[string]string) ([]float64, error) {
+	if value, ok := attrs["viewBox"]; ok {
+		box, err := svgNumbers(value)
+		if err != nil || len(box) != 4 || box[2] <= 0 || box[3] <= 0 {
+			return nil, fmt.Errorf("invalid viewBox %q", value)
+		}
+		return box, nil
+	}
+	w, wErr := strconv.ParseFloat(strings.TrimSuffix(attrs["width"], "px"), 64)
+	h, hErr := strconv.ParseFloat(strings.TrimSuffix(attrs["height"], "px"), 64)
+	if wErr != nil || hErr != nil || w <= 0 || h <= 0 {
+		return nil, nil
+	}
+	return []float64{0, 0, w, h}, nil
+}
+
+func svgShape(name string, attrs map[string]string) ([]iconPath, error) {
+	num := func(key string) float64 {
+		v, _ := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(attrs[key]), "px"), 64)
+		return v
+	}
+	switch name {
+	case "path":
+		return parseSVGPath(attrs["d"])
+	case "line":
+		return []iconPath{{{num("x1"), num("y1")}, {num("x2"), num("y2")}}}, nil
+	case "polyline", "polygon":
+		values, err := svgNumbers(attrs["points"])
+		if err != nil {
+			return nil, err
+		}
+		var path iconPath
+		for i := 0; i+1 < len(values); i += 2 {
+			path = append(path, [2]float64{values[i], values[i+1]})
+		}
+		if name == "polygon" && len(path) > 0 {
+			path = append(path, path[0])
+		}
+		return []iconPath{path}, nil
+	case "rect":
+		x, y, w, h := num("x"), num("y"), num("width"), num("height")
+		return []iconPath{{{x, y}, {x + w, y}, {x + w, y + h}, {x, y + h}, {x, y}}}, nil
+	case "circle":
+		return []iconPath{ellipsePath(num("cx"), num("cy"), num("r"), num("r"))}, nil
+	case "ellipse":
+		return []iconPath{ellipsePath(num("cx"), num("cy"), num("rx"), num("ry"))}, nil
+	}
+	return nil, nil
+}
+
+func ellipsePath(cx, cy, rx, ry float64) iconPath {
+	path := make(iconPath, 0, 4*curveSteps+1)
+	for i := 0; i <= 4*curveSteps; i++ {
+		a := 2 * math.Pi * float64(i) / float64(4*curveSteps)
+		path = append(path, [2]float64{cx + rx*math.Cos(a), cy + ry*math.Sin(a)})
+	}
+	return path
+}
+
+// parseSVGPath flattens path data into polylines. Curves and arcs are
+// approximated by straight segments.
+func parseSVGPath(d string) ([]iconPath, error) {
+	s := &svgPathScanner{data: d}
+	var paths []iconPath
+	var current iconPath
+	var pos, start, lastCtrl [2]float64
+	var cmd, prev byte
+	flush := func() {
+		if len(current) > 1 {
+			paths = append(paths, current)
+		}
+		current = nil
+	}
+	lineTo := func(p [2]float64) {
+		if len(current) == 0 {
+			current = iconPath{pos}
+		}
+		current = append(current, p)
+		pos = p
+	}
+	for {
+		if c, ok := s.command(); ok {
+			cmd = c
+		} else if s.done() {
+			break
+		} else if cmd == 0 {
+			return nil, fmt.Errorf("expected a command at offset %d in path data", s.pos)
+		}
+		rel := cmd >= 'a'
+		abs := func(p [2]float64) [2]float64 {
+			if rel {
+				return [2]float64{pos[0] + p[0], pos[1] + p[1]}
+			}
+			return p
+		}
+		upper := cmd &^ 0x20
+		switch upper {
+		case 'Z':
+			if len(current) > 0 {
+				lineTo(start)
+			}
+			flush()
+			pos = start
+			prev = 'Z'
+			cmd = 0
+			continue
+		case 'M':
+			p, err := s.point()
+			if err != nil {
+				return nil, err
+			}
+			flush()
+			pos = abs(p)
+			start = pos
+			// Further coordinate pairs are implicit line-tos.
+			cmd = 'L' | (cmd & 0x20)
+		case 'L':
+			p, err := s.point()
+			if err != nil {
+				return nil, err
+			}
+			lineTo(abs(p))
+		case 'H', 'V':
+			v, err := s.number()
+			if err != nil {
+				return nil, err
+			}
+			p := pos
+			i := 0
+			if upper == 'V' {
+				i = 1
+			}
+			if rel {
+				p[i] += v
+			} else {
+				p[i] = v
+			}
+			lineTo(p)
+		case 'C', 'S', 'Q', 'T':
+			var ctrl [][2]float64
+			n := map[byte]int{'C': 3, 'S': 2, 'Q': 2, 'T': 1}[upper]
+			for i := 0; i < n; i++ {
+				p, err := s.point()
+				if err != nil {
+					return nil, err
+				}
+				ctrl = append(ctrl, abs(p))
+			}
+			// S and T start with the reflection of the previous control point.
+			if upper == 'S' || upper == 'T' {
+				reflected := pos
+				if (upper == 'S' && (prev == 'C' || prev == 'S')) || (upper == 'T' && (prev == 'Q' || prev == 'T')) {
+					reflected = [2]float64{2*pos[0] - lastCtrl[0], 2*pos[1] - lastCtrl[1]}
+				}
+				ctrl = append([][2]float64{reflected}, ctrl...)
+			}
+			points := append([][2]float64{pos}, ctrl...)
+			lastCtrl = points[len(points)-2]
+			for i := 1; i <= curveSteps; i++ {
+				lineTo(bezierPoint(points, float64(i)/curveSteps))
+			}
+		case 'A':
+			values := make([]float64, 7)
+			for i := range values {
+				var err error
+				if i == 3 || i == 4 {
+					values[i], err = s.flag()
+				} else {
+					values[i], err = s.number()
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+			end := abs([2]float64{values[5], values[6]})
+			for _, p := range arcPoints(pos, end, values[0], values[1], values[2], values[3] != 0, values[4] != 0) {
+				lineTo(p)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported path command %q", cmd)
+		}
+		prev = upper
+	}
+	flush()
+	return paths, nil
+}
+
+// bezierPoint evaluates a Bézier curve of any degree at t.
+func bezierPoint(points [][2]float64, t float64) [2]float64 {
+	p := append([][2]float64(nil), points...)
+	for n := len(p) - 1; n > 0; n-- {
+		for i := 0; i < n; i++ {
+			p[i] = [2]float64{p[i][0] + (p[i+1][0]-p[i][0])*t, p[i][1] + (p[i+1][1]-p[i][1])*t}
+		}
+	}
+	return p[0]
+}
+
+// arcPoints converts an SVG elliptical arc from its endpoint form to points,
+// following the SVG implementation notes.
+func arcPoints(from, to [2]float64, rx, ry, rotation float64, large, sweep bool) [][2]float64 {
+	rx, ry = math.Abs(rx), math.Abs(ry)
+	if rx == 0 || ry == 0 || from == to {
+		return [][2]float64{to}
+	}
+	phi := rotation * math.Pi / 180
+	cos, sin := math.Cos(phi), math.Sin(phi)
+	dx, dy := (from[0]-to[0])/2, (from[1]-to[1])/2
+	x1 := cos*dx + sin*dy
+	y1 := -sin*dx + cos*dy
+	if lambda := x1*x1/(rx*rx) + y1*y1/(ry*ry); lambda > 1 {
+		rx *= math.Sqrt(lambda)
+		ry *= math.Sqrt(lambda)
+	}
+	num := rx*rx*ry*ry - rx*rx*y1*y1 - ry*ry*x1*x1
+	den := rx*rx*y1*y1 + ry*ry*x1*x1
+	coef := math.Sqrt(math.Max(0, num/den))
+	if large == sweep {
+		coef = -coef
+	}
+	cx1 := coef * rx * y1 / ry
+	cy1 := -coef * ry * x1 / rx
+	cx := cos*cx1 - sin*cy1 + (from[0]+to[0])/2
+	cy := sin*cx1 + cos*cy1 + (from[1]+to[1])/2
+
+	angle := func(ux, uy float64) float64 { return math.Atan2(uy, ux) }
+	theta := angle((x1-cx1)/rx, (y1-cy1)/ry)
+	delta := angle((-x1-cx1)/rx, (-y1-cy1)/ry) - theta
+	if sweep && delta < 0 {
+		delta += 2 * math.Pi
+	} else if !sweep && delta > 0 {
+		delta -= 2 * math.Pi
+	}
+
+	steps := maxInt(2, int(math.Ceil(math.Abs(delta)/(math.Pi/2)*curveSteps)))
+	points := make([][2]float64, 0, steps)
+	for i := 1; i <= steps; i++ {
+		a := theta + delta*float64(i)/float64(steps)
+		x, y := rx*math.Cos(a), ry*math.Sin(a)
+		points = append(points, [2]float64{cos*x - sin*y + cx, sin*x + cos*y + cy})
+	}
+	points[len(points)-1] = to
+	return points
+}
+
+// svgPathScanner reads commands and numbers from SVG path data.
+type svgPathScanner struct {
+	data string
+	pos  int
+}
+
+func (s *svgPathScanner) skip() {
+	for s.pos < len(s.data) && strings.IndexByte(" \t\r\n,", s.data[s.pos]) >= 0 {
+		s.pos++
+	}
+}
+
+func (s *svgPathScanner) done() bool {
+	s.skip()
+	return s.pos >= len(s.data)
+}
+
+func (s *svgPathScanner) command() (byte, bool) {
+	s.skip()
+	if s.pos < len(s.data) && strings.IndexByte("MmLlHhVvCcSsQqTtAaZz", s.data[s.pos]) >= 0 {
+		s.pos++
+		return s.data[s.pos-1], true
+	}
+	if s.pos < len(s.data) && isLetter(s.data[s.pos]) {
+		// Report unknown commands instead of failing to read a number.
+		s.pos++
+		return s.data[s.pos-1], true
+	}
+	return 0, false
+}
+
+func (s *svgPathScanner) number() (float64, error) {
+	s.skip()
+	start := s.pos
+	if s.pos < len(s.data) && (s.data[s.pos] == '-' || s.data[s.pos] == '+') {
+		s.pos++
+	}
+	dot := false
+	for s.pos < len(s.data) {
+		c := s.data[s.pos]
+		switch {
+		case c >= '0' && c <= '9':
+		case c == '.' && !dot:
+			dot = true
+		case (c == 'e' || c == 'E') && s.pos > start:
+			s.pos++
+			if s.pos < len(s.data) && (s.data[s.pos] == '-' || s.data[s.pos] == '+') {
+				s.pos++
+			}
+			continue
+		default:
+			return s.parse(start)
+		}
+		s.pos++
+	}
+	return s.parse(start)
+}
+
+func (s *svgPathScanner) parse(start int) (float64, error) {
+	v, err := strconv.ParseFloat(s.data[start:s.pos], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number at offset %d in path data", start)
+	}
+	return v, nil
+}
+
+// flag reads an arc flag, which may be written without a separator.
+func (s *svgPathScanner) flag() (float64, error) {
+	s.skip()
+	if s.pos < len(s.data) && (s.data[s.pos] == '0' || s.data[s.pos] == '1') {
+		s.pos++
+		return float64(s.data[s.pos-1] - '0'), nil
+	}
+	return 0, fmt.Errorf("invalid arc flag at offset %d in path data", s.pos)
+}
+
+func (s *svgPathScanner) point() ([2]float64, error) {
+	x, err := s.number()
+	if err != nil {
+		return [2]float64{}, err
+	}
+	y, err := s.number()
+	return [2]float64{x, y}, err
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func svgNumbers(value string) ([]float64, error) {
+	s := &svgPathScanner{data: value}
+	var out []float64
+	for !s.done() {
+		v, err := s.number()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func pathBounds(paths []iconPath) []float64 {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, path := range paths {
+		for _, p := range path {
+			minX, maxX = math.Min(minX, p[0]), math.Max(maxX, p[0])
+			minY, maxY = math.Min(minY, p[1]), math.Max(maxY, p[1])
+		}
+	}
+	return []float64{minX, minY, math.Max(maxX-minX, 1e-9), math.Max(maxY-minY, 1e-9)}
+}
+
+// normalizePaths scales the view box into the unit square, keeping the
+// aspect ratio and centring the shorter side.
+func normalizePaths(paths []iconPath, box []float64) []iconPath {
+	scale := 1 / math.Max(box[2], box[3])
+	offX := (1 - box[2]*scale) / 2
+	offY := (1 - box[3]*scale) / 2
+	out := make([]iconPath, len(paths))
+	for i, path := range paths {
+		out[i] = make(iconPath, len(path))
+		for j, p := range path {
+			out[i][j] = [2]float64{offX + (p[0]-box[0])*scale, offY + (p[1]-box[1])*scale}
+		}
+	}
+	return out
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/image/font"
+)
+
+const defaultTemplateName = "default"
+
+// labelTemplate is a declarative layout loaded from a JSON or YAML file.
+// Coordinates are in pixels relative to the template's own Width/Height and
+// are scaled when a request asks for a different label size.
+type labelTemplate struct {
+	Name     string            `json:"name"`
+	Width    int               `json:"width"`
+	Height   int               `json:"height"`
+	Elements []templateElement `json:"elements"`
+}
+
+type templateElement struct {
+	Type      string  `json:"type"`
+	X         int     `json:"x"`
+	Y         int     `json:"y"`
+	X2        int     `json:"x2"`
+	Y2        int     `json:"y2"`
+	Width     int     `json:"width"`
+	Height    int     `json:"height"`
+	Size      int     `json:"size"`
+	Field     string  `json:"field"`
+	Text      string  `json:"text"`
+	Font      string  `json:"font"`
+	FontSize  float64 `json:"fontSize"`
+	Align     string  `json:"align"`
+	Thickness int     `json:"thickness"`
+	Fill      bool    `json:"fill"`
+	Symbology string  `json:"symbology"`
+	Icon      string  `json:"icon"`
+}
+
+var labelTemplates = map[string]*labelTemplate{}
+
+func loadTemplates(dir string) error {
+	if dir == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if ext != ".json" && ext != ".yaml" && ext != ".yml" {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		tpl, err := parseTemplate(data, ext != ".json")
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if tpl.Name == "" {
+			tpl.Name = strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		}
+		key := strings.ToLower(tpl.Name)
+		if key == defaultTemplateName {
+			return fmt.Errorf("%s: template name %q is reserved", path, tpl.Name)
+		}
+		if _, exists := labelTemplates[key]; exists {
+			return fmt.Errorf("%s: duplicate template name %q", path, tpl.Name)
+		}
+		labelTemplates[key] = tpl
+		logInfo("loaded template %q from %s (%d elements)", tpl.Name, path, len(tpl.Elements))
+	}
+	return nil
+}
+
+func parseTemplate(data []byte, isYAML bool) (*labelTemplate, error) {
+	if isYAML {
+		tree, err := parseYAML(data)
+		if err != nil {
+			return nil, err
+		}
+		data, err = json.Marshal(tree)
+		if err != nil {
+			return nil, err
+		}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	var tpl labelTemplate
+	if err := decoder.Decode(&tpl); err != nil {
+		return nil, err
+	}
+	if err := tpl.validate(); err != nil {
+		return nil, err
+	}
+	return &tpl, nil
+}
+
+func (t *labelTemplate) validate() error {
+	if t.Width < 0 || t.Height < 0 {
+		return errors.New("template size must not be negative")
+	}
+	if len(t.Elements) == 0 {
+		return errors.New("template has no elements")
+	}
+	for i, el := range t.Elements {
+		if err := el.validate(); err != nil {
+			return fmt.Errorf("element %d (%s): %w", i, el.Type, err)
+		}
+	}
+	return nil
+}
+
+func (el templateElement) validate() error {
+	if el.Field != "" {
+		if _, ok := templateFields[strings.ToLower(el.Field)]; !ok {
+			return fmt.Errorf("unknown field %q", el.Field)
+		}
+	}
+	switch el.Type {
+	case "text":
+		if el.Field == "" && el.Text == "" {
+			return errors.New("text element needs a field or text")
+		}
+		if el.Font != "" {
+			if _, ok := lookupFont(el.Font); !ok {
+				return errUnknownFont(el.Font)
+			}
+		}
+		if _, ok := parseAlign(el.Align); !ok {
+			return fmt.Errorf("unknown align %q", el.Align)
+		}
+	case "qr":
+		if el.Size < 0 || el.Width < 0 {
+			return errors.New("qr size must not be negative")
+		}
+	case "icon":
+		if el.Width <= 0 || el.Height <= 0 {
+			return errors.New("icon needs width and height")
+		}
+		if el.Icon != "" {
+			if _, ok := lookupIcon(el.Icon); !ok {
+				return errUnknownIcon(el.Icon)
+			}
+		}
+	case "barcode":
+		if el.Width <= 0 || el.Height <= 0 {
+			return errors.New("barcode needs width and height")
+		}
+		if _, ok := lookupSymbology(firstNonEmpty(el.Symbology, symbologyCode128)); !ok {
+			return fmt.Errorf("unknown symbology %q", el.Symbology)
+		}
+	case "line":
+	case "box":
+		if el.Width <= 0 || el.Height <= 0 {
+			return errors.New("box needs width and height")
+		}
+	default:
+		return fmt.Errorf("unknown element type %q", el.Type)
+	}
+	return nil
+}
+
+func lookupTemplate(name string) (*labelTemplate, bool) {
+	tpl, ok := labelTemplates[strings.ToLower(strings.TrimSpace(name))]
+	return tpl, ok
+}
+
+func templateNames() []string {
+	names := []string{defaultTemplateName}
+	for _, tpl := range labelTemplates {
+		names = append(names, tpl.Name)
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+func errUnknownTemplate(name string) error {
+	return fmt.Errorf("unknown template %q (available: %s)", name, strings.Join(templateNames(), ", "))
+}
+
+var templateFields = map[string]func(labelParams) string{
+	"title":     func(p labelParams) string { return p.titleText },
+	"secondary": func(p labelParams) string { return p.secondaryText },
+	"id":        func(p labelParams) string { return p.idText },
+	"url":       func(p labelParams) string { return strings.TrimSpace(p.url) },
+	"domain":    func(p labelParams) string { return shortURLFrom(p.url) },
+}
+
+// templateValue resolves an element's bound value. Text may reference fields
+// as {title}, {secondary}, {id}, {url} or {domain}.
+func templateValue(el templateElement, params labelParams) string {
+	if el.Field != "" {
+		return strings.TrimSpace(templateFields[strings.ToLower(el.Field)](params))
+	}
+	pairs := make([]string, 0, 2*len(templateFields))
+	for name, get := range templateFields {
+		pairs = append(pairs, "{"+name+"}", get(params))
+	}
+	return strings.TrimSpace(strings.NewReplacer(pairs...).Replace(el.Text))
+}
+
+func (t *labelTemplate) layout(params labelParams) (*labelLayout, error) {
+	layout := newLabelLayout(params)
+
+	scaleX, scaleY := 1.0, 1.0
+	if t.Width > 0 {
+		scaleX = float64(params.width) / float64(t.Width)
+	}
+	if t.Height > 0 {
+		scaleY = float64(params.height) / float64(t.Height)
+	}
+	scale := math.Min(scaleX, scaleY)
+	sx := func(v int) int { return int(math.Round(float64(v) * scaleX)) }
+	sy := func(v int) int { return int(math.Round(float64(v) * scaleY)) }
+	ss := func(v int) int { return int(math.Round(float64(v) * scale)) }
+	if scaleX != 1 || scaleY != 1 {
+		logDebug("scaling template %q by %.3fx%.3f", t.Name, scaleX, scaleY)
+	}
+
+	for _, el := range t.Elements {
+		x, y := sx(el.X), sy(el.Y)
+		w, h := sx(el.Width), sy(el.Height)
+		switch el.Type {
+		case "text":
+			value := shapeArabic(templateValue(el, params))
+			if value == "" {
+				continue
+			}
+			f := params.descriptionFont
+			size := params.descriptionFontSize
+			if strings.EqualFold(el.Field, "title") {
+				f = params.titleFont
+				size = params.titleFontSize
+			}
+			if el.Font != "" {
+				f, _ = lookupFont(el.Font)
+			}
+			if el.FontSize > 0 {
+				size = el.FontSize * scale
+			}
+			if w <= 0 {
+				w = params.width - x
+			}
+			face, err := newFontFace(f, size, params.dpi)
+			if err != nil {
+				return nil, err
+			}
+			drawer := &font.Drawer{Face: face}
+			if drawer.MeasureString(value).Ceil() > w {
+				value = truncateWithEllipsis(value, w, drawer)
+			}
+			align, _ := parseAlign(el.Align)
+			if el.Align == "" {
+				align = mirrorAlign(align, value)
+			}
+			layout.addText(face, f, size, []string{value}, x, y, w, align)
+		case "qr":
+			value := params.url
+			if el.Field != "" || el.Text != "" {
+				value = templateValue(el, params)
+			}
+			if strings.TrimSpace(value) == "" {
+				continue
+			}
+			size := params.qrSize
+			if el.Size > 0 {
+				size = ss(el.Size)
+			} else if el.Width > 0 {
+				size = ss(el.Width)
+			}
+			code, err := newCodeElement(symbologyQR, value, params.codeOptions(), x, y, size, size)
+			if err != nil {
+				return nil, err
+			}
+			layout.add(code)
+		case "icon":
+			icon := params.icon
+			if el.Icon != "" {
+				icon, _ = lookupIcon(el.Icon)
+			}
+			if icon == nil {
+				continue
+			}
+			layout.add(labelElement{kind: elementIcon, x: x, y: y, width: w, height: h, icon: icon})
+		case "barcode":
+			value := params.idText
+			if el.Field != "" || el.Text != "" {
+				value = templateValue(el, params)
+			}
+			if value == "" {
+				continue
+			}
+			symbology, _ := lookupSymbology(firstNonEmpty(el.Symbology, symbologyCode128))
+			code, err := newCodeElement(symbology, value, params.codeOptions(), x, y, w, h)
+			if err != nil {
+				return nil, fmt.Errorf("barcode %q: %w", value, err)
+			}
+			layout.add(code)
+		case "line":
+			layout.add(labelElement{kind: elementLine, x: x, y: y, x2: sx(el.X2), y2: sy(el.Y2), thickness: maxInt(1, ss(defaultThickness(el.Thickness)))})
+		case "box":
+			layout.add(labelElement{kind: elementBox, x: x, y: y, width: w, height: h, thickness: maxInt(1, ss(defaultThickness(el.Thickness))), filled: el.Fill})
+		}
+	}
+	return layout, nil
+}
+
+func defaultThickness(value int) int {
+	if value > 0 {
+		return value
+	}
+	return 2
+}
@@ -1,39 +1,117 @@
 package main
 
 import (
+	"math"
 	"strings"
+	"unicode/utf8"
 
 	"golang.org/x/image/font"
 )
 
-func wrapTwoLines(text string, maxWidth int, drawer *font.Drawer) []string {
-	text = strings.TrimSpace(text)
-	if text == "" {
+// wrapLines breaks text at spaces into at most maxLines lines. Text left
+// over is cut off with an ellipsis on the last line, as are words wider than
+// a line.
+func wrapLines(text string, maxWidth, maxLines int, drawer *font.Drawer) []string {
+	words := strings.Fields(text)
+	if len(words) == 0 || maxWidth < 1 || maxLines < 1 {
 		return nil
 	}
-	if maxWidth < 1 {
-		return nil
+
+	var lines []string
+	for len(words) > 0 {
+		if len(lines) == maxLines-1 {
+			return append(lines, truncateWithEllipsis(strings.Join(words, " "), maxWidth, drawer))
+		}
+		line, used, _ := buildLine(words, maxWidth, drawer)
+		lines = append(lines, line)
+		words = words[used:]
 	}
+	return lines
+}
 
+// fitLines wraps text at spaces into at most maxLines lines and reports
+// whether it fits without cutting anything off.
+func fitLines(text string, maxWidth, maxLines int, drawer *font.Drawer) ([]string, bool) {
 	words := strings.Fields(text)
-	if len(words) == 0 {
-		return nil
+	var lines []string
+	for len(words) > 0 {
+		line, used, _ := buildLine(words, maxWidth, drawer)
+		if len(lines) == maxLines || used == 1 && line != words[0] {
+			return nil, false
+		}
+		lines = append(lines, line)
+		words = words[used:]
+	}
+	return lines, true
+}
+
+// fitText finds the largest font size from size down to minSize, in steps of
+// one pixel, at which text fits maxLines lines of maxWidth. If it does not
+// fit even at minSize, the text is cut off there.
+func fitText(text string, f *labelFont, size, minSize, dpi float64, maxWidth, maxLines int) (font.Face, float64, []string, error) {
+	for {
+		face, err := newFontFace(f, size, dpi)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		drawer := &font.Drawer{Face: face}
+		if lines, ok := fitLines(text, maxWidth, maxLines, drawer); ok {
+			return face, size, lines, nil
+		}
+		if size <= minSize {
+			return face, size, wrapLines(text, maxWidth, maxLines, drawer), nil
+		}
+		size = math.Max(size-1, minSize)
 	}
+}
 
-	line1, idx, overflow := buildLine(words, maxWidth, drawer)
-	if idx >= len(words) || overflow && idx == 1 && line1 != "" && strings.HasSuffix(line1, "...") {
-		return []string{line1}
+// wrapText breaks text into as many lines as needed to fit maxWidth. Words
+// wider than a line are split between runes instead of being truncated.
+func wrapText(text string, maxWidth int, drawer *font.Drawer) []string {
+	words := strings.Fields(text)
+	if len(words) == 0 || maxWidth < 1 {
+		return nil
 	}
 
-	line2, idx2, overflow2 := buildLine(words[idx:], maxWidth, drawer)
-	if line2 == "" {
-		return []string{line1}
+	var lines []string
+	line := ""
+	for _, word := range words {
+		candidate := word
+		if line != "" {
+			candidate = line + " " + word
+		}
+		if drawer.MeasureString(candidate).Ceil() <= maxWidth {
+			line = candidate
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+		line = word
+		for drawer.MeasureString(line).Ceil() > maxWidth {
+			head := splitToWidth(line, maxWidth, drawer)
+			lines = append(lines, head)
+			line = line[len(head):]
+		}
 	}
-	if idx2 < len(words[idx:]) || overflow2 {
-		line2 = ensureEllipsis(line2, maxWidth, drawer)
+	if line != "" {
+		lines = append(lines, line)
 	}
+	return lines
+}
 
-	return []string{line1, line2}
+// splitToWidth returns the longest prefix of text that fits maxWidth, but
+// always at least one rune so callers make progress.
+func splitToWidth(text string, maxWidth int, drawer *font.Drawer) string {
+	end := 0
+	for i, r := range text {
+		next := i + utf8.RuneLen(r)
+		if end > 0 && drawer.MeasureString(text[:next]).Ceil() > maxWidth {
+			break
+		}
+		end = next
+	}
+	return text[:end]
 }
 
 func buildLine(words []string, maxWidth int, drawer *font.Drawer) (string, int, bool) {
@@ -55,14 +133,53 @@ func buildLine(words []string, maxWidth int, drawer *font.Drawer) (string, int,
 	return line, len(words), false
 }
 
-func ensureEllipsis(line string, maxWidth int, drawer *font.Drawer) string {
-	if strings.HasSuffix(line, "...") {
-		if drawer.MeasureString(line).Ceil() <= maxWidth {
-			return line
-		}
-		return truncateWithEllipsis(strings.TrimSuffix(line, "..."), maxWidth, drawer)
+// fieldRowTexts formats custom fields as "Name: Value" rows.
+func fieldRowTexts(fields []labelField) []string {
+	rows := make([]string, len(fields))
+	for i, f := range fields {
+		rows[i] = shapeArabic(f.name + ": " + f.value)
+	}
+	return rows
+}
+
+// fitFieldRows cuts every row to maxWidth and keeps the first maxRows rows.
+func fitFieldRows(rows []string, maxRows, maxWidth int, drawer *font.Drawer) []string {
+	maxRows = maxInt(maxRows, 0)
+	if len(rows) > maxRows {
+		logDebug("dropping %d of %d field rows (no available space)", len(rows)-maxRows, len(rows))
+		rows = rows[:maxRows]
+	}
+	out := make([]string, len(rows))
+	for i, row := range rows {
+		out[i] = truncateWithEllipsis(row, maxWidth, drawer)
+	}
+	return out
+}
+
+// wrapBlock word-wraps every line of text to maxWidth. If that takes more
+// than maxLines lines (0 means no limit), the rest is dropped and the last
+// line kept ends with an ellipsis.
+func wrapBlock(text string, maxWidth, maxLines int, drawer *font.Drawer) []string {
+	var lines []string
+	for _, paragraph := range splitNonEmptyLines(text) {
+		lines = append(lines, wrapText(paragraph, maxWidth, drawer)...)
+	}
+	if maxLines <= 0 || len(lines) <= maxLines {
+		return lines
+	}
+	lines = lines[:maxLines]
+	lines[maxLines-1] = appendEllipsis(lines[maxLines-1], maxWidth, drawer)
+	return lines
+}
+
+// appendEllipsis marks line as cut off, dropping runes from its end until
+// the ellipsis fits.
+func appendEllipsis(line string, maxWidth int, drawer *font.Drawer) string {
+	runes := []rune(line)
+	for len(runes) > 0 && drawer.MeasureString(string(runes)+"...").Ceil() > maxWidth {
+		runes = runes[:len(runes)-1]
 	}
-	return truncateWithEllipsis(line, maxWidth, drawer)
+	return strings.TrimRight(string(runes), " ") + "..."
 }
 
 func truncateWithEllipsis(text string, maxWidth int, drawer *font.Drawer) string {
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"math"
 	"os"
 	"strconv"
 	"strings"
 	"time"
+
+	"golang.org/x/image/math/fixed"
 )
 
 func envString(key, fallback string) string {
@@ -68,3 +71,21 @@ func minInt(a, b int) int {
 	}
 	return b
 }
+
+func clampInt(value, lo, hi int) int {
+	if value < lo {
+		return lo
+	}
+	if value > hi {
+		return hi
+	}
+	return value
+}
+
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(math.Round(v*1000)/1000, 'f', -1, 64)
+}
+
+func formatFixed(v fixed.Int26_6) string {
+	return formatNumber(float64(v) / 64)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type yamlLine struct {
+	number  int
+	indent  int
+	content string
+}
+
+// parseYAML decodes the small YAML subset used by template files: block
+// mappings, block sequences, flow sequences of scalars, plain and quoted
+// scalars, and comments. The result only contains types that encoding/json
+// can marshal, so callers can round-trip it into a tagged struct.
+func parseYAML(data []byte) (interface{}, error) {
+	var lines []yamlLine
+	for i, raw := range strings.Split(string(data), "\n") {
+		raw = strings.TrimRight(stripYAMLComment(raw), " \t\r")
+		trimmed := strings.TrimLeft(raw, " ")
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("yaml line %d: tabs are not allowed for indentation", i+1)
+		}
+		lines = append(lines, yamlLine{number: i + 1, indent: len(raw) - len(trimmed), content: trimmed})
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	pos := 0
+	value, err := parseYAMLNode(lines, &pos, lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if pos < len(lines) {
+		return nil, fmt.Errorf("yaml line %d: unexpected indentation", lines[pos].number)
+	}
+	return value, nil
+}
+
+func parseYAMLNode(lines []yamlLine, pos *int, indent int) (interface{}, error) {
+	if isYAMLSequenceItem(lines[*pos].content) {
+		return parseYAMLSequence(lines, pos, indent)
+	}
+	return parseYAMLMapping(lines, pos, indent)
+}
+
+func parseYAMLSequence(lines []yamlLine, pos *int, indent int) (interface{}, error) {
+	out := []interface{}{}
+	for *pos < len(lines) && lines[*pos].indent == indent && isYAMLSequenceItem(lines[*pos].content) {
+		line := lines[*pos]
+		rest := strings.TrimLeft(strings.TrimPrefix(line.content, "-"), " ")
+		if rest == "" {
+			*pos++
+			if *pos >= len(lines) || lines[*pos].indent <= indent {
+				out = append(out, nil)
+				continue
+			}
+			value, err := parseYAMLNode(lines, pos, lines[*pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, value)
+			continue
+		}
+		if _, _, ok := splitYAMLKey(rest); ok || isYAMLSequenceItem(rest) {
+			// "- key: value" starts a nested block indented to the key.
+			lines[*pos] = yamlLine{number: line.number, indent: line.indent + len(line.content) - len(rest), content: rest}
+			value, err := parseYAMLNode(lines, pos, lines[*pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, value)
+			continue
+		}
+		value, err := parseYAMLScalar(rest, line.number)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, value)
+		*pos++
+	}
+	return out, nil
+}
+
+func parseYAMLMapping(lines []yamlLine, pos *int, indent int) (interface{}, error) {
+	out := map[string]interface{}{}
+	for *pos < len(lines) && lines[*pos].indent == indent {
+		line := lines[*pos]
+		if isYAMLSequenceItem(line.content) {
+			return nil, fmt.Errorf("yaml line %d: unexpected sequence item", line.number)
+		}
+		key, rest, ok := splitYAMLKey(line.content)
+		if !ok {
+			return nil, fmt.Errorf("yaml line %d: expected \"key: value\"", line.number)
+		}
+		if _, exists := out[key]; exists {
+			return nil, fmt.Errorf("yaml line %d: duplicate key %q", line.number, key)
+		}
+		*pos++
+		if rest != "" {
+			value, err := parseYAMLScalar(rest, line.number)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = value
+			continue
+		}
+		if *pos < len(lines) {
+			next := lines[*pos]
+			if next.indent > indent || (next.indent == indent && isYAMLSequenceItem(next.content)) {
+				value, err := parseYAMLNode(lines, pos, next.indent)
+				if err != nil {
+					return nil, err
+				}
+				out[key] = value
+				continue
+			}
+		}
+		out[key] = nil
+	}
+	if *pos < len(lines) && lines[*pos].indent > indent {
+		return nil, fmt.Errorf("yaml line %d: unexpected indentation", lines[*pos].number)
+	}
+	return out, nil
+}
+
+func isYAMLSequenceItem(content string) bool {
+	return content == "-" || strings.HasPrefix(content, "- ")
+}
+
+func splitYAMLKey(content string) (string, string, bool) {
+	if strings.HasPrefix(content, "\"") || strings.HasPrefix(content, "'") {
+		end := strings.IndexByte(content[1:], content[0])
+		if end < 0 {
+			return "", "", false
+		}
+		key := content[1 : end+1]
+		rest := content[end+2:]
+		if rest != ":" && !strings.HasPrefix(rest, ": ") {
+			return "", "", false
+		}
+		return key, strings.TrimSpace(rest[1:]), true
+	}
+	idx := strings.Index(content, ": ")
+	if idx < 0 {
+		if strings.HasSuffix(content, ":") {
+			return strings.TrimSpace(content[:len(content)-1]), "", true
+		}
+		return "", "", false
+	}
+	return strings.TrimSpace(content[:idx]), strings.TrimSpace(content[idx+2:]), true
+}
+
+func parseYAMLScalar(value string, lineNumber int) (interface{}, error) {
+	switch {
+	case strings.HasPrefix(value, "\""):
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return nil, fmt.Errorf("yaml line %d: invalid quoted string", lineNumber)
+		}
+		return unquoted, nil
+	case strings.HasPrefix(value, "'"):
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return nil, fmt.Errorf("yaml line %d: invalid quoted string", lineNumber)
+		}
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'"), nil
+	case strings.HasPrefix(value, "["):
+		if !strings.HasSuffix(value, "]") {
+			return nil, fmt.Errorf("yaml line %d: unterminated flow sequence", lineNumber)
+		}
+		out := []interface{}{}
+		inner := strings.TrimSpace(value[1 : len(value)-1])
+		if inner == "" {
+			return out, nil
+		}
+		for _, item := range strings.Split(inner, ",") {
+			parsed, err := parseYAMLScalar(strings.TrimSpace(item), lineNumber)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, parsed)
+		}
+		return out, nil
+	case strings.HasPrefix(value, "{"), strings.HasPrefix(value, "|"), strings.HasPrefix(value, ">"),
+		strings.HasPrefix(value, "&"), strings.HasPrefix(value, "*"), strings.HasPrefix(value, "!"):
+		return nil, fmt.Errorf("yaml line %d: unsupported yaml syntax", lineNumber)
+	}
+
+	switch value {
+	case "~", "null", "Null", "NULL":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	if parsed, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return parsed, nil
+	}
+	if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+		return parsed, nil
+	}
+	return value, nil
+}
+
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" :-[,", line[i-1]) >= 0):
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want interface{}
+	}{
+		{
+			name: "scalars",
+			in:   "name: shelf\nwidth: 400\nscale: 1.5\nbold: true\nempty: ~\n",
+			want: map[string]interface{}{"name": "shelf", "width": int64(400), "scale": 1.5, "bold": true, "empty": nil},
+		},
+		{
+			name: "nested maps",
+			in:   "outer:\n  inner:\n    value: 1\n  other: x\nnext: y\n",
+			want: map[string]interface{}{
+				"outer": map[string]interface{}{
+					"inner": map[string]interface{}{"value": int64(1)},
+					"other": "x",
+				},
+				"next": "y",
+			},
+		},
+		{
+			name: "sequence of mappings",
+			in:   "elements:\n  - type: text\n    x: 10\n  - type: qr\n    size: 80\n",
+			want: map[string]interface{}{"elements": []interface{}{
+				map[string]interface{}{"type": "text", "x": int64(10)},
+				map[string]interface{}{"type": "qr", "size": int64(80)},
+			}},
+		},
+		{
+			name: "sequence at key indent",
+			in:   "elements:\n- type: line\n- type: box\n",
+			want: map[string]interface{}{"elements": []interface{}{
+				map[string]interface{}{"type": "line"},
+				map[string]interface{}{"type": "box"},
+			}},
+		},
+		{
+			name: "nested sequences and flow sequence",
+			in:   "rows:\n  - - 1\n    - 2\n  - [a, \"b\", 3]\n",
+			want: map[string]interface{}{"rows": []interface{}{
+				[]interface{}{int64(1), int64(2)},
+				[]interface{}{"a", "b", int64(3)},
+			}},
+		},
+		{
+			name: "quoted and colon-containing scalars",
+			in: "url: https://example.com/item/1\ntime: 12:30\n" +
+				"double: \"a: b # not a comment\"\nsingle: 'it''s'\n\"quoted key\": v\nescaped: \"tab\\there\"\n",
+			want: map[string]interface{}{
+				"url":        "https://example.com/item/1",
+				"time":       "12:30",
+				"double":     "a: b # not a comment",
+				"single":     "it's",
+				"quoted key": "v",
+				"escaped":    "tab\there",
+			},
+		},
+		{
+			name: "comments",
+			in:   "# header\n---\nname: a#b # trailing\n\n  # indented comment\nsize: 3\t# after tab\n",
+			want: map[string]interface{}{"name": "a#b", "size": int64(3)},
+		},
+		{
+			name: "tab inside value",
+			in:   "text: a\tb\n",
+			want: map[string]interface{}{"text": "a\tb"},
+		},
+		{
+			name: "empty document",
+			in:   "# nothing\n",
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseYAML([]byte(tt.in))
+			if err != nil {
+				t.Fatalf("parseYAML: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseYAML = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"tab indentation", "a:\n\tb: 1\n", "yaml line 2: tabs are not allowed"},
+		{"space then tab indentation", "a:\n  \tb: 1\n", "yaml line 2: tabs are not allowed"},
+		{"missing colon", "a: 1\nplain\n", "yaml line 2: expected \"key: value\""},
+		{"duplicate key", "a: 1\n# c\na: 2\n", "yaml line 3: duplicate key \"a\""},
+		{"bad indentation", "a: 1\n    b: 2\n", "yaml line 2: unexpected indentation"},
+		{"sequence in mapping", "a: 1\n- b\n", "yaml line 2: unexpected sequence item"},
+		{"unterminated double quote", "a: \"open\n", "yaml line 1: invalid quoted string"},
+		{"unterminated single quote", "a: b\nc: 'open\n", "yaml line 2: invalid quoted string"},
+		{"unterminated flow sequence", "a: [1, 2\n", "yaml line 1: unterminated flow sequence"},
+		{"flow mapping", "a: {b: 1}\n", "yaml line 1: unsupported yaml syntax"},
+		{"block scalar", "a: |\n  text\n", "yaml line 1: unsupported yaml syntax"},
+		{"anchor", "a: &x 1\n", "yaml line 1: unsupported yaml syntax"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseYAML([]byte(tt.in))
+			if err == nil {
+				t.Fatalf("parseYAML succeeded, want error %q", tt.want)
+			}
+			if !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("error = %q, want prefix %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTemplateYAML(t *testing.T) {
+	in := `# Shelf label
+name: shelf
+width: 400
+height: 200
+elements:
+  - type: text
+    field: title
+    x: 8
+    y: 8
+    fontSize: 28
+  - type: qr
+    x: 250
+    y: 40
+    size: 140
+`
+	tpl, err := parseTemplate([]byte(in), true)
+	if err != nil {
+		t.Fatalf("parseTemplate: %v", err)
+	}
+	if tpl.Name != "shelf" || tpl.Width != 400 || tpl.Height != 200 || len(tpl.Elements) != 2 {
+		t.Fatalf("template = %+v", tpl)
+	}
+	if el := tpl.Elements[0]; el.Type != "text" || el.Field != "title" || el.FontSize != 28 {
+		t.Errorf("first element = %+v", el)
+	}
+	if el := tpl.Elements[1]; el.Type != "qr" || el.X != 250 || el.Size != 140 {
+		t.Errorf("second element = %+v", el)
+	}
+}
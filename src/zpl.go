@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// encodeZPL writes the layout as a ZPL II program. Text and codes use the
+// printer's native commands; the icon is sent as a ^GFA graphic. Width and
+// Height are printer dots, so Dpi should match the printer resolution.
+//
+// Rotation by 180 degrees turns the whole label with ^POI. For 90 and 270
+// degrees every field is turned on its own: its box is mapped onto the
+// physical label, which is where ^FO puts the field's upper-left corner.
+func encodeZPL(layout *labelLayout) ([]byte, error) {
+	width, height := layout.outputSize()
+	var b bytes.Buffer
+	b.WriteString("^XA\n^CI28\n")
+	fmt.Fprintf(&b, "^FX %dx%d dots, %sx%s in at %s dpi\n", width, height,
+		formatNumber(float64(width)/layout.dpi), formatNumber(float64(height)/layout.dpi), formatNumber(layout.dpi))
+	fmt.Fprintf(&b, "^PW%d\n^LL%d\n^LH0,0\n", width, height)
+	if layout.rotation == 180 {
+		b.WriteString("^POI\n")
+	}
+
+	turned := layout.rotation == 90 || layout.rotation == 270
+	orientation := "N"
+	if turned {
+		orientation = map[int]string{90: "R", 270: "B"}[layout.rotation]
+	}
+	// origin returns the ^FO position of a field with the given layout box.
+	origin := func(x, y, w, h int) image.Point {
+		if !turned {
+			return image.Pt(x, y)
+		}
+		return layout.rotateRect(image.Rect(x, y, x+w, y+h)).Min
+	}
+
+	for _, el := range layout.elements {
+		switch el.kind {
+		case elementText:
+			ascent := el.face.Metrics().Ascent.Ceil()
+			height := maxInt(10, int(math.Round(el.fontSize)))
+			for _, line := range placeTextLines(el, layout.height) {
+				at := origin(el.x, line.baseline-ascent, maxInt(el.width, 1), height)
+				fmt.Fprintf(&b, "^FO%d,%d^A0%s,%d,0^FB%d,1,0,%s,0^FH^FD%s^FS\n",
+					at.X, at.Y, orientation, height, maxInt(el.width, 1), zplJustification(el.align), zplEscape(string(line.runes)))
+			}
+		case elementQR:
+			modules := len(el.qr.Bitmap())
+			magnification := clampInt(el.width/maxInt(modules, 1), 1, 10)
+			// Centre the symbol in its box when the magnification leaves
+			// some of it unused.
+			offset := maxInt(0, (el.width-magnification*modules)/2)
+			// ^BQ only prints upright; a QR code reads in any orientation.
+			at := origin(el.x+offset, el.y+offset, magnification*modules, magnification*modules)
+			fmt.Fprintf(&b, "^FO%d,%d^BQN,2,%d^FH^FD%sA,%s^FS\n", at.X, at.Y, magnification, zplQRLevel(el.qr.Level), zplEscape(el.data))
+		case elementIcon:
+			icon := image.NewRGBA(image.Rect(0, 0, el.width, el.height))
+			draw.Draw(icon, icon.Bounds(), &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+			drawIcon(icon, el.icon, 0, 0, el.width, el.height)
+			if turned {
+				icon = rotateImage(icon, layout.rotation)
+			}
+			at := origin(el.x, el.y, el.width, el.height)
+			fmt.Fprintf(&b, "^FO%d,%d%s^FS\n", at.X, at.Y, zplGraphic(thresholdBitmap(icon)))
+		case elementBarcode:
+			module := barcodeModuleWidth(el.modules, el.width)
+			offsetX := el.x + maxInt(0, (el.width-module*len(el.modules))/2)
+			at := origin(offsetX, el.y, module*len(el.modules), el.height)
+			switch el.symbology {
+			case symbologyCode39:
+				fmt.Fprintf(&b, "^BY%d,3^FO%d,%d^B3%s,N,%d,N,N^FH^FD%s^FS\n", module, at.X, at.Y, orientation, el.height, zplEscape(strings.ToUpper(el.data)))
+			case symbologyEAN13:
+				fmt.Fprintf(&b, "^BY%d^FO%d,%d^BE%s,%d,N,N^FD%s^FS\n", module, at.X, at.Y, orientation, el.height, el.data[:12])
+			default:
+				fmt.Fprintf(&b, "^BY%d^FO%d,%d^BC%s,%d,N,N,N,A^FH^FD%s^FS\n", module, at.X, at.Y, orientation, el.height, zplEscape(el.data))
+			}
+		case elementMatrix:
+			module, offset := matrixModule(len(el.matrix), el.width)
+			at := origin(el.x+offset, el.y+offset, module*len(el.matrix), module*len(el.matrix))
+			if el.symbology == symbologyAztec {
+				fmt.Fprintf(&b, "^FO%d,%d^BO%s,%d,N,0^FH^FD%s^FS\n", at.X, at.Y, orientation, clampInt(module, 1, 10), zplEscape(el.data))
+			} else {
+				fmt.Fprintf(&b, "^FO%d,%d^BX%s,%d,200^FH^FD%s^FS\n", at.X, at.Y, orientation, module, zplEscape(el.data))
+			}
+		case elementLine:
+			if turned {
+				// Turn the pixels at both ends of the line.
+				start, end := origin(el.x, el.y, 1, 1), origin(el.x2, el.y2, 1, 1)
+				el.x, el.y, el.x2, el.y2 = start.X, start.Y, end.X, end.Y
+			}
+			writeZPLLine(&b, el)
+		case elementBox:
+			thickness := maxInt(1, el.thickness)
+			if el.filled {
+				thickness = minInt(el.width, el.height)
+			}
+			at, w, h := origin(el.x, el.y, el.width, el.height), el.width, el.height
+			if turned {
+				w, h = h, w
+			}
+			fmt.Fprintf(&b, "^FO%d,%d^GB%d,%d,%d^FS\n", at.X, at.Y, w, h, thickness)
+		}
+	}
+
+	b.WriteString("^XZ\n")
+	return b.Bytes(), nil
+}
+
+func writeZPLLine(b *bytes.Buffer, el labelElement) {
+	thickness := maxInt(1, el.thickness)
+	x, y := minInt(el.x, el.x2), minInt(el.y, el.y2)
+	w, h := absInt(el.x2-el.x), absInt(el.y2-el.y)
+	half := thickness / 2
+	switch {
+	case h == 0:
+		fmt.Fprintf(b, "^FO%d,%d^GB%d,%d,%d^FS\n", x, y-half, w+thickness, thickness, thickness)
+	case w == 0:
+		fmt.Fprintf(b, "^FO%d,%d^GB%d,%d,%d^FS\n", x-half, y, thickness, h+thickness, thickness)
+	default:
+		// ^GD leans right when the line rises from left to right.
+		orientation := "L"
+		if (el.x2 > el.x) != (el.y2 > el.y) {
+			orientation = "R"
+		}
+		fmt.Fprintf(b, "^FO%d,%d^GD%d,%d,%d,B,%s^FS\n", x, y, w, h, thickness, orientation)
+	}
+}
+
+// zplGraphic encodes a bitmap as an ASCII-hex ^GFA command.
+func zplGraphic(bitmap *monoBitmap) string {
+	total := len(bitmap.bits)
+	return fmt.Sprintf("^GFA,%d,%d,%d,%s", total, total, bitmap.stride, strings.ToUpper(hex.EncodeToString(bitmap.bits)))
+}
+
+func zplQRLevel(level qrcode.RecoveryLevel) string {
+	switch level {
+	case qrcode.Low:
+		return "L"
+	case qrcode.High:
+		return "Q"
+	case qrcode.Highest:
+		return "H"
+	}
+	return "M"
+}
+
+func zplJustification(align textAlign) string {
+	switch align {
+	case alignCenter:
+		return "C"
+	case alignRight:
+		return "R"
+	}
+	return "L"
+}
+
+// zplEscape hex-encodes the characters ZPL treats as commands; fields are
+// emitted with ^FH so the printer decodes them again.
+func zplEscape(value string) string {
+	return strings.NewReplacer("_", "_5F", "^", "_5E", "~", "_7E", "\n", " ", "\r", " ").Replace(value)
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// zplFields splits a ZPL program into fields ending in ^FS, each a list of
+// commands without the caret. Commands outside fields are in the last entry.
+func zplFields(program string) [][]string {
+	var fields [][]string
+	var current []string
+	for _, command := range strings.Split(program, "^")[1:] {
+		command = strings.TrimRight(command, "\n")
+		if command == "FS" {
+			fields = append(fields, current)
+			current = nil
+			continue
+		}
+		current = append(current, command)
+	}
+	return append(fields, current)
+}
+
+// zplCommand returns the arguments of the first command with prefix in
+// commands.
+func zplCommand(commands []string, prefix string) (string, bool) {
+	for _, command := range commands {
+		if strings.HasPrefix(command, prefix) {
+			return strings.TrimPrefix(command, prefix), true
+		}
+	}
+	return "", false
+}
+
+func zplInts(t *testing.T, args string) []int {
+	t.Helper()
+	var out []int
+	for _, part := range strings.Split(args, ",") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			t.Fatalf("bad ZPL number %q in %q", part, args)
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+func renderZPL(t *testing.T, query string) (*labelLayout, string) {
+	t.Helper()
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, err := parseLabelParams(values)
+	if err != nil {
+		t.Fatalf("parseLabelParams: %v", err)
+	}
+	params.qrSnap = true
+	layout, err := layoutLabel(params)
+	if err != nil {
+		t.Fatalf("layoutLabel: %v", err)
+	}
+	if err := checkLayout(layout, outputOptions{format: formatZPL}); err != nil {
+		t.Fatalf("checkLayout: %v", err)
+	}
+	data, err := encodeZPL(layout)
+	if err != nil {
+		t.Fatalf("encodeZPL: %v", err)
+	}
+	return layout, string(data)
+}
+
+func TestEncodeZPL(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		width  int
+		height int
+		title  string
+		url    string
+		level  string
+	}{
+		{
+			name:   "defaults",
+			query:  "TitleText=Zahnstange&URL=https://inv.example/item/000-029",
+			width:  defaultWidth,
+			height: defaultHeight,
+			title:  "Zahnstange",
+			url:    "https://inv.example/item/000-029",
+			level:  "M",
+		},
+		{
+			name:   "sized with high error correction",
+			query:  "Width=400&Height=240&Dpi=203&QrSize=150&QrErrorCorrection=H&TitleText=Box_1&URL=https://x.example/item/7",
+			width:  400,
+			height: 240,
+			title:  "Box_5F1",
+			url:    "https://x.example/item/7",
+			level:  "H",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layout, program := renderZPL(t, tt.query)
+			if !strings.HasPrefix(program, "^XA\n") || !strings.HasSuffix(program, "^XZ\n") {
+				t.Fatalf("program is not wrapped in ^XA/^XZ:\n%s", program)
+			}
+			fields := zplFields(program)
+			setup := fields[0]
+			if pw, _ := zplCommand(setup, "PW"); pw != strconv.Itoa(tt.width) {
+				t.Errorf("^PW = %q, want %d", pw, tt.width)
+			}
+			if ll, _ := zplCommand(setup, "LL"); ll != strconv.Itoa(tt.height) {
+				t.Errorf("^LL = %q, want %d", ll, tt.height)
+			}
+
+			var sawTitle, sawQR, sawGraphic bool
+			for _, field := range fields {
+				origin, ok := zplCommand(field, "FO")
+				if !ok {
+					continue
+				}
+				pos := zplInts(t, origin)
+				if pos[0] < 0 || pos[1] < 0 || pos[0] >= tt.width || pos[1] >= tt.height {
+					t.Errorf("field origin %v outside the label", pos)
+				}
+				data, _ := zplCommand(field, "FD")
+				if font, ok := zplCommand(field, "A0N,"); ok && data == tt.title {
+					sawTitle = true
+					if size := zplInts(t, font); size[0] < 10 {
+						t.Errorf("title font height %d", size[0])
+					}
+					if _, ok := zplCommand(field, "FH"); !ok {
+						t.Errorf("text field without ^FH: %v", field)
+					}
+				}
+				if qr, ok := zplCommand(field, "BQN,"); ok {
+					sawQR = true
+					if want := tt.level + "A," + tt.url; data != want {
+						t.Errorf("QR data = %q, want %q", data, want)
+					}
+					args := zplInts(t, qr)
+					checkZPLQRSize(t, layout, pos, args[1])
+				}
+				if graphic, ok := zplCommand(field, "GFA,"); ok {
+					sawGraphic = true
+					checkZPLGraphic(t, graphic)
+				}
+			}
+			if !sawTitle {
+				t.Errorf("no ^A0 text field with %q:\n%s", tt.title, program)
+			}
+			if !sawQR {
+				t.Errorf("no ^BQN field:\n%s", program)
+			}
+			if !sawGraphic {
+				t.Errorf("no ^GFA icon:\n%s", program)
+			}
+		})
+	}
+}
+
+// checkZPLQRSize checks that the printed QR code covers the layout's QR box.
+func checkZPLQRSize(t *testing.T, layout *labelLayout, origin []int, magnification int) {
+	t.Helper()
+	for _, el := range layout.elements {
+		if el.kind != elementQR {
+			continue
+		}
+		size := len(el.qr.Bitmap()) * magnification
+		if size != el.width {
+			t.Errorf("QR prints at %d dots, layout box is %d", size, el.width)
+		}
+		if origin[0] != el.x || origin[1] != el.y {
+			t.Errorf("QR origin %v, layout box at (%d,%d)", origin, el.x, el.y)
+		}
+		return
+	}
+	t.Error("layout has no QR element")
+}
+
+// checkZPLGraphic checks the byte counts and hex payload of ^GFA arguments.
+func checkZPLGraphic(t *testing.T, args string) {
+	t.Helper()
+	parts := strings.SplitN(args, ",", 4)
+	if len(parts) != 4 {
+		t.Fatalf("^GFA has %d arguments", len(parts))
+	}
+	counts := zplInts(t, strings.Join(parts[:3], ","))
+	if counts[0] != counts[1] || counts[2] <= 0 || counts[0]%counts[2] != 0 {
+		t.Errorf("^GFA counts %v", counts)
+	}
+	if len(parts[3]) != 2*counts[0] {
+		t.Errorf("^GFA has %d hex digits, want %d", len(parts[3]), 2*counts[0])
+	}
+	if strings.Trim(parts[3], "0123456789ABCDEF") != "" {
+		t.Error("^GFA payload is not upper-case hex")
+	}
+	if strings.Trim(parts[3], "0") == "" {
+		t.Error("^GFA graphic is blank")
+	}
+}
+
+func TestEncodeZPLQROffset(t *testing.T) {
+	// Without snapping the box is not a multiple of the module count; the
+	// symbol is centred in it.
+	values := url.Values{"TitleText": {"A"}, "URL": {"https://x.example/item/1"}}
+	params, err := parseLabelParams(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout, err := layoutLabel(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := encodeZPL(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, el := range layout.elements {
+		if el.kind != elementQR {
+			continue
+		}
+		modules := len(el.qr.Bitmap())
+		magnification := el.width / modules
+		offset := (el.width - magnification*modules) / 2
+		want := "^FO" + strconv.Itoa(el.x+offset) + "," + strconv.Itoa(el.y+offset) + "^BQN,2," + strconv.Itoa(magnification)
+		if !strings.Contains(string(data), want) {
+			t.Errorf("missing %q in:\n%s", want, data)
+		}
+	}
+}
+
+func TestEncodeZPLRotated(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		orientation string
+		// place maps a layout box on the 240x400 logical label to the
+		// upper-left corner of the turned box on the 400x240 label.
+		place func(x, y, w, h int) (int, int)
+	}{
+		{"rotate 90", "Rotate=90", "R", func(x, y, w, h int) (int, int) { return 400 - y - h, x }},
+		{"rotate 270", "Rotate=270", "B", func(x, y, w, h int) (int, int) { return y, 240 - x - w }},
+		{"orientation", "Orientation=portrait", "R", func(x, y, w, h int) (int, int) { return 400 - y - h, x }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layout, program := renderZPL(t, "Width=400&Height=240&TitleText=Box&DescriptionText=Garage&URL=https://x.example/item/7&"+tt.query)
+			fields := zplFields(program)
+			if pw, _ := zplCommand(fields[0], "PW"); pw != "400" {
+				t.Errorf("^PW = %q, want 400", pw)
+			}
+			if ll, _ := zplCommand(fields[0], "LL"); ll != "240" {
+				t.Errorf("^LL = %q, want 240", ll)
+			}
+			if _, ok := zplCommand(fields[0], "PO"); ok {
+				t.Error("^PO set for a quarter turn")
+			}
+			raster := drawLayout(layout)
+
+			var texts, graphics int
+			for _, field := range fields {
+				origin, ok := zplCommand(field, "FO")
+				if !ok {
+					continue
+				}
+				pos := zplInts(t, origin)
+				if pos[0] < 0 || pos[1] < 0 || pos[0] >= 400 || pos[1] >= 240 {
+					t.Errorf("field origin %v outside the label", pos)
+				}
+				if font, ok := zplCommand(field, "A0"); ok {
+					texts++
+					if !strings.HasPrefix(font, tt.orientation+",") {
+						t.Errorf("text field ^A0%s, want orientation %s", font, tt.orientation)
+					}
+				}
+				if _, ok := zplCommand(field, "BQN,"); ok {
+					for _, el := range layout.elements {
+						if el.kind != elementQR {
+							continue
+						}
+						x, y := tt.place(el.x, el.y, el.width, el.height)
+						if pos[0] != x || pos[1] != y {
+							t.Errorf("QR at %v, want (%d,%d)", pos, x, y)
+						}
+						// Every corner but one of a QR code is a dark
+						// finder pattern, and the raster output has turned
+						// one of them to the field origin.
+						if r, _, _, _ := raster.At(pos[0], pos[1]).RGBA(); r != 0 {
+							t.Errorf("raster output has no QR module at the ^FO of the QR code")
+						}
+					}
+				}
+				if graphic, ok := zplCommand(field, "GFA,"); ok {
+					checkZPLGraphic(t, graphic)
+					graphics++
+					for _, el := range layout.elements {
+						if el.kind != elementIcon {
+							continue
+						}
+						x, y := tt.place(el.x, el.y, el.width, el.height)
+						if pos[0] != x || pos[1] != y {
+							t.Errorf("icon at %v, want (%d,%d)", pos, x, y)
+						}
+						counts := zplInts(t, strings.Join(strings.SplitN(graphic, ",", 4)[:3], ","))
+						if stride := (el.height + 7) / 8; counts[2] != stride || counts[0] != stride*el.width {
+							t.Errorf("icon graphic %v, want %d bytes per row for %d rows", counts, stride, el.width)
+						}
+					}
+				}
+			}
+			if texts == 0 {
+				t.Errorf("no text fields:\n%s", program)
+			}
+			if graphics != 1 {
+				t.Errorf("%d ^GFA graphics, want the icon:\n%s", graphics, program)
+			}
+		})
+	}
+}